@@ -2,9 +2,9 @@ module send
 
 go 1.24.2
 
-require github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
-
 require (
-	github.com/sigurn/crc16 v0.0.0-20240131213347-83fcde1e29d1 // indirect
-	golang.org/x/sys v0.33.0 // indirect
+	github.com/sigurn/crc16 v0.0.0-20240131213347-83fcde1e29d1
+	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
 )
+
+require golang.org/x/sys v0.33.0
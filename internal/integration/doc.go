@@ -0,0 +1,6 @@
+// Package integration 在socat创建的虚拟串口对上运行发送端和接收端程序的端到端测试，
+// 测试构建两个程序的二进制文件，各占一端，按真实部署的方式经命令行参数配置。
+// 未安装socat的环境（如本地开发机）自动跳过，CI中安装socat即可运行：
+//
+//	go test ./internal/integration/
+package integration
@@ -0,0 +1,191 @@
+//go:build linux
+
+package integration
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"send/internal/ptytest"
+)
+
+// defaultCorrelationID 发送端未指定消息时内置消息的关联ID
+const defaultCorrelationID = "78f0dd39-5e0b-4002-809d-9bae380dfec3"
+
+// buildPrograms 构建发送端和接收端的二进制文件，返回二者的路径
+func buildPrograms(t *testing.T) (string, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("集成测试需要构建程序，-short 时跳过")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("未找到go工具，无法构建被测程序")
+	}
+	dir := t.TempDir()
+	out, err := exec.Command(goTool, "build", "-o", dir, "send/send", "send/receive").CombinedOutput()
+	if err != nil {
+		t.Fatalf("构建程序失败: %v\n%s", err, out)
+	}
+	return filepath.Join(dir, "send"), filepath.Join(dir, "receive")
+}
+
+// startReceiver 在port上运行接收端，开始监听串口后返回其JSON Lines输出逐行的通道；测试结束时中断接收端
+func startReceiver(t *testing.T, receive, port string, args ...string) <-chan []byte {
+	t.Helper()
+	cmd := exec.Command(receive, append([]string{"-port", port, "-jsonl", "-discard-window", "0", "-silence-after", "1m"}, args...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("启动接收端失败: %v", err)
+	}
+
+	lines := make(chan []byte, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+
+	// 日志经标准错误输出，持续读取以免管道写满阻塞接收端
+	listening := make(chan struct{})
+	logged := make(chan string, 1)
+	go func() {
+		var log strings.Builder
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.WriteString(scanner.Text() + "\n")
+			if strings.Contains(scanner.Text(), "开始监听串口") {
+				close(listening)
+			}
+		}
+		logged <- log.String()
+	}()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+		}
+		if t.Failed() {
+			t.Logf("接收端日志:\n%s", <-logged)
+		}
+	})
+
+	select {
+	case <-listening:
+		return lines
+	case err := <-exited:
+		t.Fatalf("接收端意外退出: %v\n%s", err, <-logged)
+	case <-time.After(10 * time.Second):
+		t.Fatal("接收端未开始监听串口")
+	}
+	return nil
+}
+
+// runSender 在port上运行发送端并等待其退出，stdin非空时作为标准输入
+func runSender(t *testing.T, send, port, stdin string, args ...string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, send, append([]string{"-port", port, "-settle", "0", "-discard-window", "0"}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("发送端失败: %v\n%s", err, out)
+	}
+}
+
+// expectMessages 等待接收端依次交付want中关联ID的消息
+func expectMessages(t *testing.T, lines <-chan []byte, want ...string) {
+	t.Helper()
+	var got []string
+	timeout := time.After(10 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("接收端输出提前结束，已收到 %v，期望 %v", got, want)
+			}
+			var message Message
+			if err := json.Unmarshal(line, &message); err != nil {
+				t.Fatalf("接收端输出了无效的JSON Lines %q: %v", line, err)
+			}
+			got = append(got, message.CorrelationID)
+		case <-timeout:
+			t.Fatalf("接收端只交付了 %v，期望 %v", got, want)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("接收端交付 %v，期望 %v", got, want)
+	}
+}
+
+// Message 接收端输出的消息中测试关心的字段
+type Message struct {
+	CorrelationID string `json:"correlationID"`
+	Payload       string `json:"payload"`
+}
+
+func TestSocatSingleMessage(t *testing.T) {
+	sendPort, receivePort := ptytest.SocatPair(t)
+	send, receive := buildPrograms(t)
+	lines := startReceiver(t, receive, receivePort)
+
+	runSender(t, send, sendPort, "")
+	expectMessages(t, lines, defaultCorrelationID)
+}
+
+func TestSocatStreamAckWindow(t *testing.T) {
+	sendPort, receivePort := ptytest.SocatPair(t)
+	send, receive := buildPrograms(t)
+	lines := startReceiver(t, receive, receivePort)
+
+	var stdin strings.Builder
+	var want []string
+	for i := 1; i <= 5; i++ {
+		id := fmt.Sprintf("stream-%d", i)
+		payload := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`{"event":{"deviceName":"Device-%d"}}`, i)))
+		line, err := json.Marshal(map[string]string{"apiVersion": "v3", "correlationID": id, "payload": payload, "contentType": "application/json"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdin.Write(append(line, '\n'))
+		want = append(want, id)
+	}
+
+	runSender(t, send, sendPort, stdin.String(), "-stream", "-ack-window", "4")
+	expectMessages(t, lines, want...)
+}
+
+func TestSocatHandshake(t *testing.T) {
+	sendPort, receivePort := ptytest.SocatPair(t)
+	send, receive := buildPrograms(t)
+	lines := startReceiver(t, receive, receivePort, "-device-model", "TH-20", "-firmware", "1.4.2")
+
+	runSender(t, send, sendPort, "", "-handshake", "-allow-peer", "model=TH-*,firmware=1.4.*")
+	expectMessages(t, lines, defaultCorrelationID)
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+// Package ptytest 为串口回环测试提供伪终端：测试持有主端扮演对端，被测程序按普通串口打开从端
+package ptytest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open 创建一对伪终端，返回主端和从端的设备路径，测试结束时关闭主端
+func Open(t testing.TB) (*os.File, string) {
+	t.Helper()
+	// 以非阻塞方式打开，使主端的读写走运行时的轮询器，读超时（SetReadDeadline）才能生效
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Skipf("无法创建伪终端: %v", err)
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	t.Cleanup(func() { master.Close() })
+
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatalf("解锁伪终端失败: %v", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatalf("读取伪终端编号失败: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// ReadUntil 从主端读取，直到收到的数据包含want中的全部字符串或超过timeout，返回已收到的数据
+func ReadUntil(master *os.File, timeout time.Duration, want ...string) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	var received []byte
+	buf := make([]byte, 256)
+	for {
+		complete := true
+		for _, w := range want {
+			if !bytes.Contains(received, []byte(w)) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return received, nil
+		}
+		if err := master.SetReadDeadline(deadline); err != nil {
+			return received, err
+		}
+		n, err := master.Read(buf)
+		received = append(received, buf[:n]...)
+		if err != nil {
+			return received, fmt.Errorf("未收到 %q: %v", want, err)
+		}
+	}
+}
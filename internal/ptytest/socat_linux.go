@@ -0,0 +1,58 @@
+//go:build linux
+
+package ptytest
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// SocatPair 用socat创建一对互联的伪终端，返回两端的设备路径，测试结束时停止socat；
+// 与Open不同，两端都按普通串口打开，适合两个被测程序各占一端的集成测试。
+// 未安装socat时跳过测试
+func SocatPair(t testing.TB) (string, string) {
+	t.Helper()
+	socat, err := exec.LookPath("socat")
+	if err != nil {
+		t.Skip("未安装socat，跳过虚拟串口对测试")
+	}
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "ttyA"), filepath.Join(dir, "ttyB")
+	var stderr bytes.Buffer
+	cmd := exec.Command(socat, "-d", "-d", "pty,raw,echo=0,link="+a, "pty,raw,echo=0,link="+b)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("启动socat失败: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	t.Cleanup(func() {
+		if cmd.Process.Kill() == nil {
+			<-exited
+		}
+	})
+
+	// socat创建两端的伪终端后才建立符号链接
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, errA := os.Stat(a)
+		_, errB := os.Stat(b)
+		if errA == nil && errB == nil {
+			return a, b
+		}
+		select {
+		case err := <-exited:
+			t.Fatalf("socat意外退出: %v\n%s", err, stderr.String())
+		case <-time.After(20 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			t.Fatalf("socat未创建虚拟串口对: %v\n%s", <-exited, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package serialcomm
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 带抖动的指数退避，供重开串口、可靠发送和队列排空等重试路径共用
+type Backoff struct {
+	Base        time.Duration // 首次等待时间
+	Cap         time.Duration // 等待时间上限，0表示不限（仍不会超过time.Duration的最大值）
+	Jitter      float64       // 抖动比例（0~1），实际等待在 [d*(1-Jitter), d] 内随机
+	MaxAttempts int           // 最大重试次数，0表示不限
+	// OnAttempt 在每次重试等待前调用，便于统一记录日志或指标
+	OnAttempt func(attempt int, delay time.Duration, err error)
+
+	Clock Clock          // 为nil时使用系统时间
+	Rand  func() float64 // 返回 [0,1) 内的随机数，为nil时使用math/rand
+
+	attempt int
+}
+
+// Next 记录一次失败并返回下一次重试前的等待时间，超过最大次数时返回false
+func (b *Backoff) Next(err error) (time.Duration, bool) {
+	b.attempt++
+	if b.MaxAttempts > 0 && b.attempt > b.MaxAttempts {
+		return 0, false
+	}
+
+	limit := b.Cap
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	delay := b.Base
+	for i := 1; i < b.attempt && delay < limit; i++ {
+		if delay > limit/2 {
+			delay = limit // 再翻倍会超过上限或溢出
+			break
+		}
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	if b.Jitter > 0 {
+		random := rand.Float64
+		if b.Rand != nil {
+			random = b.Rand
+		}
+		delay -= time.Duration(random() * b.Jitter * float64(delay))
+	}
+
+	if b.OnAttempt != nil {
+		b.OnAttempt(b.attempt, delay, err)
+	}
+	return delay, true
+}
+
+// Wait 等价于Next后按返回的时间休眠
+func (b *Backoff) Wait(err error) bool {
+	delay, ok := b.Next(err)
+	if ok {
+		clock := b.Clock
+		if clock == nil {
+			clock = RealClock{}
+		}
+		clock.Sleep(delay)
+	}
+	return ok
+}
+
+// Reset 在成功后清零重试计数
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Attempts 返回当前已记录的失败次数
+func (b *Backoff) Attempts() int {
+	return b.attempt
+}
@@ -0,0 +1,50 @@
+package serialcomm
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowthAndCap(t *testing.T) {
+	b := &Backoff{Base: 100 * time.Millisecond, Cap: time.Second, MaxAttempts: 6}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		delay, ok := b.Next(nil)
+		if !ok || delay != w*time.Millisecond {
+			t.Fatalf("第%d次: 得到 %v (%v)，期望 %v", i+1, delay, ok, w*time.Millisecond)
+		}
+	}
+	if _, ok := b.Next(nil); ok {
+		t.Error("超过最大次数后仍允许重试")
+	}
+	b.Reset()
+	if delay, _ := b.Next(nil); delay != 100*time.Millisecond {
+		t.Errorf("重置后首次等待 %v，期望 %v", delay, 100*time.Millisecond)
+	}
+}
+
+func TestBackoffUncappedDoesNotOverflow(t *testing.T) {
+	b := &Backoff{Base: time.Second}
+	for i := 0; i < 100; i++ {
+		delay, ok := b.Next(nil)
+		if !ok || delay <= 0 {
+			t.Fatalf("第%d次: 等待 %v 溢出", i+1, delay)
+		}
+	}
+	if delay, _ := b.Next(nil); delay != math.MaxInt64 {
+		t.Errorf("不设上限时等待 %v，期望钳位到 %v", delay, time.Duration(math.MaxInt64))
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	b := &Backoff{Base: time.Second, Jitter: 0.5, Clock: clock, Rand: func() float64 { return 1 }}
+	start := clock.Now()
+	if !b.Wait(nil) {
+		t.Fatal("首次重试被拒绝")
+	}
+	if got := clock.Since(start); got != 500*time.Millisecond {
+		t.Errorf("抖动后等待 %v，期望 %v", got, 500*time.Millisecond)
+	}
+}
@@ -0,0 +1,518 @@
+// Package serialcomm 收发两端共用的串口链路基础：分帧编解码、错误类别、时间源和密钥提供者。
+// 收发两端必须使用完全相同的分帧实现，放在同一个包中避免两份拷贝逐渐走样
+package serialcomm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sigurn/crc16"
+)
+
+// FrameCodec 帧编解码：把帧体封装为线上字节，并从接收缓冲区中拆出完整的帧体。
+// 替换编解码即可使用其他分帧方式，而不必改动收发流程
+type FrameCodec interface {
+	// Encode 帧体超过该分帧方式能表示的长度时返回ErrProtocol类错误，调用方应改为分片发送
+	Encode(body []byte) ([]byte, error)
+	// Decode 从buffer中取出一帧的帧体；数据不足时返回ErrIncompleteFrame且不消耗buffer，
+	// 其他错误表示帧无效，调用方应丢弃缓冲区并请求重传
+	Decode(buffer *bytes.Buffer) ([]byte, error)
+}
+
+// ErrIncompleteFrame 缓冲区中的数据还不够一帧
+var ErrIncompleteFrame = errors.New("帧不完整")
+
+// frameStarter 能判断缓冲区中是否已有帧开头的分帧方式
+type frameStarter interface {
+	frameStarted(data []byte) bool
+}
+
+// FrameStarted 判断接收缓冲区中的数据是否可能是一帧的开头。为false时数据只是噪声，
+// 接收端超时后应静默丢弃而不是当作未收完的帧请求重传
+func FrameStarted(codec FrameCodec, data []byte) bool {
+	if c, ok := codec.(frameStarter); ok {
+		return c.frameStarted(data)
+	}
+	return len(data) > 0
+}
+
+// ErrForeignFrame 共享总线上发给其他站点的帧，不是错误，接收端应跳过且不回复
+var ErrForeignFrame = errors.New("其他站点的帧")
+
+// bodyLimiter 帧体长度有上限的分帧方式
+type bodyLimiter interface {
+	maxBody() int
+}
+
+// MaxBody 返回分帧方式能承载的最大帧体长度，0表示不限；发送端分片时不得超过该长度
+func MaxBody(codec FrameCodec) int {
+	if c, ok := codec.(bodyLimiter); ok {
+		return c.maxBody()
+	}
+	return 0
+}
+
+// errBodyTooLarge 帧体超过分帧方式的长度上限
+func errBodyTooLarge(length, limit int) error {
+	return ProtocolError("帧体 %d 字节超过分帧长度上限 %d 字节", length, limit)
+}
+
+// LengthCRCCodec 默认分帧：长度前缀（默认4字节大端） | 帧体 | 2字节大端CRC16-MODBUS | 换行符，
+// 长度为0的帧是保活帧。帧的边界完全由长度前缀决定，换行符只在帧尾的固定位置检查，
+// 帧体中出现0x0A不影响解析
+type LengthCRCCodec struct {
+	MaxLength uint32 // 帧体最大长度，0表示不限
+
+	// NoTerminator 不发送也不检查帧尾的换行符，双方须一致；旧版接收端要求换行符，默认保留
+	NoTerminator bool
+
+	// 长度前缀的格式，须与对端一致，如部分MCU固件使用2字节小端长度
+	LengthSize   int  // 2或4，0表示4；为2时帧体不能超过65535字节
+	LittleEndian bool // 长度前缀为小端序（CRC仍为大端序）
+}
+
+// prefixSize 长度前缀的字节数
+func (c LengthCRCCodec) prefixSize() int {
+	if c.LengthSize == 2 {
+		return 2
+	}
+	return 4
+}
+
+// byteOrder 长度前缀的字节序
+func (c LengthCRCCodec) byteOrder() binary.ByteOrder {
+	if c.LittleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// trailerSize 帧体之后的字节数：CRC和可选的换行符
+func (c LengthCRCCodec) trailerSize() int {
+	if c.NoTerminator {
+		return 2
+	}
+	return 3
+}
+
+func (c LengthCRCCodec) maxBody() int {
+	limit := int(c.MaxLength)
+	if c.prefixSize() == 2 && (limit == 0 || limit > 0xFFFF) {
+		limit = 0xFFFF
+	}
+	return limit
+}
+
+func (c LengthCRCCodec) Encode(body []byte) ([]byte, error) {
+	if limit := c.maxBody(); limit > 0 && len(body) > limit {
+		return nil, errBodyTooLarge(len(body), limit)
+	}
+	prefix := c.prefixSize()
+	frame := make([]byte, prefix, prefix+len(body)+c.trailerSize())
+	if prefix == 2 {
+		c.byteOrder().PutUint16(frame, uint16(len(body)))
+	} else {
+		c.byteOrder().PutUint32(frame, uint32(len(body)))
+	}
+	frame = append(frame, body...)
+	frame = binary.BigEndian.AppendUint16(frame, checksum(body))
+	if c.NoTerminator {
+		return frame, nil
+	}
+	return append(frame, '\n'), nil
+}
+
+func (c LengthCRCCodec) Decode(buffer *bytes.Buffer) ([]byte, error) {
+	data := buffer.Bytes()
+	prefix := c.prefixSize()
+	if len(data) < prefix {
+		return nil, ErrIncompleteFrame
+	}
+	var length uint32
+	if prefix == 2 {
+		length = uint32(c.byteOrder().Uint16(data))
+	} else {
+		length = c.byteOrder().Uint32(data)
+	}
+	if c.MaxLength > 0 && length > c.MaxLength {
+		return nil, ProtocolError("长度前缀无效 (%d字节)", length)
+	}
+	// 未限制MaxLength时，超过2^31的长度在32位平台上转换为int会变成负数，须在转换前拒绝
+	if uint64(length) > uint64(math.MaxInt32-prefix-c.trailerSize()) {
+		return nil, ProtocolError("长度前缀无效 (%d字节)", length)
+	}
+	end := prefix + int(length)
+	total := end + c.trailerSize()
+	if len(data) < total {
+		return nil, ErrIncompleteFrame
+	}
+
+	body := data[prefix:end]
+	received := binary.BigEndian.Uint16(data[end:])
+	calculated := checksum(body)
+	if received != calculated {
+		return nil, ProtocolError("CRC校验失败，接收到的CRC: %x，计算的CRC: %x", received, calculated)
+	}
+	if !c.NoTerminator && data[total-1] != '\n' {
+		return nil, ProtocolError("帧未以换行符结束 (%#x)", data[total-1])
+	}
+	body = append([]byte(nil), body...)
+	buffer.Next(total)
+	return body, nil
+}
+
+// COBSCodec COBS分帧：COBS(帧体 | 2字节大端CRC16-MODBUS) | 0x00。
+// 帧内不会出现0x00，接收端只需找到下一个0x00即可定界，帧体中的换行符或类似长度前缀的字节不会造成误判
+type COBSCodec struct {
+	MaxLength int // 帧体最大长度，0表示不限
+}
+
+func (c COBSCodec) maxBody() int {
+	return c.MaxLength
+}
+
+func (c COBSCodec) Encode(body []byte) ([]byte, error) {
+	if c.MaxLength > 0 && len(body) > c.MaxLength {
+		return nil, errBodyTooLarge(len(body), c.MaxLength)
+	}
+	raw := binary.BigEndian.AppendUint16(append([]byte(nil), body...), checksum(body))
+	frame := make([]byte, 1, len(raw)+len(raw)/254+2)
+	code, codeIndex := byte(1), 0
+	for _, b := range raw {
+		if b != 0 {
+			frame = append(frame, b)
+			code++
+		}
+		if b == 0 || code == 0xFF {
+			frame[codeIndex] = code
+			codeIndex = len(frame)
+			frame = append(frame, 0)
+			code = 1
+		}
+	}
+	frame[codeIndex] = code
+	return append(frame, 0), nil
+}
+
+func (c COBSCodec) Decode(buffer *bytes.Buffer) ([]byte, error) {
+	data := buffer.Bytes()
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		if c.MaxLength > 0 && len(data) > c.MaxLength+c.MaxLength/254+4 {
+			return nil, ProtocolError("超过最大长度仍未找到帧分隔符 (%d字节)", len(data))
+		}
+		return nil, ErrIncompleteFrame
+	}
+	encoded := data[:end]
+	buffer.Next(end + 1) // 无论解码是否成功都消耗掉这一帧，下一帧从分隔符之后开始
+
+	raw := make([]byte, 0, len(encoded))
+	for i := 0; i < len(encoded); {
+		code := int(encoded[i])
+		if code == 0 || i+code > len(encoded) {
+			return nil, ProtocolError("COBS编码无效")
+		}
+		raw = append(raw, encoded[i+1:i+code]...)
+		i += code
+		if code < 0xFF && i < len(encoded) {
+			raw = append(raw, 0)
+		}
+	}
+	if len(raw) < 2 {
+		return nil, ProtocolError("COBS帧过短 (%d字节)", len(raw))
+	}
+	body := raw[:len(raw)-2]
+	if c.MaxLength > 0 && len(body) > c.MaxLength {
+		return nil, ProtocolError("帧体超过最大长度 (%d字节)", len(body))
+	}
+	received := binary.BigEndian.Uint16(raw[len(raw)-2:])
+	calculated := checksum(body)
+	if received != calculated {
+		return nil, ProtocolError("CRC校验失败，接收到的CRC: %x，计算的CRC: %x", received, calculated)
+	}
+	return body, nil
+}
+
+// ResyncingCodec 出错时已自行跳过无效数据、能从下一帧继续解码的编解码，
+// 接收端此时不必清空缓冲区，紧随其后的有效帧不会被丢弃
+type ResyncingCodec interface {
+	FrameCodec
+	resyncs()
+}
+
+func (c COBSCodec) resyncs() {}
+
+// SyncCodec 在内层分帧前加上同步标记（如0xAA55）；出错后从下一个同步标记处重新同步，
+// 同步标记之前的线路噪声被丢弃
+type SyncCodec struct {
+	Marker []byte
+	Inner  FrameCodec
+}
+
+func (c SyncCodec) resyncs() {}
+
+// frameStarted 同步标记出现之前的字节都是噪声；Decode最多保留标记长度减1字节的尾部
+func (c SyncCodec) frameStarted(data []byte) bool {
+	return bytes.Contains(data, c.Marker)
+}
+
+func (c SyncCodec) maxBody() int {
+	return MaxBody(c.Inner)
+}
+
+func (c SyncCodec) Encode(body []byte) ([]byte, error) {
+	frame, err := c.Inner.Encode(body)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), c.Marker...), frame...), nil
+}
+
+func (c SyncCodec) Decode(buffer *bytes.Buffer) ([]byte, error) {
+	data := buffer.Bytes()
+	start := bytes.Index(data, c.Marker)
+	if start < 0 {
+		// 保留可能是同步标记前半部分的尾部字节，其余都是噪声
+		if keep := len(c.Marker) - 1; len(data) > keep {
+			buffer.Next(len(data) - keep)
+		}
+		return nil, ErrIncompleteFrame
+	}
+	buffer.Next(start)
+
+	inner := bytes.NewBuffer(buffer.Bytes()[len(c.Marker):])
+	before := inner.Len()
+	body, err := c.Inner.Decode(inner)
+	if err == ErrIncompleteFrame {
+		return nil, err
+	}
+	if err != nil {
+		buffer.Next(1) // 跳过这个同步标记，下次从下一个同步标记开始
+		return nil, err
+	}
+	buffer.Next(len(c.Marker) + before - inner.Len())
+	return body, nil
+}
+
+// VersionedCodec 每帧以1字节协议版本开头，其后按该版本的分帧方式编码；接收端可同时
+// 接受多个版本，以后更改分帧时已部署的接收端仍能识别旧版本的帧
+type VersionedCodec struct {
+	Version  byte                // 发送使用的版本
+	Versions map[byte]FrameCodec // 各版本的分帧方式，须包含Version
+}
+
+func (c VersionedCodec) maxBody() int {
+	return MaxBody(c.Versions[c.Version])
+}
+
+func (c VersionedCodec) Encode(body []byte) ([]byte, error) {
+	frame, err := c.Versions[c.Version].Encode(body)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.Version}, frame...), nil
+}
+
+func (c VersionedCodec) Decode(buffer *bytes.Buffer) ([]byte, error) {
+	data := buffer.Bytes()
+	if len(data) < 1 {
+		return nil, ErrIncompleteFrame
+	}
+	inner, ok := c.Versions[data[0]]
+	if !ok {
+		return nil, ProtocolError("不支持的协议版本 %d", data[0])
+	}
+
+	rest := bytes.NewBuffer(data[1:])
+	before := rest.Len()
+	body, err := inner.Decode(rest)
+	if err != nil {
+		return nil, err
+	}
+	buffer.Next(1 + before - rest.Len())
+	return body, nil
+}
+
+// HMACCodec 在内层分帧的帧体后追加HMAC-SHA256（CRC仍由内层计算），接收端据此拒绝伪造的帧；
+// HMAC不匹配时返回ErrAuth类错误，与CRC错误区分。
+// 每帧都从Key重新读取十六进制编码的密钥，轮换后无需重启；读取开销大时用CachedKey包装
+type HMACCodec struct {
+	Key   KeyProvider
+	Inner FrameCodec
+}
+
+func (c HMACCodec) sum(body []byte) ([]byte, error) {
+	key, err := HexKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// Validate 检查内层的长度上限能否容纳HMAC；上限不超过HMAC的长度时任何帧都无法编码
+func (c HMACCodec) Validate() error {
+	if limit := MaxBody(c.Inner); limit > 0 && limit <= sha256.Size {
+		return ProtocolError("内层分帧的长度上限 %d 字节容纳不下 %d 字节的HMAC", limit, sha256.Size)
+	}
+	return nil
+}
+
+// maxBody 内层的上限减去HMAC的长度，不小于0；内层上限不足时Validate返回错误
+func (c HMACCodec) maxBody() int {
+	limit := MaxBody(c.Inner)
+	if limit == 0 {
+		return 0
+	}
+	return max(limit-sha256.Size, 0)
+}
+
+func (c HMACCodec) frameStarted(data []byte) bool {
+	return FrameStarted(c.Inner, data)
+}
+
+func (c HMACCodec) Encode(body []byte) ([]byte, error) {
+	sum, err := c.sum(body)
+	if err != nil {
+		return nil, err
+	}
+	return c.Inner.Encode(append(append([]byte(nil), body...), sum...))
+}
+
+func (c HMACCodec) Decode(buffer *bytes.Buffer) ([]byte, error) {
+	signed, err := c.Inner.Decode(buffer)
+	if err != nil {
+		return nil, err
+	}
+	if len(signed) < sha256.Size {
+		return nil, &LinkError{Kind: ErrAuth, Err: fmt.Errorf("帧缺少HMAC (%d字节)", len(signed))}
+	}
+	body := signed[:len(signed)-sha256.Size]
+	sum, err := c.sum(body)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signed[len(body):], sum) {
+		return nil, &LinkError{Kind: ErrAuth, Err: errors.New("HMAC校验失败，帧可能被伪造")}
+	}
+	return body, nil
+}
+
+// modbusTable CRC16-MODBUS查表，各分帧方式共用
+var modbusTable = crc16.MakeTable(crc16.CRC16_MODBUS)
+
+// checksum 计算帧体的CRC16-MODBUS
+func checksum(body []byte) uint16 {
+	return crc16.Checksum(body, modbusTable)
+}
+
+// modbusMaxADU MODBUS RTU帧（ADU）的最大长度
+const modbusMaxADU = 256
+
+// ModbusCodec MODBUS RTU兼容分帧：地址(1) | 功能码(1) | 帧体 | 2字节小端CRC16-MODBUS，
+// 帧之间以3.5个字符时间的静默分隔，使本协议可以与MODBUS从站共用一条总线。
+// RTU帧没有长度字段，接收端按帧间静默定界（见Gap），CRC只用于校验；
+// 地址或功能码不属于本协议的帧（其他从站的流量）返回ErrForeignFrame，调用方应静默跳过。
+// 帧体不能超过252字节，发送端据MaxBody自动分片；
+// 反馈也须封装为RTU帧，见WrapToken
+type ModbusCodec struct {
+	Address  byte // 本协议使用的从站地址
+	Function byte // 本协议使用的功能码，应在用户自定义范围（65~72、100~110）内，0表示65
+	Baud     int  // 用于计算帧间静默时间，0表示9600
+}
+
+func (c ModbusCodec) resyncs() {}
+
+func (c ModbusCodec) function() byte {
+	if c.Function == 0 {
+		return 65
+	}
+	return c.Function
+}
+
+// charTime 传输一个字符（11位）的时间
+func (c ModbusCodec) charTime() time.Duration {
+	baud := c.Baud
+	if baud == 0 {
+		baud = 9600
+	}
+	return time.Duration(float64(time.Second) * 11 / float64(baud))
+}
+
+// Silence 帧间静默时间：3.5个字符，波特率高于19200时按规范固定为1.75ms
+func (c ModbusCodec) Silence() time.Duration {
+	if c.Baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return 7 * c.charTime() / 2
+}
+
+// Gap 判断一次读取之前线路是否空闲了至少一个帧间静默：last为上次读到数据的时间，
+// now为本次读到n字节的时间，这n字节本身的传输时间不计入空闲
+func (c ModbusCodec) Gap(last, now time.Time, n int) bool {
+	return now.Sub(last)-time.Duration(n)*c.charTime() >= c.Silence()
+}
+
+// WrapToken 把反馈字符串封装为RTU帧，收发两端都用封装后的字符串作为反馈，
+// 使反馈在共享总线上同样是合法的MODBUS帧
+func (c ModbusCodec) WrapToken(token string) string {
+	frame, _ := c.Encode([]byte(token)) // 反馈字符串远小于帧长上限
+	return string(frame)
+}
+
+// maxBody 帧体不能超过ADU减去地址、功能码和CRC
+func (c ModbusCodec) maxBody() int {
+	return modbusMaxADU - 4
+}
+
+func (c ModbusCodec) Encode(body []byte) ([]byte, error) {
+	if len(body) > c.maxBody() {
+		return nil, errBodyTooLarge(len(body), c.maxBody())
+	}
+	frame := append([]byte{c.Address, c.function()}, body...)
+	return binary.LittleEndian.AppendUint16(frame, crc16.Checksum(frame, modbusTable)), nil
+}
+
+// Decode 把缓冲区中的全部数据作为一帧：RTU帧以静默定界，调用方须在Gap判定线路空闲后才调用
+func (c ModbusCodec) Decode(buffer *bytes.Buffer) ([]byte, error) {
+	if buffer.Len() == 0 {
+		return nil, ErrIncompleteFrame
+	}
+	frame := buffer.Next(buffer.Len())
+	if len(frame) < 4 || len(frame) > modbusMaxADU {
+		return nil, ProtocolError("RTU帧长度无效 (%d字节)", len(frame))
+	}
+	end := len(frame) - 2
+	received := binary.LittleEndian.Uint16(frame[end:])
+	calculated := crc16.Checksum(frame[:end], modbusTable)
+	if received != calculated {
+		return nil, ProtocolError("CRC校验失败，接收到的CRC: %x，计算的CRC: %x", received, calculated)
+	}
+	if frame[0] != c.Address || frame[1] != c.function() {
+		return nil, fmt.Errorf("%w (地址 %d，功能码 %d)", ErrForeignFrame, frame[0], frame[1])
+	}
+	return append([]byte(nil), frame[2:end]...), nil
+}
+
+// ModbusFraming 返回分帧方式中的MODBUS RTU分帧，穿过认证、同步标记、版本头等外层包装；
+// 版本头按发送使用的版本查找
+func ModbusFraming(codec FrameCodec) (ModbusCodec, bool) {
+	switch c := codec.(type) {
+	case ModbusCodec:
+		return c, true
+	case HMACCodec:
+		return ModbusFraming(c.Inner)
+	case SyncCodec:
+		return ModbusFraming(c.Inner)
+	case VersionedCodec:
+		return ModbusFraming(c.Versions[c.Version])
+	}
+	return ModbusCodec{}, false
+}
@@ -0,0 +1,246 @@
+package serialcomm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// staticKey 测试用的固定密钥，可在用例中修改以模拟轮换
+type staticKey struct {
+	hex string
+}
+
+func (k *staticKey) Key() ([]byte, error) {
+	return []byte(k.hex), nil
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	key := &staticKey{hex: "00112233445566778899aabbccddeeff"}
+	codecs := []struct {
+		name  string
+		codec FrameCodec
+	}{
+		{"默认长度前缀", LengthCRCCodec{}},
+		{"2字节小端长度无换行", LengthCRCCodec{LengthSize: 2, LittleEndian: true, NoTerminator: true}},
+		{"COBS", COBSCodec{MaxLength: 1024}},
+		{"同步标记", SyncCodec{Marker: []byte{0xAA, 0x55}, Inner: LengthCRCCodec{}}},
+		{"版本头", VersionedCodec{Version: 2, Versions: map[byte]FrameCodec{1: LengthCRCCodec{}, 2: COBSCodec{}}}},
+		{"HMAC", HMACCodec{Key: key, Inner: LengthCRCCodec{}}},
+	}
+	bodies := [][]byte{
+		[]byte(`{"id":"1"}`),
+		{0x00, 0x0A, 0xFF, 0x00},
+		bytes.Repeat([]byte{0x00}, 300),
+	}
+	for _, tc := range codecs {
+		for _, body := range bodies {
+			frame, err := tc.codec.Encode(body)
+			if err != nil {
+				t.Fatalf("%s: 编码失败: %v", tc.name, err)
+			}
+			buffer := bytes.NewBuffer(append(frame, frame...))
+			for i := 0; i < 2; i++ {
+				got, err := tc.codec.Decode(buffer)
+				if err != nil {
+					t.Fatalf("%s: 解码第%d帧失败: %v", tc.name, i+1, err)
+				}
+				if !bytes.Equal(got, body) {
+					t.Fatalf("%s: 帧体不一致: %x，期望 %x", tc.name, got, body)
+				}
+			}
+			if buffer.Len() != 0 {
+				t.Fatalf("%s: 解码后剩余 %d 字节", tc.name, buffer.Len())
+			}
+		}
+	}
+}
+
+func TestCodecIncompleteAndCorrupt(t *testing.T) {
+	codecs := []struct {
+		name  string
+		codec FrameCodec
+	}{
+		{"默认长度前缀", LengthCRCCodec{}},
+		{"COBS", COBSCodec{}},
+		{"同步标记", SyncCodec{Marker: []byte{0xAA, 0x55}, Inner: LengthCRCCodec{}}},
+	}
+	body := []byte("hello")
+	for _, tc := range codecs {
+		frame, err := tc.codec.Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		partial := bytes.NewBuffer(append([]byte(nil), frame[:len(frame)-2]...))
+		before := partial.Len()
+		if _, err := tc.codec.Decode(partial); err != ErrIncompleteFrame {
+			t.Errorf("%s: 不完整的帧返回 %v，期望ErrIncompleteFrame", tc.name, err)
+		}
+		if partial.Len() != before {
+			t.Errorf("%s: 不完整的帧被消耗了 %d 字节", tc.name, before-partial.Len())
+		}
+
+		corrupt := append([]byte(nil), frame...)
+		corrupt[len(corrupt)/2] ^= 0x01
+		if _, err := tc.codec.Decode(bytes.NewBuffer(corrupt)); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%s: 损坏的帧返回 %v，期望ErrProtocol", tc.name, err)
+		}
+	}
+}
+
+func TestLengthPrefixLimit(t *testing.T) {
+	tests := []struct {
+		codec   LengthCRCCodec
+		size    int
+		wantErr bool
+	}{
+		{LengthCRCCodec{LengthSize: 2}, 0xFFFF, false},
+		{LengthCRCCodec{LengthSize: 2}, 0x10000, true},
+		{LengthCRCCodec{LengthSize: 2, MaxLength: 100}, 101, true},
+		{LengthCRCCodec{}, 0x10000, false},
+	}
+	for _, tc := range tests {
+		_, err := tc.codec.Encode(make([]byte, tc.size))
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%+v 编码 %d 字节: 错误 %v，期望出错 %v", tc.codec, tc.size, err, tc.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrProtocol) {
+			t.Errorf("超长错误应为ErrProtocol类: %v", err)
+		}
+	}
+	// 不限长度时，恶意的超大长度前缀返回ErrProtocol，而不是在32位平台上切片越界
+	hostile := bytes.NewBuffer([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00})
+	if _, err := (LengthCRCCodec{}).Decode(hostile); !errors.Is(err, ErrProtocol) {
+		t.Errorf("超大长度前缀: %v，期望ErrProtocol", err)
+	}
+
+	if got := MaxBody(HMACCodec{Inner: LengthCRCCodec{LengthSize: 2}}); got != 0xFFFF-32 {
+		t.Errorf("HMAC包装后的上限为 %d，期望 %d", got, 0xFFFF-32)
+	}
+	small := HMACCodec{Inner: LengthCRCCodec{MaxLength: 20}}
+	if got := MaxBody(small); got < 0 {
+		t.Errorf("内层上限小于HMAC长度时上限为 %d，不应为负数", got)
+	}
+	if err := small.Validate(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("内层上限容纳不下HMAC时 Validate 返回 %v，期望ErrProtocol", err)
+	}
+	if err := (HMACCodec{Inner: ModbusCodec{}}).Validate(); err != nil {
+		t.Errorf("RTU分帧可以容纳HMAC: %v", err)
+	}
+}
+
+func TestModbusCodec(t *testing.T) {
+	codec := ModbusCodec{Address: 0xF7, Baud: 9600}
+	frame, err := codec.Encode([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := codec.Decode(bytes.NewBuffer(frame))
+	if err != nil || string(body) != "data" {
+		t.Fatalf("解码得到 %q, %v", body, err)
+	}
+
+	// 其他从站的帧CRC正确，但不属于本协议
+	other := ModbusCodec{Address: 0x01, Function: 3}
+	foreign, _ := other.Encode([]byte{0x00, 0x01})
+	if _, err := codec.Decode(bytes.NewBuffer(foreign)); !errors.Is(err, ErrForeignFrame) {
+		t.Errorf("其他从站的帧返回 %v，期望ErrForeignFrame", err)
+	}
+
+	// 静默定界：整个缓冲区是一帧，两帧连在一起时CRC不再匹配而不是被拆成两帧
+	if _, err := codec.Decode(bytes.NewBuffer(append(frame, frame...))); !errors.Is(err, ErrProtocol) {
+		t.Errorf("未经静默分隔的两帧返回 %v，期望ErrProtocol", err)
+	}
+
+	if _, err := codec.Encode(make([]byte, 253)); !errors.Is(err, ErrProtocol) {
+		t.Errorf("超过252字节的帧体返回 %v，期望ErrProtocol", err)
+	}
+
+	start := time.Unix(0, 0)
+	silence := codec.Silence()
+	if codec.Gap(start, start.Add(silence/2), 0) {
+		t.Error("不足帧间静默时判定为空闲")
+	}
+	if !codec.Gap(start, start.Add(silence), 0) {
+		t.Error("达到帧间静默时未判定为空闲")
+	}
+	if codec.Gap(start, start.Add(silence+codec.charTime()), 4) {
+		t.Error("本次读到的字节的传输时间被计入空闲")
+	}
+}
+
+func TestModbusFraming(t *testing.T) {
+	rtu := ModbusCodec{Address: 0xF7}
+	wrapped := []FrameCodec{
+		rtu,
+		HMACCodec{Inner: rtu},
+		SyncCodec{Marker: []byte{0xAA}, Inner: rtu},
+		VersionedCodec{Version: 2, Versions: map[byte]FrameCodec{1: LengthCRCCodec{}, 2: HMACCodec{Inner: rtu}}},
+	}
+	for _, codec := range wrapped {
+		if got, ok := ModbusFraming(codec); !ok || got != rtu {
+			t.Errorf("%T: 得到 %v, %v，期望找到RTU分帧", codec, got, ok)
+		}
+	}
+	if _, ok := ModbusFraming(VersionedCodec{Version: 1, Versions: map[byte]FrameCodec{1: LengthCRCCodec{}, 2: rtu}}); ok {
+		t.Error("发送版本不是RTU分帧时仍返回了RTU分帧")
+	}
+}
+
+func TestHMACKeyRotation(t *testing.T) {
+	key := &staticKey{hex: "00112233445566778899aabbccddeeff"}
+	codec := HMACCodec{Key: key, Inner: LengthCRCCodec{}}
+	frame, err := codec.Encode([]byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.hex = "ffeeddccbbaa99887766554433221100"
+	if _, err := codec.Decode(bytes.NewBuffer(frame)); !errors.Is(err, ErrAuth) {
+		t.Errorf("轮换密钥后旧帧返回 %v，期望ErrAuth", err)
+	}
+	frame, _ = codec.Encode([]byte("body"))
+	if _, err := codec.Decode(bytes.NewBuffer(frame)); err != nil {
+		t.Errorf("轮换后的新帧解码失败: %v", err)
+	}
+}
+
+func TestCachedKeyTTL(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	provider := &staticKey{hex: "01"}
+	cached := &CachedKey{Provider: provider, TTL: time.Minute, Clock: clock}
+
+	first, _ := cached.Key()
+	provider.hex = "02"
+	clock.Advance(30 * time.Second)
+	if got, _ := cached.Key(); !bytes.Equal(got, first) {
+		t.Errorf("TTL内重新获取了密钥: %s", got)
+	}
+	clock.Advance(31 * time.Second)
+	if got, _ := cached.Key(); string(got) != "02" {
+		t.Errorf("TTL过期后仍返回旧密钥: %s", got)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    KeyProvider
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"env:SERIALJSON_MAC_KEY", EnvKey{Name: "SERIALJSON_MAC_KEY"}, false},
+		{"file:device.key", FileKey{Path: "device.key", Private: true}, false},
+		{"keyring:serialjson/sign-key", KeyringKey{Service: "serialjson", Account: "sign-key"}, false},
+		{"keyring:serialjson", nil, true},
+		{"device.key", nil, true},
+		{"vault:secret", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseKey(tc.spec, true)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseKey(%q) = %v, %v，期望 %v (出错 %v)", tc.spec, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package serialcomm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 错误类别，调用方用errors.Is按类别处理，而不是匹配日志文本
+var (
+	ErrPort     = errors.New("串口错误")  // 打开、读写串口失败
+	ErrTimeout  = errors.New("超时")    // 等待反馈或时间预算超时
+	ErrProtocol = errors.New("协议错误")  // 帧格式、长度或校验错误
+	ErrNack     = errors.New("对端未确认") // 对端回复RETRY或未知反馈
+	ErrAuth     = errors.New("认证失败")  // 帧的HMAC不匹配，重传无济于事
+)
+
+// LinkError 带类别的错误，可用errors.As取出操作名称，errors.Is同时匹配类别和底层错误
+type LinkError struct {
+	Kind error  // 上面的错误类别之一
+	Op   string // 失败的操作，为空时只显示底层错误
+	Err  error
+}
+
+func (e *LinkError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *LinkError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// PortError 包装串口读写错误
+func PortError(op string, err error) error {
+	return &LinkError{Kind: ErrPort, Op: op, Err: err}
+}
+
+// ProtocolError 构造帧格式错误
+func ProtocolError(format string, args ...any) error {
+	return &LinkError{Kind: ErrProtocol, Err: fmt.Errorf(format, args...)}
+}
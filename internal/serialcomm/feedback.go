@@ -0,0 +1,36 @@
+package serialcomm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WindowAckPrefix 滑动窗口累积确认的前缀，其后为5位十进制序号，表示该序号及之前的帧都已处理
+const WindowAckPrefix = "ACK"
+
+// windowAckDigits 累积确认中序号的位数
+const windowAckDigits = 5
+
+// WindowAck 返回确认到seq（含）的累积确认
+func WindowAck(seq uint16) string {
+	return fmt.Sprintf("%s%0*d", WindowAckPrefix, windowAckDigits, seq)
+}
+
+// ShadowsWindowAck 判断反馈字符串是否为某个累积确认的前缀（如旧固件的 "ACK"）：
+// 读取反馈时两者无法区分，不能同时用于一条链路
+func ShadowsWindowAck(token string) bool {
+	switch {
+	case token == "" || len(token) > len(WindowAckPrefix)+windowAckDigits:
+		return false
+	case len(token) <= len(WindowAckPrefix):
+		return strings.HasPrefix(WindowAckPrefix, token)
+	case !strings.HasPrefix(token, WindowAckPrefix):
+		return false
+	}
+	for _, c := range token[len(WindowAckPrefix):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
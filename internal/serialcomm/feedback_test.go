@@ -0,0 +1,35 @@
+package serialcomm
+
+import "testing"
+
+func TestWindowAck(t *testing.T) {
+	if got := WindowAck(12); got != "ACK00012" {
+		t.Errorf("WindowAck(12) = %q", got)
+	}
+	if got := WindowAck(0xFFFF); got != "ACK65535" {
+		t.Errorf("WindowAck(0xFFFF) = %q", got)
+	}
+}
+
+func TestShadowsWindowAck(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"ACK", true},
+		{"A", true},
+		{"ACK0", true},
+		{"ACK00012", true},
+		{"OK", false},
+		{"NAK", false},
+		{"ACKNOWLEDGED", false},
+		{"ACK000123", false},
+		{"ACKx", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if got := ShadowsWindowAck(tc.token); got != tc.want {
+			t.Errorf("ShadowsWindowAck(%q) = %v，期望 %v", tc.token, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package serialcomm
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Framing 命令行上的分帧配置，收发两端注册相同的选项，取值须一致
+type Framing struct {
+	Kind           string // 分帧方式：length（长度前缀，默认）、cobs（帧体含二进制数据时不会误判边界）或modbus（与MODBUS从站共用总线）
+	LengthPrefix   string // 长度前缀的格式：字节数加字节序，如 4be（默认）、2le，只用于length
+	NoTerminator   bool   // 帧尾不加换行符，只用于length；旧版接收端要求换行符
+	SyncMarker     string // 十六进制的同步标记，如 AA55；非空时每帧以它开头，线路噪声多时用于重新同步
+	ModbusAddress  int    // modbus分帧使用的从站地址（1~247）
+	ModbusFunction int    // modbus分帧使用的功能码，须在用户自定义范围（65~72、100~110）内
+	Version        int    // 帧头的协议版本（1~255），0表示不加版本头
+	// AcceptVersions 除Version外还接受的旧版本及其分帧方式，如 1=length,2=cobs；
+	// 更改分帧时先让接收端同时接受新旧版本，再逐台升级发送端
+	AcceptVersions string
+}
+
+// RegisterFlags 在fs上注册分帧选项
+func (f *Framing) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.Kind, "framing", "length", "分帧方式：length（长度前缀+CRC）、cobs（COBS编码，以0x00定界）或modbus（RTU兼容帧，以静默定界），须与对端一致")
+	fs.IntVar(&f.ModbusAddress, "modbus-address", 0xF7, "modbus分帧使用的从站地址（1~247），不能与总线上的其他从站冲突")
+	fs.IntVar(&f.ModbusFunction, "modbus-function", 65, "modbus分帧使用的功能码（65~72或100~110）")
+	fs.StringVar(&f.LengthPrefix, "length-prefix", "4be", "长度前缀的格式：4be、4le、2be或2le（部分MCU固件使用2字节小端长度），须与对端一致")
+	fs.BoolVar(&f.NoTerminator, "no-terminator", false, "length分帧的帧尾不加换行符（每帧省1字节）；旧版对端要求换行符，须与对端一致")
+	fs.IntVar(&f.Version, "frame-version", 0, "帧头的协议版本（1~255），以当前 -framing 编码；0表示不加版本头，须与对端一致")
+	fs.StringVar(&f.AcceptVersions, "accept-versions", "", "除 -frame-version 外还接受的版本及其分帧方式，如 1=length,2=cobs")
+	fs.StringVar(&f.SyncMarker, "sync-marker", "", "十六进制的同步标记（如 AA55），每帧以它开头，出错后从下一个标记处重新同步；空表示不使用，须与对端一致")
+}
+
+// Validate 检查分帧配置，返回的错误逐条列出每个无效选项
+func (f Framing) Validate() error {
+	var errs []error
+	switch f.Kind {
+	case "", "length", "cobs":
+	case "modbus":
+		if f.ModbusAddress < 1 || f.ModbusAddress > 247 {
+			errs = append(errs, fmt.Errorf("-modbus-address %d 无效：应在 1~247 之间", f.ModbusAddress))
+		}
+		if !userFunction(f.ModbusFunction) {
+			errs = append(errs, fmt.Errorf("-modbus-function %d 无效：应在 65~72 或 100~110 之间", f.ModbusFunction))
+		}
+		// RTU帧须以地址开头，版本头和同步标记会使其他从站把本协议的帧当作发给自己的
+		if f.Version != 0 || f.SyncMarker != "" {
+			errs = append(errs, errors.New("-framing modbus 不能与 -frame-version 或 -sync-marker 同时使用"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("-framing %q 无效：应为 length、cobs 或 modbus", f.Kind))
+	}
+	if f.NoTerminator && (f.Kind == "cobs" || f.Kind == "modbus") {
+		errs = append(errs, fmt.Errorf("-no-terminator 只用于 -framing length，%s 分帧没有换行符", f.Kind))
+	}
+	if _, _, err := parseLengthPrefix(f.LengthPrefix); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseSyncMarker(f.SyncMarker); err != nil {
+		errs = append(errs, err)
+	}
+	if f.Version < 0 || f.Version > 255 {
+		errs = append(errs, fmt.Errorf("-frame-version %d 无效：应在 0~255 之间", f.Version))
+	}
+	if _, err := parseAcceptVersions(f.AcceptVersions); err != nil {
+		errs = append(errs, err)
+	} else if f.AcceptVersions != "" && f.Version == 0 {
+		errs = append(errs, errors.New("-accept-versions 需要同时设置 -frame-version"))
+	}
+	return errors.Join(errs...)
+}
+
+// Codec 按配置创建分帧方式，maxLength为帧体最大长度，0表示只受分帧格式本身的限制；
+// baud为串口波特率，modbus分帧据此计算帧间静默时间
+func (f Framing) Codec(maxLength uint32, baud int) (FrameCodec, error) {
+	if f.Kind == "modbus" {
+		if f.ModbusAddress < 1 || f.ModbusAddress > 247 || !userFunction(f.ModbusFunction) {
+			return nil, fmt.Errorf("modbus地址 %d 或功能码 %d 无效", f.ModbusAddress, f.ModbusFunction)
+		}
+		return ModbusCodec{Address: byte(f.ModbusAddress), Function: byte(f.ModbusFunction), Baud: baud}, nil
+	}
+	codec, err := f.baseCodec(f.Kind, maxLength)
+	if err != nil {
+		return nil, err
+	}
+	if f.Version != 0 {
+		if f.Version < 0 || f.Version > 255 {
+			return nil, fmt.Errorf("-frame-version %d 无效：应在 0~255 之间", f.Version)
+		}
+		accepted, err := parseAcceptVersions(f.AcceptVersions)
+		if err != nil {
+			return nil, err
+		}
+		versions := map[byte]FrameCodec{byte(f.Version): codec}
+		for version, kind := range accepted {
+			if version == byte(f.Version) {
+				continue // -frame-version 始终使用当前的 -framing
+			}
+			if versions[version], err = f.baseCodec(kind, maxLength); err != nil {
+				return nil, err
+			}
+		}
+		codec = VersionedCodec{Version: byte(f.Version), Versions: versions}
+	}
+	marker, err := parseSyncMarker(f.SyncMarker)
+	if err != nil {
+		return nil, err
+	}
+	if marker != nil {
+		codec = SyncCodec{Marker: marker, Inner: codec}
+	}
+	return codec, nil
+}
+
+// baseCodec 创建kind指定的分帧方式，不含版本头、同步标记等外层包装
+func (f Framing) baseCodec(kind string, maxLength uint32) (FrameCodec, error) {
+	switch kind {
+	case "", "length":
+	case "cobs":
+		return COBSCodec{MaxLength: int(maxLength)}, nil
+	default:
+		return nil, fmt.Errorf("分帧方式 %q 无效：应为 length 或 cobs", kind)
+	}
+	size, little, err := parseLengthPrefix(f.LengthPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return LengthCRCCodec{MaxLength: maxLength, NoTerminator: f.NoTerminator, LengthSize: size, LittleEndian: little}, nil
+}
+
+// userFunction 判断功能码是否在MODBUS规定的用户自定义范围内
+func userFunction(function int) bool {
+	return function >= 65 && function <= 72 || function >= 100 && function <= 110
+}
+
+// parseAcceptVersions 解析 -accept-versions，如 1=length,2=cobs
+func parseAcceptVersions(spec string) (map[byte]string, error) {
+	versions := make(map[byte]string)
+	if spec == "" {
+		return versions, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		number, kind, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		version, err := strconv.ParseUint(number, 10, 8)
+		if !ok || err != nil || version == 0 {
+			return nil, fmt.Errorf("-accept-versions 中的 %q 无效：应为 版本=分帧方式，版本在 1~255 之间", entry)
+		}
+		switch kind {
+		case "length", "cobs":
+		default:
+			return nil, fmt.Errorf("-accept-versions 中版本 %d 的分帧方式 %q 无效：应为 length 或 cobs", version, kind)
+		}
+		if _, dup := versions[byte(version)]; dup {
+			return nil, fmt.Errorf("-accept-versions 中版本 %d 重复", version)
+		}
+		versions[byte(version)] = kind
+	}
+	return versions, nil
+}
+
+// parseSyncMarker 解析十六进制的同步标记，空字符串表示不使用
+func parseSyncMarker(spec string) ([]byte, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	marker, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(spec), "0x"))
+	if err != nil || len(marker) == 0 {
+		return nil, fmt.Errorf("-sync-marker %q 无效：应为十六进制字节，如 AA55", spec)
+	}
+	return marker, nil
+}
+
+// parseLengthPrefix 解析长度前缀的格式，空字符串为默认的4字节大端
+func parseLengthPrefix(spec string) (size int, little bool, err error) {
+	switch strings.ToLower(spec) {
+	case "", "4be":
+		return 4, false, nil
+	case "4le":
+		return 4, true, nil
+	case "2be":
+		return 2, false, nil
+	case "2le":
+		return 2, true, nil
+	}
+	return 0, false, fmt.Errorf("-length-prefix %q 无效：应为 4be、4le、2be 或 2le", spec)
+}
@@ -0,0 +1,52 @@
+package serialcomm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFramingCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing Framing
+		want    FrameCodec
+	}{
+		{"默认", Framing{}, LengthCRCCodec{MaxLength: 100, LengthSize: 4}},
+		{"2字节小端长度", Framing{LengthPrefix: "2le"}, LengthCRCCodec{MaxLength: 100, LengthSize: 2, LittleEndian: true}},
+		{"4字节小端长度", Framing{LengthPrefix: "4LE"}, LengthCRCCodec{MaxLength: 100, LengthSize: 4, LittleEndian: true}},
+		{"COBS", Framing{Kind: "cobs"}, COBSCodec{MaxLength: 100}},
+		{"MODBUS", Framing{Kind: "modbus", ModbusAddress: 0xF7, ModbusFunction: 100}, ModbusCodec{Address: 0xF7, Function: 100, Baud: 19200}},
+		{"无换行符", Framing{NoTerminator: true}, LengthCRCCodec{MaxLength: 100, NoTerminator: true, LengthSize: 4}},
+		{"版本头", Framing{Version: 2, Kind: "cobs", AcceptVersions: "1=length, 2=length"}, VersionedCodec{Version: 2, Versions: map[byte]FrameCodec{
+			1: LengthCRCCodec{MaxLength: 100, LengthSize: 4},
+			2: COBSCodec{MaxLength: 100},
+		}}},
+		{"同步标记", Framing{SyncMarker: "0xAA55"}, SyncCodec{Marker: []byte{0xAA, 0x55}, Inner: LengthCRCCodec{MaxLength: 100, LengthSize: 4}}},
+	}
+	for _, tc := range tests {
+		if err := tc.framing.Validate(); err != nil {
+			t.Errorf("%s: 有效的配置被拒绝: %v", tc.name, err)
+			continue
+		}
+		got, err := tc.framing.Codec(100, 19200)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: 得到 %#v，期望 %#v", tc.name, got, tc.want)
+		}
+	}
+
+	for _, invalid := range []Framing{{LengthPrefix: "3be"}, {Kind: "slip"}, {Kind: "cobs", NoTerminator: true}, {SyncMarker: "AA5"}, {SyncMarker: "0x"},
+		{Version: 256}, {AcceptVersions: "1=length"}, {Version: 2, AcceptVersions: "1=slip"}, {Version: 2, AcceptVersions: "0=cobs"},
+		{Version: 2, AcceptVersions: "1=cobs,1=length"},
+		{Kind: "modbus", ModbusAddress: 0, ModbusFunction: 65}, {Kind: "modbus", ModbusAddress: 1, ModbusFunction: 3},
+		{Kind: "modbus", ModbusAddress: 1, ModbusFunction: 65, SyncMarker: "AA55"},
+		{Kind: "modbus", ModbusAddress: 1, ModbusFunction: 65, Version: 1},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("无效的配置 %+v 未被拒绝", invalid)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package serialcomm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// GoldenVector 金标准帧：按Codec所述的分帧方式编码Body应得到的线上字节Frame，
+// 字节均为十六进制，供其他语言的固件实现逐字节对照
+type GoldenVector struct {
+	Name  string `json:"name"`
+	Codec string `json:"codec"`
+	Body  string `json:"body"`
+	Frame string `json:"frame"`
+}
+
+// Decode 返回向量的帧体和线上字节
+func (v GoldenVector) Decode() (body, frame []byte, err error) {
+	body, err = hex.DecodeString(v.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: 帧体不是有效的十六进制: %v", v.Name, err)
+	}
+	frame, err = hex.DecodeString(v.Frame)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: 帧不是有效的十六进制: %v", v.Name, err)
+	}
+	return body, frame, nil
+}
+
+// goldenKeyHex 金标准HMAC帧使用的公开测试密钥，不能用于实际链路
+const goldenKeyHex = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// fixedKey 固定的密钥材料
+type fixedKey string
+
+func (k fixedKey) Key() ([]byte, error) {
+	return []byte(k), nil
+}
+
+// goldenCodecs 金标准覆盖的分帧方式、校验与选项组合
+var goldenCodecs = []struct {
+	name  string
+	desc  string
+	codec FrameCodec
+}{
+	{"lencrc", "4字节大端长度 | 帧体 | CRC16-MODBUS大端 | 0x0A", LengthCRCCodec{}},
+	{"lencrc-noterm", "4字节大端长度 | 帧体 | CRC16-MODBUS大端", LengthCRCCodec{NoTerminator: true}},
+	{"lencrc-len2le", "2字节小端长度 | 帧体 | CRC16-MODBUS大端 | 0x0A", LengthCRCCodec{LengthSize: 2, LittleEndian: true}},
+	{"lencrc-len2le-noterm", "2字节小端长度 | 帧体 | CRC16-MODBUS大端", LengthCRCCodec{LengthSize: 2, LittleEndian: true, NoTerminator: true}},
+	{"cobs", "COBS(帧体 | CRC16-MODBUS大端) | 0x00", COBSCodec{}},
+	{"sync-lencrc", "0xAA55 | 4字节大端长度 | 帧体 | CRC16-MODBUS大端 | 0x0A", SyncCodec{Marker: []byte{0xAA, 0x55}, Inner: LengthCRCCodec{}}},
+	{"sync-cobs", "0xAA55 | COBS(帧体 | CRC16-MODBUS大端) | 0x00", SyncCodec{Marker: []byte{0xAA, 0x55}, Inner: COBSCodec{}}},
+	{"v1-lencrc", "版本0x01 | 4字节大端长度 | 帧体 | CRC16-MODBUS大端 | 0x0A", VersionedCodec{Version: 1, Versions: map[byte]FrameCodec{1: LengthCRCCodec{}}}},
+	{"v2-cobs", "版本0x02 | COBS(帧体 | CRC16-MODBUS大端) | 0x00", VersionedCodec{Version: 2, Versions: map[byte]FrameCodec{2: COBSCodec{}}}},
+	{"hmac-lencrc", "4字节大端长度 | 帧体 | HMAC-SHA256(密钥" + goldenKeyHex + ") | CRC16-MODBUS大端 | 0x0A", HMACCodec{Key: fixedKey(goldenKeyHex), Inner: LengthCRCCodec{}}},
+	{"modbus", "地址0xF7 | 功能码0x41 | 帧体 | CRC16-MODBUS小端，帧间3.5字符静默", ModbusCodec{Address: 0xF7}},
+}
+
+// goldenBodies 金标准覆盖的帧体：保活帧、JSON、含分隔符字节的二进制、跨COBS分块边界的长帧
+var goldenBodies = []struct {
+	name string
+	body []byte
+}{
+	{"keepalive", nil},
+	{"json", []byte(`{"apiVersion":"v2","payload":"eyJ2IjoxfQ=="}`)},
+	{"binary", []byte{0x00, 0x0A, 0xFF, 0x00, 0xAA, 0x55, 0x0D, 0x00}},
+	{"zeros", make([]byte, 16)},
+	{"long", bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04, 0x05}, 60)},
+}
+
+// GenerateGolden 用本包的编码器生成金标准语料，帧体超出分帧方式上限的组合被跳过。
+// 发布的语料位于testdata/golden.json，新增组合后用 go test -run Golden -update 重新生成
+func GenerateGolden() ([]GoldenVector, error) {
+	var vectors []GoldenVector
+	for _, c := range goldenCodecs {
+		for _, b := range goldenBodies {
+			if limit := MaxBody(c.codec); limit > 0 && len(b.body) > limit {
+				continue
+			}
+			frame, err := c.codec.Encode(b.body)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %v", c.name, b.name, err)
+			}
+			vectors = append(vectors, GoldenVector{
+				Name:  c.name + "/" + b.name,
+				Codec: c.desc,
+				Body:  hex.EncodeToString(b.body),
+				Frame: hex.EncodeToString(frame),
+			})
+		}
+	}
+	return vectors, nil
+}
+
+// LoadGolden 读取金标准语料文件
+func LoadGolden(path string) ([]GoldenVector, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取金标准语料失败: %v", err)
+	}
+	var vectors []GoldenVector
+	err = json.Unmarshal(data, &vectors)
+	if err != nil {
+		return nil, fmt.Errorf("金标准语料 %s 无效: %v", path, err)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,73 @@
+package serialcomm
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "重新生成testdata/golden.json")
+
+// goldenFile 发布给其他语言实现的金标准语料
+var goldenFile = filepath.Join("testdata", "golden.json")
+
+func TestGoldenCorpus(t *testing.T) {
+	vectors, err := GenerateGolden()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateGolden {
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 已发布的语料不能因编码器的改动而变化，否则已按它实现的固件会失效
+	published, err := LoadGolden(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != len(vectors) {
+		t.Fatalf("语料有 %d 条，编码器生成 %d 条；新增组合后用 -update 重新生成", len(published), len(vectors))
+	}
+	for i, want := range published {
+		if vectors[i] != want {
+			t.Errorf("%s: 编码器输出与已发布的语料不一致\n得到 %s\n期望 %s", want.Name, vectors[i].Frame, want.Frame)
+		}
+	}
+}
+
+func TestGoldenDecodes(t *testing.T) {
+	published, err := LoadGolden(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codecs := make(map[string]FrameCodec)
+	for _, c := range goldenCodecs {
+		codecs[c.name] = c.codec
+	}
+	for _, v := range published {
+		body, frame, err := v.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _, _ := strings.Cut(v.Name, "/")
+		codec := codecs[name]
+		got, err := codec.Decode(bytes.NewBuffer(frame))
+		if err != nil {
+			t.Errorf("%s: 解码失败: %v", v.Name, err)
+			continue
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("%s: 解码得到 %x，期望 %x", v.Name, got, body)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package serialcomm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyProvider 提供密钥材料（PEM文本），每次调用都重新获取以支持不停机轮换
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// EnvKey 从环境变量读取密钥
+type EnvKey struct {
+	Name string
+}
+
+func (k EnvKey) Key() ([]byte, error) {
+	value, ok := os.LookupEnv(k.Name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("环境变量 %s 未设置", k.Name)
+	}
+	return []byte(value), nil
+}
+
+// FileKey 从文件读取密钥，Private为true时拒绝组或其他用户可访问的文件
+type FileKey struct {
+	Path    string
+	Private bool
+}
+
+func (k FileKey) Key() ([]byte, error) {
+	if k.Private && runtime.GOOS != "windows" {
+		info, err := os.Stat(k.Path)
+		if err != nil {
+			return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			return nil, fmt.Errorf("密钥文件 %s 权限过宽 (%04o)，请执行 chmod 600", k.Path, perm)
+		}
+	}
+	key, err := os.ReadFile(k.Path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+	}
+	return key, nil
+}
+
+// KeyringKey 通过系统钥匙串读取密钥：Linux使用secret-tool，macOS使用security
+type KeyringKey struct {
+	Service string
+	Account string
+}
+
+func (k KeyringKey) Key() ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", k.Service, "account", k.Account)
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", k.Service, "-a", k.Account, "-w")
+	default:
+		return nil, fmt.Errorf("当前平台不支持系统钥匙串")
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("从钥匙串读取 %s/%s 失败: %v", k.Service, k.Account, err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+// CachedKey 在TTL内缓存下层提供者的结果，过期后重新获取以拾取轮换后的密钥
+type CachedKey struct {
+	Provider KeyProvider
+	TTL      time.Duration
+	Clock    Clock // 为nil时使用系统时间
+
+	mu      sync.Mutex
+	key     []byte
+	fetched time.Time
+}
+
+func (k *CachedKey) Key() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	clock := k.Clock
+	if clock == nil {
+		clock = RealClock{}
+	}
+	if k.key != nil && clock.Since(k.fetched) < k.TTL {
+		return k.key, nil
+	}
+	key, err := k.Provider.Key()
+	if err != nil {
+		return nil, err
+	}
+	k.key = key
+	k.fetched = clock.Now()
+	return key, nil
+}
+
+// HexKey 读取十六进制编码的预共享密钥
+func HexKey(key KeyProvider) ([]byte, error) {
+	raw, err := key.Key()
+	if err != nil {
+		return nil, fmt.Errorf("读取链路密钥失败: %v", err)
+	}
+	secret, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("链路密钥不是有效的十六进制: %v", err)
+	}
+	return secret, nil
+}
+
+// ParseKey 按命令行写法创建密钥提供者：env:变量名、file:路径 或 keyring:服务/账户，
+// private为true时密钥文件须只有属主可读；空字符串返回nil，表示未配置
+func ParseKey(spec string, private bool) (KeyProvider, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("密钥 %q 格式无效，应为 env:变量名、file:路径 或 keyring:服务/账户", spec)
+	}
+	switch kind {
+	case "env":
+		return EnvKey{Name: value}, nil
+	case "file":
+		return FileKey{Path: value, Private: private}, nil
+	case "keyring":
+		service, account, ok := strings.Cut(value, "/")
+		if !ok || service == "" || account == "" {
+			return nil, fmt.Errorf("钥匙串密钥 %q 格式无效，应为 keyring:服务/账户", spec)
+		}
+		return KeyringKey{Service: service, Account: account}, nil
+	}
+	return nil, fmt.Errorf("未知的密钥来源 %q，应为 env、file 或 keyring", kind)
+}
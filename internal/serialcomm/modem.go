@@ -0,0 +1,9 @@
+package serialcomm
+
+// ModemStatus 串口调制解调器状态线（CTS/DSR/DCD/RI）
+type ModemStatus struct {
+	CTS bool
+	DSR bool
+	DCD bool
+	RI  bool
+}
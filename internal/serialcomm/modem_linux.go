@@ -0,0 +1,83 @@
+//go:build linux
+
+package serialcomm
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openControl 另开一个不会成为控制终端的非阻塞文件描述符，用于ioctl，不影响串口库持有的描述符
+func openControl(name string) (*os.File, error) {
+	return os.OpenFile(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+}
+
+// DrainPort 等待驱动发送缓冲区中的数据全部发出（tcdrain），直到ctx取消。
+// tcdrain本身不可中断：取消时丢弃尚未发出的数据（tcflush），使阻塞的ioctl返回，
+// 等待它返回后再关闭文件描述符，不留下阻塞的goroutine
+func DrainPort(ctx context.Context, name string) error {
+	f, err := openControl(name)
+	if err != nil {
+		return fmt.Errorf("打开串口 %s 排空发送缓冲区失败: %v", name, err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	done := make(chan error, 1)
+	go func() {
+		// TCSBRK参数非0时不发送break，仅等待输出队列清空，即tcdrain
+		done <- unix.IoctlSetInt(fd, unix.TCSBRK, 1)
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			return fmt.Errorf("排空发送缓冲区失败: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	flushErr := unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCOFLUSH)
+	if flushErr != nil {
+		// 无法丢弃输出时ioctl会在数据发完后自行返回，此前不能关闭描述符
+		<-done
+		return fmt.Errorf("排空发送缓冲区超时，丢弃未发出的数据失败: %v", flushErr)
+	}
+	<-done
+	return fmt.Errorf("排空发送缓冲区超时，已丢弃未发出的数据: %w", ctx.Err())
+}
+
+// ModemLines 读取串口状态线的文件描述符，打开一次可反复读取
+type ModemLines struct {
+	f *os.File
+}
+
+// OpenModemLines 另开一个文件描述符用于读取串口的状态线，用完须Close
+func OpenModemLines(name string) (*ModemLines, error) {
+	f, err := openControl(name)
+	if err != nil {
+		return nil, fmt.Errorf("打开串口 %s 读取状态线失败: %v", name, err)
+	}
+	return &ModemLines{f: f}, nil
+}
+
+// Status 读取当前的状态线
+func (m *ModemLines) Status() (ModemStatus, error) {
+	bits, err := unix.IoctlGetInt(int(m.f.Fd()), unix.TIOCMGET)
+	if err != nil {
+		return ModemStatus{}, fmt.Errorf("读取状态线失败: %v", err)
+	}
+	return ModemStatus{
+		CTS: bits&unix.TIOCM_CTS != 0,
+		DSR: bits&unix.TIOCM_DSR != 0,
+		DCD: bits&unix.TIOCM_CAR != 0,
+		RI:  bits&unix.TIOCM_RNG != 0,
+	}, nil
+}
+
+func (m *ModemLines) Close() error {
+	return m.f.Close()
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package serialcomm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"send/internal/ptytest"
+)
+
+func TestDrainPort(t *testing.T) {
+	_, slave := ptytest.Open(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := DrainPort(ctx, slave); err != nil {
+		t.Errorf("排空空闲的串口失败: %v", err)
+	}
+	if err := DrainPort(ctx, "/dev/serialjson-missing"); err == nil {
+		t.Error("不存在的串口未返回错误")
+	}
+}
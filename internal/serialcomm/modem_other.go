@@ -0,0 +1,29 @@
+//go:build !linux
+
+package serialcomm
+
+import (
+	"context"
+	"fmt"
+)
+
+// DrainPort 当前平台的串口库未暴露tcdrain等价接口
+func DrainPort(ctx context.Context, name string) error {
+	return fmt.Errorf("当前平台不支持排空串口 %s 的发送缓冲区", name)
+}
+
+// ModemLines 当前平台的串口库未暴露状态线接口
+type ModemLines struct{}
+
+// OpenModemLines 当前平台的串口库未暴露状态线接口
+func OpenModemLines(name string) (*ModemLines, error) {
+	return nil, fmt.Errorf("当前平台不支持读取串口 %s 的状态线", name)
+}
+
+func (m *ModemLines) Status() (ModemStatus, error) {
+	return ModemStatus{}, fmt.Errorf("当前平台不支持读取状态线")
+}
+
+func (m *ModemLines) Close() error {
+	return nil
+}
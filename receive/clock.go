@@ -0,0 +1,6 @@
+package main
+
+import "send/internal/serialcomm"
+
+// sysClock 当前使用的时间源，测试时可替换为serialcomm.FakeClock
+var sysClock serialcomm.Clock = serialcomm.RealClock{}
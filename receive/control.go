@@ -0,0 +1,16 @@
+package main
+
+// 帧体首字节的类型空间：JSON帧体以'{'等可打印字符开头，0x01~0x0F为帧体编码标记
+// （0x01字典压缩、0x02键名缩短、0x03分片、0x04加密），0x10~0x1F保留给控制帧（0x10握手、0x11诊断、0x12日志、0x13配置），供协议扩展使用
+const (
+	controlFrameMin = 0x10
+	controlFrameMax = 0x1F
+)
+
+// controlType 返回控制帧的类型，不是控制帧时返回false
+func controlType(body []byte) (byte, bool) {
+	if len(body) == 0 || body[0] < controlFrameMin || body[0] > controlFrameMax {
+		return 0, false
+	}
+	return body[0], true
+}
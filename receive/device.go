@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// waitForDevice 等待设备节点出现（容器中映射的 /dev/tty* 可能晚于进程启动），
+// Windows的COM口名称不是文件路径，直接返回
+func waitForDevice(name string, timeout time.Duration) error {
+	if !strings.HasPrefix(name, "/") {
+		return nil
+	}
+	start := sysClock.Now()
+	lastLog := start
+	for {
+		_, err := os.Stat(name)
+		if err == nil {
+			if waited := sysClock.Since(start); waited > 0 {
+				log.Printf("设备 %s 已就绪，等待了 %v", name, waited.Round(time.Millisecond))
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("检查设备 %s 失败: %v", name, err)
+		}
+		if sysClock.Since(start) > timeout {
+			return fmt.Errorf("等待设备 %s 超时 (%v)", name, timeout)
+		}
+		if sysClock.Since(lastLog) >= 5*time.Second {
+			log.Printf("设备 %s 尚未出现，继续等待 (已等待 %v)", name, sysClock.Since(start).Round(time.Second))
+			lastLog = sysClock.Now()
+		}
+		sysClock.Sleep(200 * time.Millisecond)
+	}
+}
+
+// markReady 在收到第一帧有效数据后创建就绪标记文件，供容器的就绪探针检查
+func markReady(path string) {
+	if path == "" {
+		return
+	}
+	err := os.WriteFile(path, []byte(sysClock.Now().Format(time.RFC3339)+"\n"), 0644)
+	if err != nil {
+		log.Printf("写入就绪标记 %s 失败: %v", path, err)
+		return
+	}
+	log.Printf("已写入就绪标记 %s", path)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// diagnosticControlType 诊断控制帧的类型，由对端（通常是MCU）定期发送自己的计数器，
+// 布局为 类型(1) | 接收错误(4) | 缓冲区溢出(4) | 复位次数(4)，均为大端序的累计值；
+// 之后的字节留给以后新增的计数器，旧版本接收端忽略
+const diagnosticControlType = 0x11
+
+// diagnosticFrameLen 诊断帧的最小长度
+const diagnosticFrameLen = 1 + 3*4
+
+// peerCounters 对端上报的计数器
+type peerCounters struct {
+	RxErrors  uint32    `json:"rxErrors"`  // 对端检测到的接收错误（帧错误、校验错误等）
+	Overruns  uint32    `json:"overruns"`  // 对端接收缓冲区溢出次数
+	Resets    uint32    `json:"resets"`    // 对端复位次数
+	Reports   int64     `json:"reports"`   // 收到的诊断帧数
+	UpdatedAt time.Time `json:"updatedAt"` // 最近一次上报的时间，从未上报时为零值
+}
+
+// parseDiagnostic 解析诊断帧，长度不足时返回错误
+func parseDiagnostic(body []byte) (peerCounters, error) {
+	if len(body) < diagnosticFrameLen {
+		return peerCounters{}, serialcomm.ProtocolError("诊断帧长度 %d 不足 %d", len(body), diagnosticFrameLen)
+	}
+	return peerCounters{
+		RxErrors: binary.BigEndian.Uint32(body[1:5]),
+		Overruns: binary.BigEndian.Uint32(body[5:9]),
+		Resets:   binary.BigEndian.Uint32(body[9:13]),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// dictFrameMarker 字典压缩帧的首字节，JSON帧总以 { 开头，不会与之冲突
+const dictFrameMarker = 0x01
+
+// builtinDictID 内置EdgeX字典的编号
+const builtinDictID = 1
+
+// builtinDict 内置字典，必须与发送端完全一致
+var builtinDict = []byte(`"readings":[{"id":"","origin":,"deviceName":"","resourceName":"","profileName":"","valueType":"Int8","value":""}]` +
+	`eyJhcGlWZXJzaW9uIjoidjMiLCJyZXF1ZXN0SWQiOiI` +
+	`{"apiVersion":"v3","receivedTopic":"","correlationID":"","requestID":"","errorCode":0,"payload":"","contentType":"application/json"}`)
+
+// decompressFrame 解压字典压缩的帧体，未压缩的帧原样返回
+func decompressFrame(data []byte, dicts map[byte][]byte, maxLength int) ([]byte, error) {
+	if len(data) == 0 || data[0] != dictFrameMarker {
+		return data, nil
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("压缩帧缺少字典编号")
+	}
+	dict, ok := dicts[data[1]]
+	if !ok {
+		return nil, fmt.Errorf("未知的字典编号 %d", data[1])
+	}
+	r := flate.NewReaderDict(bytes.NewReader(data[2:]), dict)
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxLength)+1))
+	if err != nil {
+		return nil, fmt.Errorf("解压失败: %v", err)
+	}
+	if len(out) > maxLength {
+		return nil, fmt.Errorf("解压后超过最大长度 %d", maxLength)
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// dispatchItem 等待交付的一条消息
+type dispatchItem struct {
+	priority uint8
+	seq      uint64
+	deliver  func()
+}
+
+// dispatchHeap 按优先级从高到低、同优先级按到达顺序排列
+type dispatchHeap []dispatchItem
+
+func (h dispatchHeap) Len() int { return len(h) }
+func (h dispatchHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h dispatchHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *dispatchHeap) Push(x any)   { *h = append(*h, x.(dispatchItem)) }
+func (h *dispatchHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// dispatchQueue 接收循环确认消息后把交付（如JSON Lines输出）放入此队列，由单独的goroutine
+// 按帧级优先级依次执行：下游处理慢时消息在此排队，告警越过排队中的遥测先交付，
+// 而接收和确认不受下游拖累；排队达到MaxQueued时push阻塞，对接收循环施加背压
+type dispatchQueue struct {
+	MaxQueued int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	items    dispatchHeap
+	seq      uint64
+	closed   bool
+	finished chan struct{}
+}
+
+func newDispatchQueue(maxQueued int) *dispatchQueue {
+	q := &dispatchQueue{MaxQueued: maxQueued, finished: make(chan struct{})}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// push 排入一次交付
+func (q *dispatchQueue) push(priority uint8, deliver func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.MaxQueued > 0 && len(q.items) >= q.MaxQueued {
+		q.cond.Wait()
+	}
+	q.seq++
+	heap.Push(&q.items, dispatchItem{priority: priority, seq: q.seq, deliver: deliver})
+	q.cond.Broadcast()
+}
+
+// run 依次执行优先级最高的交付，直到队列关闭且为空
+func (q *dispatchQueue) run() {
+	defer close(q.finished)
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		item := heap.Pop(&q.items).(dispatchItem)
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		item.deliver()
+	}
+}
+
+// close 交付完已排队的消息后返回
+func (q *dispatchQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-q.finished
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDispatchQueuePriority(t *testing.T) {
+	q := newDispatchQueue(0)
+	var got []string
+	release := make(chan struct{})
+	started := make(chan struct{})
+	// 第一条交付阻塞期间排队的消息按优先级交付
+	q.push(0, func() {
+		close(started)
+		<-release
+		got = append(got, "first")
+	})
+	<-started
+	for _, item := range []struct {
+		name     string
+		priority uint8
+	}{{"bulk1", 0}, {"alarm", 7}, {"bulk2", 0}, {"normal", 4}} {
+		name := item.name
+		q.push(item.priority, func() { got = append(got, name) })
+	}
+	close(release)
+	q.close()
+
+	want := []string{"first", "alarm", "normal", "bulk1", "bulk2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("交付顺序 %v，期望 %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// recentError 最近发生的一次错误
+type recentError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// stateRecorder 保留最近的错误和原始帧，供导出状态快照时附带
+type stateRecorder struct {
+	mu     sync.Mutex
+	max    int
+	errors []recentError
+	frames [][]byte
+}
+
+func newStateRecorder(max int) *stateRecorder {
+	return &stateRecorder{max: max}
+}
+
+// recordError 记录一条错误，只保留最近max条
+func (r *stateRecorder) recordError(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, recentError{Time: sysClock.Now(), Message: fmt.Sprintf(format, args...)})
+	if len(r.errors) > r.max {
+		r.errors = r.errors[len(r.errors)-r.max:]
+	}
+}
+
+// recordFrame 记录一个完整的原始帧体（无论校验是否通过），只保留最近max个
+func (r *stateRecorder) recordFrame(frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, append([]byte(nil), frame...))
+	if len(r.frames) > r.max {
+		r.frames = r.frames[len(r.frames)-r.max:]
+	}
+}
+
+// parserState 导出时帧解析器的状态
+type parserState struct {
+	Buffered int    `json:"buffered"`
+	LastData string `json:"lastData"`
+}
+
+// stateDump 用于问题报告的链路状态快照，配置中的密钥类字段已脱敏
+type stateDump struct {
+	Time         time.Time      `json:"time"`
+	Config       map[string]any `json:"config"`
+	Parser       parserState    `json:"parser"`
+	Stats        statsSnapshot  `json:"stats"`
+	RecentErrors []recentError  `json:"recentErrors"`
+	RecentFrames [][]byte       `json:"recentFrames"` // base64编码
+}
+
+// redact 脱敏密钥类配置，只保留是否已设置
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "<已设置>"
+}
+
+// writeStateDump 把状态快照写入path，文件权限为0600
+func writeStateDump(path string, dump stateDump, recorder *stateRecorder) error {
+	recorder.mu.Lock()
+	dump.RecentErrors = append([]recentError(nil), recorder.errors...)
+	dump.RecentFrames = append([][]byte(nil), recorder.frames...)
+	recorder.mu.Unlock()
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态快照失败: %v", err)
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("写入状态快照失败: %v", err)
+	}
+	return nil
+}
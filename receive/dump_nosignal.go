@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package main
+
+import "log"
+
+// notifyDump 当前平台没有SIGUSR1
+func notifyDump(request func()) {
+	log.Println("当前平台不支持用信号触发状态导出")
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyDump 收到SIGUSR1时调用request，如 kill -USR1 <pid>
+func notifyDump(request func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			request()
+		}
+	}()
+}
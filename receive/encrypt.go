@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"send/internal/serialcomm"
+)
+
+// encryptedFrameMarker 加密帧的首字节，其后为 随机数(12) | AES-GCM密文及认证标签
+const encryptedFrameMarker = 0x04
+
+// linkCipher 由链路预共享密钥创建AES-GCM，密钥为十六进制编码的16/24/32字节
+func linkCipher(key serialcomm.KeyProvider) (cipher.AEAD, error) {
+	secret, err := serialcomm.HexKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("链路密钥无效: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptFrame 解密加密帧；配置了链路密钥时拒绝未加密的帧，防止在不安全线路上注入明文
+func decryptFrame(data []byte, key serialcomm.KeyProvider) ([]byte, error) {
+	encrypted := len(data) > 0 && data[0] == encryptedFrameMarker
+	switch {
+	case key == nil && !encrypted:
+		return data, nil
+	case key == nil:
+		return nil, fmt.Errorf("收到加密帧但未配置链路密钥")
+	case !encrypted:
+		return nil, fmt.Errorf("已配置链路密钥，拒绝未加密的帧")
+	}
+
+	gcm, err := linkCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1+gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("加密帧过短 (%d字节)", len(data))
+	}
+	nonce := data[1 : 1+gcm.NonceSize()]
+	plain, err := gcm.Open(nil, nonce, data[1+gcm.NonceSize():], data[:1])
+	if err != nil {
+		return nil, fmt.Errorf("解密失败，密钥不匹配或数据被篡改: %v", err)
+	}
+	return plain, nil
+}
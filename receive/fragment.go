@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// fragmentFrameMarker 分片帧的首字节，其后为 消息编号(2) | 分片序号(2) | 分片总数(2)，均为大端序
+const fragmentFrameMarker = 0x03
+
+// fragmentHeaderSize 分片帧头的长度
+const fragmentHeaderSize = 7
+
+// errStaleFragment 分片属于已完成或已放弃的消息，多为确认丢失后的重发或上一次中断的传输的残留，
+// 接收端应确认并丢弃，不能混入当前消息
+var errStaleFragment = errors.New("过期的分片")
+
+// partialMessage 正在重组的消息
+type partialMessage struct {
+	id        uint16
+	count     int
+	chunkSize int // 除最后一片外每片的长度，即该片在消息中的偏移步长，由第一个非末尾分片确定
+	parts     map[int][]byte
+	size      int
+	started   time.Time
+}
+
+// reassembler 重组分片帧，每个分片单独确认；发送端同时只发送一条消息，因此只有一个活动的重组会话，
+// 出现新的消息编号时放弃当前会话。已完成或放弃的编号在Timeout内被记住，其残留分片被拒绝
+type reassembler struct {
+	MaxSize int           // 重组后的最大长度，防止占用过多内存
+	Timeout time.Duration // 从收到第一个分片起收齐所有分片的时限
+	active  *partialMessage
+	closed  map[uint16]closedMessage // 已完成或放弃的消息
+}
+
+// closedMessage 已结束的消息。编号只有16位，新消息可能在Timeout内碰巧使用相同编号，
+// 因此记住每个已收分片的内容哈希：只有与已收分片完全相同的分片才是重发的残留，
+// 内容不同的分片属于新消息，不能被当作残留确认后丢弃
+type closedMessage struct {
+	at     time.Time
+	count  int
+	hashes map[int][sha256.Size]byte // 分片序号 -> 分片内容的SHA-256
+}
+
+// matches 判断分片是否是已结束消息中某个分片的重发
+func (c closedMessage) matches(index, count int, chunk []byte) bool {
+	if count != c.count {
+		return false
+	}
+	hash, ok := c.hashes[index]
+	return ok && hash == sha256.Sum256(chunk)
+}
+
+// isFragment 判断帧体是否为分片帧
+func isFragment(body []byte) bool {
+	return len(body) > 0 && body[0] == fragmentFrameMarker
+}
+
+// close 结束当前会话并记住其编号
+func (r *reassembler) close(now time.Time) {
+	if r.active == nil {
+		return
+	}
+	if r.closed == nil {
+		r.closed = make(map[uint16]closedMessage)
+	}
+	hashes := make(map[int][sha256.Size]byte, len(r.active.parts))
+	for i, part := range r.active.parts {
+		hashes[i] = sha256.Sum256(part)
+	}
+	r.closed[r.active.id] = closedMessage{at: now, count: r.active.count, hashes: hashes}
+	r.active = nil
+}
+
+// awaiting 判断当前会话是否为消息id且尚缺第index片
+func (r *reassembler) awaiting(id uint16, index int) bool {
+	if r.active == nil || r.active.id != id {
+		return false
+	}
+	_, ok := r.active.parts[index]
+	return !ok
+}
+
+// add 加入一个分片帧，收齐后返回完整的帧体；重复的分片（对端未收到确认而重发）直接覆盖
+func (r *reassembler) add(body []byte) ([]byte, bool, error) {
+	if len(body) < fragmentHeaderSize {
+		return nil, false, fmt.Errorf("分片帧过短 (%d字节)", len(body))
+	}
+	id := binary.BigEndian.Uint16(body[1:3])
+	index := int(binary.BigEndian.Uint16(body[3:5]))
+	count := int(binary.BigEndian.Uint16(body[5:7]))
+	if count == 0 || index >= count {
+		return nil, false, fmt.Errorf("分片序号无效 (%d/%d)", index, count)
+	}
+
+	now := sysClock.Now()
+	for closedID, c := range r.closed {
+		if now.Sub(c.at) > r.Timeout {
+			delete(r.closed, closedID)
+		}
+	}
+	// 相同编号的新消息正在重组且缺少这一片时，内容相同的分片同样可用，不视为残留
+	if c, ok := r.closed[id]; ok && c.matches(index, count, body[fragmentHeaderSize:]) && !r.awaiting(id, index) {
+		return nil, false, fmt.Errorf("%w: 消息 %d 已结束 (分片 %d/%d)", errStaleFragment, id, index, count)
+	}
+	if r.active != nil && now.Sub(r.active.started) > r.Timeout {
+		r.close(now)
+	}
+	if r.active != nil && r.active.id != id {
+		log.Printf("收到新消息 %d 的分片，放弃未收齐的消息 %d (%d/%d片)", id, r.active.id, len(r.active.parts), r.active.count)
+		r.close(now)
+	}
+	if r.active == nil {
+		r.active = &partialMessage{id: id, count: count, parts: make(map[int][]byte), started: now}
+	}
+
+	p := r.active
+	chunk := append([]byte(nil), body[fragmentHeaderSize:]...)
+	if err := p.checkOffset(index, count, len(chunk)); err != nil {
+		r.close(now)
+		return nil, false, err
+	}
+	p.size += len(chunk) - len(p.parts[index])
+	p.parts[index] = chunk
+	if p.size > r.MaxSize {
+		r.close(now)
+		return nil, false, fmt.Errorf("分片消息超过最大长度 %d", r.MaxSize)
+	}
+	if len(p.parts) < p.count {
+		return nil, false, nil
+	}
+
+	r.close(now)
+	full := make([]byte, 0, p.size)
+	for i := 0; i < p.count; i++ {
+		full = append(full, p.parts[i]...)
+	}
+	return full, true, nil
+}
+
+// checkOffset 校验分片与会话一致：总数相同，非末尾分片长度相同（偏移为 序号×长度），末尾分片不长于其他分片
+func (p *partialMessage) checkOffset(index, count, size int) error {
+	if count != p.count {
+		return fmt.Errorf("消息 %d 的分片总数不一致 (%d，会话为 %d)", p.id, count, p.count)
+	}
+	last := index == count-1
+	if !last && p.chunkSize == 0 {
+		p.chunkSize = size
+		for i, part := range p.parts {
+			if i != count-1 && len(part) != size || i == count-1 && len(part) > size {
+				return fmt.Errorf("消息 %d 的分片 %d 长度 %d 与偏移不符", p.id, i, len(part))
+			}
+		}
+	}
+	if p.chunkSize == 0 {
+		return nil
+	}
+	if !last && size != p.chunkSize || last && size > p.chunkSize {
+		return fmt.Errorf("消息 %d 的分片 %d 长度 %d 与偏移不符 (分片长度为 %d)", p.id, index, size, p.chunkSize)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// useFakeClock 在测试期间把sysClock替换为手动推进的时间源
+func useFakeClock(t *testing.T) *serialcomm.FakeClock {
+	clock := serialcomm.NewFakeClock(time.Unix(1000, 0))
+	saved := sysClock
+	sysClock = clock
+	t.Cleanup(func() { sysClock = saved })
+	return clock
+}
+
+// fragment 按发送端的格式构造分片帧
+func fragment(id uint16, index, count int, chunk string) []byte {
+	body := []byte{fragmentFrameMarker}
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = binary.BigEndian.AppendUint16(body, uint16(index))
+	body = binary.BigEndian.AppendUint16(body, uint16(count))
+	return append(body, chunk...)
+}
+
+func TestReassembler(t *testing.T) {
+	type step struct {
+		frame   []byte
+		advance time.Duration // 加入分片前推进的时间
+		want    string        // 收齐后的完整帧体，为空表示未收齐
+		stale   bool          // 期望被判定为残留分片
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"按序收齐", []step{
+			{frame: fragment(1, 0, 3, "aaa")},
+			{frame: fragment(1, 1, 3, "bbb")},
+			{frame: fragment(1, 2, 3, "c"), want: "aaabbbc"},
+		}},
+		{"乱序与重复", []step{
+			{frame: fragment(1, 2, 3, "c")},
+			{frame: fragment(1, 0, 3, "aaa")},
+			{frame: fragment(1, 0, 3, "aaa")},
+			{frame: fragment(1, 1, 3, "bbb"), want: "aaabbbc"},
+		}},
+		{"完成后的重发是残留", []step{
+			{frame: fragment(1, 0, 2, "aa")},
+			{frame: fragment(1, 1, 2, "b"), want: "aab"},
+			{frame: fragment(1, 1, 2, "b"), stale: true},
+		}},
+		{"编号碰撞但内容不同的新消息不被丢弃", []step{
+			{frame: fragment(1, 0, 2, "aa")},
+			{frame: fragment(1, 1, 2, "b"), want: "aab"},
+			{frame: fragment(1, 0, 2, "xx")},
+			{frame: fragment(1, 1, 2, "b"), want: "xxb"},
+		}},
+		{"超时后残留记录过期", []step{
+			{frame: fragment(1, 0, 2, "aa")},
+			{frame: fragment(1, 1, 2, "b"), want: "aab"},
+			{frame: fragment(1, 0, 2, "aa"), advance: time.Minute},
+			{frame: fragment(1, 1, 2, "b"), want: "aab"},
+		}},
+		{"超时放弃未收齐的消息", []step{
+			{frame: fragment(1, 0, 2, "aa")},
+			{frame: fragment(1, 1, 2, "b"), advance: time.Minute},
+			{frame: fragment(1, 0, 2, "aa"), want: "aab"},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := useFakeClock(t)
+			r := &reassembler{MaxSize: 1024, Timeout: 30 * time.Second}
+			for i, s := range tc.steps {
+				clock.Advance(s.advance)
+				full, done, err := r.add(s.frame)
+				if s.stale {
+					if !errors.Is(err, errStaleFragment) {
+						t.Fatalf("第%d步: 返回 %v，期望残留分片", i+1, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("第%d步: %v", i+1, err)
+				}
+				if done != (s.want != "") || !bytes.Equal(full, []byte(s.want)) {
+					t.Fatalf("第%d步: 得到 %q (完成 %v)，期望 %q", i+1, full, done, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerRejectsInconsistentFragments(t *testing.T) {
+	useFakeClock(t)
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"分片过短", [][]byte{{fragmentFrameMarker, 0, 1}}},
+		{"序号超出总数", [][]byte{fragment(1, 2, 2, "a")}},
+		{"分片长度与偏移不符", [][]byte{fragment(1, 0, 3, "aaa"), fragment(1, 1, 3, "bb")}},
+		{"超过最大长度", [][]byte{fragment(1, 0, 2, string(make([]byte, 20))), fragment(1, 1, 2, "b")}},
+	}
+	for _, tc := range tests {
+		r := &reassembler{MaxSize: 16, Timeout: time.Minute}
+		var err error
+		for _, frame := range tc.frames {
+			if _, _, err = r.add(frame); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("%s: 未返回错误", tc.name)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// goldenTimeout 等待对端输出下一条向量的时间
+const goldenTimeout = 10 * time.Second
+
+// verifyGolden 对照金标准语料检查对端实现：对端按语料顺序编码各向量的帧体并写到串口，
+// 本端逐字节比较收到的帧；codec非空时只检查该分帧方式的向量（固件通常只实现其中一种）
+func verifyGolden(config *serial.Config, path, codec string) error {
+	vectors, err := serialcomm.LoadGolden(path)
+	if err != nil {
+		return err
+	}
+	if codec != "" {
+		var selected []serialcomm.GoldenVector
+		for _, v := range vectors {
+			if strings.HasPrefix(v.Name, codec+"/") {
+				selected = append(selected, v)
+			}
+		}
+		vectors = selected
+	}
+	if len(vectors) == 0 {
+		return fmt.Errorf("语料中没有分帧方式 %q 的向量", codec)
+	}
+
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return fmt.Errorf("无法打开串口: %v", err)
+	}
+	defer closePort(port, config.Name)
+	port.Flush()
+
+	log.Printf("等待对端依次输出 %d 条金标准向量", len(vectors))
+	var failed int
+	for _, v := range vectors {
+		_, want, err := v.Decode()
+		if err != nil {
+			return err
+		}
+		got, err := readFull(port, len(want), goldenTimeout)
+		if err != nil {
+			return fmt.Errorf("%s: %w", v.Name, err)
+		}
+		if i := mismatchAt(got, want); i >= 0 {
+			failed++
+			log.Printf("不一致 %s: 第%d字节起不同\n  收到 %x\n  期望 %x", v.Name, i, got, want)
+			continue
+		}
+		log.Printf("一致 %s", v.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d 条向量与金标准不一致", failed, len(vectors))
+	}
+	log.Printf("全部 %d 条向量与金标准一致", len(vectors))
+	return nil
+}
+
+// readFull 在超时前从串口读满n字节
+func readFull(port *serial.Port, n int, timeout time.Duration) ([]byte, error) {
+	buf := make([]byte, n)
+	var got int
+	deadline := sysClock.Now().Add(timeout)
+	for got < n {
+		if sysClock.Now().After(deadline) {
+			return buf[:got], &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: fmt.Errorf("%v内只收到 %d/%d 字节", timeout, got, n)}
+		}
+		m, err := port.Read(buf[got:])
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return buf[:got], serialcomm.PortError("读取失败", err)
+		}
+		got += m
+	}
+	return buf, nil
+}
+
+// mismatchAt 返回两段字节第一个不同的位置，相同时返回-1
+func mismatchAt(got, want []byte) int {
+	if bytes.Equal(got, want) {
+		return -1
+	}
+	for i := range min(len(got), len(want)) {
+		if got[i] != want[i] {
+			return i
+		}
+	}
+	return min(len(got), len(want))
+}
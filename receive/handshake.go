@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"send/internal/serialcomm"
+)
+
+// helloControlType 握手控制帧的类型，帧体为该字节后接发送端能力的JSON
+const helloControlType = 0x10
+
+// helloReplyPrefix 回复握手的前缀，整行为前缀加能力JSON再加换行
+const helloReplyPrefix = "HELLO "
+
+// crcAlgorithm 帧校验使用的CRC算法名称，握手时交换
+const crcAlgorithm = "crc16-modbus"
+
+// peerIdentity 握手时通告的设备身份，字段均可为空
+type peerIdentity struct {
+	Serial   string `json:"serial,omitempty"`   // 设备序列号
+	Model    string `json:"model,omitempty"`    // 设备型号
+	Firmware string `json:"firmware,omitempty"` // 固件版本，如 1.4.2
+}
+
+// capabilities 握手时交换的协议能力，列表为空表示不支持或未使用该特性
+type capabilities struct {
+	Versions []int    `json:"versions,omitempty"` // 帧版本头，不使用版本头时为空
+	MaxFrame int      `json:"maxFrame"`           // 最大帧体长度，0表示不限
+	Dicts    []int    `json:"dicts,omitempty"`    // 压缩字典编号，0为不带字典的普通DEFLATE
+	KeyMaps  []int    `json:"keyMaps,omitempty"`  // 键名映射表版本
+	CRC      []string `json:"crc"`                // CRC算法，按优先级排列
+
+	Identity *peerIdentity `json:"identity,omitempty"` // 本端身份，旧版本不通告
+}
+
+// codecVersions 返回分帧方式接受的全部版本头，穿过认证和同步标记等外层包装
+func codecVersions(codec serialcomm.FrameCodec) []int {
+	switch c := codec.(type) {
+	case serialcomm.VersionedCodec:
+		var versions []int
+		for v := range c.Versions {
+			versions = append(versions, int(v))
+		}
+		sort.Ints(versions)
+		return versions
+	case serialcomm.HMACCodec:
+		return codecVersions(c.Inner)
+	case serialcomm.SyncCodec:
+		return codecVersions(c.Inner)
+	}
+	return nil
+}
+
+// sortedKeys 返回map的键（按升序），用于列出支持的字典和映射表版本
+func sortedKeys[V any](m map[byte]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// helloReply 解析握手帧中的发送端能力，返回要回复的一行；协商由发送端完成
+func helloReply(body []byte, local capabilities) (capabilities, string, error) {
+	var peer capabilities
+	err := json.Unmarshal(body[1:], &peer)
+	if err != nil {
+		return peer, "", serialcomm.ProtocolError("握手帧无效: %v", err)
+	}
+	reply, err := json.Marshal(local)
+	if err != nil {
+		return peer, "", err
+	}
+	return peer, helloReplyPrefix + string(reply) + "\n", nil
+}
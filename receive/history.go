@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// historyHeader 统计历史CSV的列，计数均为接收端启动以来的累计值
+var historyHeader = []string{"time", "port", "frames", "bytes", "retries", "gaps", "lostFrames", "replays", "rxBytesPerSec", "utilization"}
+
+// statsHistory 定期把统计快照追加到CSV文件，用于跨天观察链路质量的变化趋势，
+// 如与温度、施工等环境变化对照
+type statsHistory struct {
+	File     string
+	Port     string
+	Interval time.Duration
+}
+
+// run 按Interval持续追加快照，写入失败只记录日志
+func (h statsHistory) run(stats *receiveStats) {
+	for {
+		sysClock.Sleep(h.Interval)
+		err := h.record(sysClock.Now(), stats.snapshot())
+		if err != nil {
+			log.Printf("保存统计历史失败: %v", err)
+		}
+	}
+}
+
+// record 追加一行快照，文件为空时先写表头
+func (h statsHistory) record(now time.Time, snap statsSnapshot) error {
+	f, err := os.OpenFile(h.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		err = w.Write(historyHeader)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Write([]string{
+		now.Format(time.RFC3339),
+		h.Port,
+		strconv.FormatInt(snap.Frames, 10),
+		strconv.FormatInt(snap.Bytes, 10),
+		strconv.FormatInt(snap.Link.Retries, 10),
+		strconv.FormatInt(snap.Gaps, 10),
+		strconv.FormatInt(snap.LostFrames, 10),
+		strconv.FormatInt(snap.Replays, 10),
+		strconv.FormatFloat(snap.Link.RxBps, 'f', 1, 64),
+		strconv.FormatFloat(snap.Link.Utilization, 'f', 4, 64),
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// historyRow 统计历史中的一行
+type historyRow struct {
+	Time     time.Time
+	Port     string
+	Counters [6]int64 // frames, bytes, retries, gaps, lostFrames, replays
+}
+
+// dailyTrend 某串口某天的汇总，由相邻快照的计数差值累加而来
+type dailyTrend struct {
+	Port     string
+	Day      string
+	Seconds  float64
+	Counters [6]int64
+}
+
+// readHistory 读取统计历史CSV
+func readHistory(r io.Reader) ([]historyRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var rows []historyRow
+	for i, record := range records {
+		if i == 0 || len(record) < 8 {
+			continue // 跳过表头和不完整的行
+		}
+		t, err := time.Parse(time.RFC3339, record[0])
+		if err != nil {
+			return nil, fmt.Errorf("第%d行时间无效: %v", i+1, err)
+		}
+		row := historyRow{Time: t, Port: record[1]}
+		for j := range row.Counters {
+			row.Counters[j], err = strconv.ParseInt(record[2+j], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("第%d行第%d列无效: %v", i+1, 3+j, err)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// dailyTrends 按串口和日期汇总；计数变小说明接收端重启过，此时以新值作为增量
+func dailyTrends(rows []historyRow) []dailyTrend {
+	byKey := make(map[[2]string]*dailyTrend)
+	last := make(map[string]historyRow)
+	for _, row := range rows {
+		prev, ok := last[row.Port]
+		last[row.Port] = row
+		if !ok {
+			continue
+		}
+		key := [2]string{row.Port, row.Time.Local().Format("2006-01-02")}
+		trend := byKey[key]
+		if trend == nil {
+			trend = &dailyTrend{Port: key[0], Day: key[1]}
+			byKey[key] = trend
+		}
+		restarted := row.Counters[0] < prev.Counters[0]
+		for j, v := range row.Counters {
+			if restarted {
+				trend.Counters[j] += v
+			} else {
+				trend.Counters[j] += v - prev.Counters[j]
+			}
+		}
+		trend.Seconds += row.Time.Sub(prev.Time).Seconds()
+	}
+
+	trends := make([]dailyTrend, 0, len(byKey))
+	for _, trend := range byKey {
+		trends = append(trends, *trend)
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Port != trends[j].Port {
+			return trends[i].Port < trends[j].Port
+		}
+		return trends[i].Day < trends[j].Day
+	})
+	return trends
+}
+
+// reportHistory 读取统计历史文件，按天输出每个串口的帧数、吞吐和错误率
+func reportHistory(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rows, err := readHistory(f)
+	if err != nil {
+		return fmt.Errorf("读取统计历史失败: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "串口\t日期\t帧数\t吞吐(字节/秒)\t重传率\t丢帧\t重放")
+	for _, t := range dailyTrends(rows) {
+		frames, bytes, retries, lost, replays := t.Counters[0], t.Counters[1], t.Counters[2], t.Counters[4], t.Counters[5]
+		var throughput, retryRate float64
+		if t.Seconds > 0 {
+			throughput = float64(bytes) / t.Seconds
+		}
+		if frames+retries > 0 {
+			retryRate = float64(retries) / float64(frames+retries)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%.2f%%\t%d\t%d\n", t.Port, t.Day, frames, throughput, retryRate*100, lost, replays)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// keyMapFrameMarker 键名缩短帧的首字节，其后一个字节为映射表版本
+const keyMapFrameMarker = 0x02
+
+// inlinePayloadKey 缩短后的消息中，内嵌（非base64）payload JSON使用的键
+const inlinePayloadKey = "P"
+
+// keyMapTables 按版本划分的键名映射表（长键名 -> 短键名），已发布的版本不能修改，只能新增版本
+var keyMapTables = map[byte]map[string]string{
+	1: {
+		// 消息信封
+		"apiVersion":    "a",
+		"receivedTopic": "t",
+		"correlationID": "c",
+		"requestID":     "r",
+		"errorCode":     "e",
+		"payload":       "p",
+		"contentType":   "ct",
+		"signature":     "sg",
+		"certificate":   "cr",
+		"sequence":      "sq",
+		// EdgeX事件与读数
+		"requestId":    "ri",
+		"event":        "ev",
+		"id":           "i",
+		"deviceName":   "d",
+		"profileName":  "pn",
+		"sourceName":   "sn",
+		"origin":       "o",
+		"readings":     "rd",
+		"resourceName": "rn",
+		"valueType":    "vt",
+		"value":        "v",
+	},
+}
+
+// renameKeys 按映射表重命名JSON中所有对象的键，保持键的顺序和其他内容不变，输出为紧凑格式
+func renameKeys(data []byte, table map[string]string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	type container struct {
+		object    bool // 是否为对象（否则为数组）
+		expectKey bool // 对象中下一个记号是否为键
+		first     bool // 是否尚未写入任何成员
+	}
+	var stack []container
+	var buf bytes.Buffer
+
+	// valueDone 在一个完整的值写入后，让所在对象转而期待下一个键
+	valueDone := func() {
+		if n := len(stack); n > 0 && stack[n-1].object {
+			stack[n-1].expectKey = true
+		}
+	}
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			buf.WriteByte(byte(d))
+			stack = stack[:len(stack)-1]
+			valueDone()
+			continue
+		}
+
+		isKey := false
+		if n := len(stack); n > 0 {
+			top := &stack[n-1]
+			isKey = top.object && top.expectKey
+			if top.object && !top.expectKey {
+				buf.WriteByte(':')
+			} else if !top.first {
+				buf.WriteByte(',')
+			}
+			top.first = false
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			buf.WriteByte(byte(d))
+			stack = append(stack, container{object: d == '{', expectKey: true, first: true})
+			continue
+		}
+
+		if s, ok := tok.(string); ok && isKey {
+			if renamed, ok := table[s]; ok {
+				tok = renamed
+			}
+		}
+		encoded, err := json.Marshal(tok)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+		if isKey {
+			stack[len(stack)-1].expectKey = false
+		} else {
+			valueDone()
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// expandFrame 将键名缩短的帧还原为普通消息JSON，其他帧原样返回
+func expandFrame(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != keyMapFrameMarker {
+		return data, nil
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("键名缩短帧缺少映射表版本")
+	}
+	table, ok := keyMapTables[data[1]]
+	if !ok {
+		return nil, fmt.Errorf("未知的键名映射表版本 %d", data[1])
+	}
+	reverse := make(map[string]string, len(table))
+	for long, short := range table {
+		reverse[short] = long
+	}
+
+	expanded, err := renameKeys(data[2:], reverse)
+	if err != nil {
+		return nil, fmt.Errorf("还原键名失败: %v", err)
+	}
+	var message Message
+	err = json.Unmarshal(expanded, &message)
+	if err != nil {
+		return nil, fmt.Errorf("解析还原后的消息失败: %v", err)
+	}
+	if inline, ok := message.Extra[inlinePayloadKey]; ok {
+		payload, err := renameKeys(inline, reverse)
+		if err != nil {
+			return nil, fmt.Errorf("还原payload键名失败: %v", err)
+		}
+		message.Payload = base64.StdEncoding.EncodeToString(payload)
+		delete(message.Extra, inlinePayloadKey)
+	}
+	return json.Marshal(message)
+}
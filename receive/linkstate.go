@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// linkStateCallback 对端静默或恢复时调用，up为false时silentFor为已静默的时长，
+// up为true时为恢复前静默的总时长
+type linkStateCallback func(up bool, silentFor time.Duration)
+
+// linkMonitor 根据收到任何字节（数据帧或保活帧）的时间判断对端是否在线；
+// 对端按心跳间隔发送保活帧时，SilenceAfter应为该间隔的数倍，使断线与暂时没有数据可以区分
+type linkMonitor struct {
+	SilenceAfter time.Duration
+	OnChange     linkStateCallback
+
+	last   time.Time
+	silent bool
+}
+
+// seen 记录在now收到了数据，此前判定为静默时通知恢复
+func (m *linkMonitor) seen(now time.Time) {
+	if m.silent {
+		m.silent = false
+		if m.OnChange != nil {
+			m.OnChange(true, now.Sub(m.last))
+		}
+	}
+	m.last = now
+}
+
+// check 距上次收到数据超过SilenceAfter时通知静默，每次静默只通知一次
+func (m *linkMonitor) check(now time.Time) {
+	if m.silent || m.last.IsZero() || now.Sub(m.last) <= m.SilenceAfter {
+		return
+	}
+	m.silent = true
+	if m.OnChange != nil {
+		m.OnChange(false, now.Sub(m.last))
+	}
+}
@@ -0,0 +1,169 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"send/internal/ptytest"
+	"send/internal/serialcomm"
+)
+
+// startReceiver 在伪终端的从端上运行接收循环，握手成功后返回主端；测试结束时停止接收循环
+func startReceiver(t *testing.T, opts receiveOptions) *os.File {
+	t.Helper()
+	master, slave := ptytest.Open(t)
+	opts.Port = slave
+	if opts.Baud == 0 {
+		opts.Baud = 115200
+	}
+	if opts.SilenceAfter == 0 {
+		opts.SilenceAfter = time.Minute
+	}
+	if opts.OKToken == "" {
+		opts.OKToken, opts.RetryToken, opts.AuthToken = "OK", "RETRY", "AUTH"
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		run(opts)
+	}()
+	t.Cleanup(func() {
+		stopRequested.Store(true)
+		<-stopped
+		stopRequested.Store(false)
+	})
+
+	// 接收循环开始监听前写入的数据会被清空，反复握手直到收到回复
+	body := append([]byte{helloControlType}, "{}"...)
+	if opts.LinkKey != "" {
+		body = sealFrame(t, opts.LinkKey, body)
+	}
+	hello, err := serialcomm.LengthCRCCodec{}.Encode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 0; ; attempt++ {
+		if _, err := master.Write(hello); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ptytest.ReadUntil(master, 500*time.Millisecond, helloReplyPrefix); err == nil {
+			return master
+		}
+		if attempt == 20 {
+			t.Fatal("接收循环未应答握手")
+		}
+	}
+}
+
+// sealFrame 按发送端的格式用链路密钥加密帧体
+func sealFrame(t *testing.T, keySpec string, body []byte) []byte {
+	t.Helper()
+	key, err := serialcomm.ParseKey(keySpec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := linkCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	frame := append([]byte{encryptedFrameMarker}, nonce...)
+	return gcm.Seal(frame, nonce, body, []byte{encryptedFrameMarker})
+}
+
+// testMessage 构造一条可以成功解析和交付的消息JSON
+func testMessage(t *testing.T, device string) []byte {
+	t.Helper()
+	payload, err := json.Marshal(Payload{Event: Event{DeviceName: device}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := json.Marshal(Message{Payload: base64.StdEncoding.EncodeToString(payload)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// TestLoopbackCoalescedWindowFrames 一次写入的多个窗口帧（发送端合并写出）须逐帧处理并确认
+func TestLoopbackCoalescedWindowFrames(t *testing.T) {
+	master := startReceiver(t, receiveOptions{})
+
+	var burst []byte
+	for seq, device := range []string{"a", "b", "c"} {
+		frame, err := serialcomm.LengthCRCCodec{}.Encode(windowFrame(9, uint16(seq), 0, string(testMessage(t, device))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		burst = append(burst, frame...)
+	}
+	if _, err := master.Write(burst); err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := ptytest.ReadUntil(master, 5*time.Second, "ACK00000", "ACK00001", "ACK00002")
+	if err != nil {
+		t.Fatalf("%v，收到 %q", err, received)
+	}
+}
+
+// TestLoopbackLinkKeyRejectsPlaintextControl 配置了链路密钥时，线路上注入的明文控制帧与数据帧一样被拒绝
+func TestLoopbackLinkKeyRejectsPlaintextControl(t *testing.T) {
+	t.Setenv("TEST_LINK_KEY", "000102030405060708090a0b0c0d0e0f")
+	master := startReceiver(t, receiveOptions{LinkKey: "env:TEST_LINK_KEY"})
+
+	hello, err := serialcomm.LengthCRCCodec{}.Encode(append([]byte{helloControlType}, "{}"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.Write(hello); err != nil {
+		t.Fatal(err)
+	}
+	received, err := ptytest.ReadUntil(master, 5*time.Second, "RETRY")
+	if err != nil {
+		t.Fatalf("%v，收到 %q", err, received)
+	}
+	if bytes.Contains(received, []byte(helloReplyPrefix)) {
+		t.Fatalf("明文握手帧得到了回复: %q", received)
+	}
+}
+
+// TestLoopbackRequireSignature 配置了受信任的密钥时，去掉签名的消息被拒绝而不是只标注
+func TestLoopbackRequireSignature(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "device.pub")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	master := startReceiver(t, receiveOptions{TrustedKeys: stringList{"file:" + keyFile}, RequireSignature: true})
+
+	frame, err := serialcomm.LengthCRCCodec{}.Encode(testMessage(t, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	received, err := ptytest.ReadUntil(master, 5*time.Second, "AUTH")
+	if err != nil {
+		t.Fatalf("%v，收到 %q", err, received)
+	}
+}
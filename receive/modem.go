@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// watchModemStatus 周期性读取状态线，在任一状态线变化时调用onChange，ctx取消时返回。
+// 串口只打开一次，读取失败（如USB串口被拔出）时关闭并在下一周期重新打开；
+// 首次打开或读取失败（如平台不支持）时直接返回，不影响数据接收
+func watchModemStatus(ctx context.Context, name string, interval time.Duration, onChange func(prev, cur serialcomm.ModemStatus)) {
+	lines, err := serialcomm.OpenModemLines(name)
+	if err != nil {
+		log.Printf("状态线监视未启用: %v", err)
+		return
+	}
+	defer func() {
+		if lines != nil {
+			lines.Close()
+		}
+	}()
+	prev, err := lines.Status()
+	if err != nil {
+		log.Printf("状态线监视未启用: %v", err)
+		return
+	}
+	log.Printf("初始状态线: %+v", prev)
+
+	for {
+		sysClock.Sleep(interval)
+		if ctx.Err() != nil {
+			return
+		}
+		if lines == nil {
+			if lines, err = serialcomm.OpenModemLines(name); err != nil {
+				continue
+			}
+		}
+		cur, err := lines.Status()
+		if err != nil {
+			log.Printf("%v，重新打开串口", err)
+			lines.Close()
+			lines = nil
+			continue
+		}
+		if cur != prev {
+			onChange(prev, cur)
+			prev = cur
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// discardInput 在窗口期内读取并丢弃串口输入，用于吸收打开串口时常见的0x00/0xFF噪声
+func discardInput(port *serial.Port, window time.Duration) (int, error) {
+	discard := make([]byte, 256)
+	var discarded int
+	deadline := sysClock.Now().Add(window)
+	for sysClock.Now().Before(deadline) {
+		n, err := port.Read(discard)
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return discarded, err
+		}
+		discarded += n
+	}
+	return discarded, port.Flush()
+}
+
+// decodeSkippingNoise 缓冲区以噪声字节开头且无法解码时，跳过开头的噪声再尝试解码，
+// 直到遇到能完整解码的帧；返回丢弃的字节数。噪声可能恰好组成一个看似合理的长度前缀
+// （如 00 00 00 FF），因此数据不足时也尝试从噪声之后解码。
+// 出错后会自行跳过整帧的编解码不能使用，否则会吃掉下一帧的首字节
+func decodeSkippingNoise(codec serialcomm.FrameCodec, buffer *bytes.Buffer, noise []byte) ([]byte, int, error) {
+	body, err := codec.Decode(buffer)
+	if err == nil {
+		return body, 0, nil
+	}
+
+	data := buffer.Bytes()
+	for skip := 1; skip <= len(data) && bytes.IndexByte(noise, data[skip-1]) >= 0; skip++ {
+		rest := bytes.NewBuffer(data[skip:])
+		before := rest.Len()
+		candidate, candidateErr := codec.Decode(rest)
+		if candidateErr == nil {
+			buffer.Next(skip + before - rest.Len())
+			return candidate, skip, nil
+		}
+	}
+	return nil, 0, err
+}
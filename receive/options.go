@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// receiveOptions 接收端的运行参数，均可在命令行设置，安装为服务时原样传给服务；
+// 默认值即未配置时的行为
+type receiveOptions struct {
+	JSONL    bool
+	DumpFile string
+
+	Port    string
+	Baud    int
+	Framing serialcomm.Framing // 分帧方式，须与发送端一致
+
+	TrustedKeys stringList // 受信任的设备公钥（PKIX PEM），如 file:device.pub
+	TrustedCA   string     // 签发设备证书的CA
+	// RequireSignature 配置了受信任的密钥（含配置下发保存的密钥）时拒绝未签名的消息，
+	// 否则攻击者去掉签名即可绕过校验；关闭后未签名的消息只在输出中标注
+	RequireSignature bool
+	ProvisionDir     string // 配置下发保存密钥的目录
+	MACKey           string // 帧认证的预共享密钥（十六进制）
+	LinkKey          string // 链路加密的预共享密钥（十六进制AES密钥）
+	PairingCode      string // 配置下发的一次性配对码，为空时拒绝下发
+	ReplayState      string // 重放保护的状态文件，为空时不做重放保护
+
+	// 反馈字符串，须与发送端的 -ok-token 等一致（旧固件可能使用 "NAK" 等其他字符串）
+	OKToken    string
+	RetryToken string
+	AuthToken  string
+
+	ReadOnly        bool
+	ReplyTurnaround time.Duration
+	DiscardWindow   time.Duration
+	SilenceAfter    time.Duration
+	DeliverRaw      bool
+
+	StatsAddr    string
+	HistoryFile  string
+	ReadyFile    string
+	RouteTable   string
+	Dict         string
+	PeerLogFile  string
+	RemoteConfig string
+
+	// 握手时通告的本机身份，发送端据此识别设备并按允许列表拒绝不认识的设备或固件
+	DeviceSerial string
+	DeviceModel  string
+	Firmware     string
+}
+
+// registerFlags 注册运行参数的命令行选项
+func (o *receiveOptions) registerFlags() {
+	// --jsonl: 每条解析成功的消息以一行JSON输出到标准输出（日志仍输出到标准错误），便于配合jq等工具
+	flag.BoolVar(&o.JSONL, "jsonl", false, "以JSON Lines格式将解析的消息输出到标准输出")
+	// --dump-file: 收到SIGUSR1时把配置、解析器状态、统计、最近的错误和原始帧写入该文件，便于提交问题报告
+	flag.StringVar(&o.DumpFile, "dump-file", "", "状态快照文件，收到SIGUSR1时写入")
+
+	flag.StringVar(&o.Port, "port", "com7", "串口名称")
+	flag.IntVar(&o.Baud, "baud", 115200, "波特率")
+	o.Framing.RegisterFlags(flag.CommandLine)
+
+	// 密钥可来自文件、环境变量或系统钥匙串，每分钟重新获取以支持不停机轮换
+	flag.Var(&o.TrustedKeys, "trusted-key", "受信任的设备公钥（PKIX PEM格式的Ed25519公钥），如 file:device.pub，可重复")
+	flag.StringVar(&o.TrustedCA, "trusted-ca", "", "签发设备证书的CA证书，如 file:ca.pem")
+	flag.BoolVar(&o.RequireSignature, "require-signature", true, "配置了受信任的密钥时拒绝未签名的消息，设为false时只在输出中标注")
+	flag.StringVar(&o.ProvisionDir, "provision-dir", "provisioned", "配置下发保存密钥的目录，未指定受信任密钥时从此加载")
+	flag.StringVar(&o.MACKey, "mac-key", "", "帧认证的预共享密钥（十六进制），如 env:SERIALJSON_MAC_KEY，须与发送端一致")
+	flag.StringVar(&o.LinkKey, "link-key", "", "链路加密的预共享密钥（十六进制AES密钥），如 env:SERIALJSON_LINK_KEY，须与发送端一致")
+	flag.StringVar(&o.PairingCode, "pairing-code", "", "一次性配对码，设置后接受发送端下发的密钥")
+	flag.StringVar(&o.ReplayState, "replay-state", "", "重放保护的状态文件，只接受序号递增的消息（发送端需开启序号）")
+
+	flag.StringVar(&o.OKToken, "ok-token", "OK", "确认帧时回复的字符串，须与发送端一致")
+	flag.StringVar(&o.RetryToken, "retry-token", "RETRY", "请求重传时回复的字符串，须与发送端一致")
+	flag.StringVar(&o.AuthToken, "auth-token", "AUTH", "帧认证或签名失败时回复的字符串，提示发送端不必重传")
+
+	flag.BoolVar(&o.ReadOnly, "read-only", false, "被动监听，只解析和输出帧，从不向串口写入反馈")
+	flag.DurationVar(&o.ReplyTurnaround, "reply-turnaround", 0, "RS-485半双工回复前等待发送端释放总线的时间，如 5ms")
+	flag.DurationVar(&o.DiscardWindow, "discard-window", 200*time.Millisecond, "打开串口后丢弃线路噪声的时间窗口")
+	flag.DurationVar(&o.SilenceAfter, "silence-after", 30*time.Second, "对端静默超过该时间时告警，应为发送端心跳间隔的数倍")
+	flag.BoolVar(&o.DeliverRaw, "deliver-raw", false, "JSON解析失败的帧确认后原样交付，而不是请求重传")
+
+	flag.StringVar(&o.StatsAddr, "stats-addr", "", "HTTP统计接口的监听地址，如 127.0.0.1:9100")
+	flag.StringVar(&o.HistoryFile, "history-file", "", "定期追加统计快照的文件，用 --stats-report 查看趋势")
+	flag.StringVar(&o.ReadyFile, "ready-file", "", "收到第一帧有效数据后创建的就绪标记文件")
+	flag.StringVar(&o.RouteTable, "route-table", "", "学习到的设备-串口路由表文件，供发送端自动路由")
+	flag.StringVar(&o.Dict, "dict", "", "用发送端 -train-dict 训练的压缩字典（编号2）")
+	flag.StringVar(&o.PeerLogFile, "peer-log", "", "对端日志帧的输出文件，为空时写入本程序的日志")
+	flag.StringVar(&o.RemoteConfig, "remote-config", "", "可由发送端读写的设备配置文件，为空时拒绝配置请求")
+
+	flag.StringVar(&o.DeviceSerial, "device-serial", "", "握手时通告的设备序列号")
+	flag.StringVar(&o.DeviceModel, "device-model", "", "握手时通告的设备型号")
+	flag.StringVar(&o.Firmware, "firmware", "", "握手时通告的固件版本，如 1.4.2")
+}
+
+// identity 返回握手时通告的本机身份，均未设置时返回nil
+func (o *receiveOptions) identity() *peerIdentity {
+	if o.DeviceSerial == "" && o.DeviceModel == "" && o.Firmware == "" {
+		return nil
+	}
+	return &peerIdentity{Serial: o.DeviceSerial, Model: o.DeviceModel, Firmware: o.Firmware}
+}
+
+// validate 检查参数取值和组合，返回的错误逐条列出每个无效参数及修正方法
+func (o *receiveOptions) validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if o.Port == "" {
+		invalid("-port 为空：请指定串口名称，如 COM7 或 /dev/ttyUSB0")
+	}
+	if o.Baud <= 0 {
+		invalid("-baud %d 无效：应为正数，如 9600 或 115200", o.Baud)
+	}
+	if err := o.Framing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if o.DiscardWindow < 0 || o.ReplyTurnaround < 0 {
+		invalid("-discard-window/-reply-turnaround 不能为负数：不需要等待时设为0")
+	}
+	if o.SilenceAfter <= 0 {
+		invalid("-silence-after 应为正数：应为发送端心跳间隔的数倍，如 30s")
+	}
+
+	for _, spec := range o.TrustedKeys {
+		if _, err := serialcomm.ParseKey(spec, false); err != nil {
+			invalid("-trusted-key: %v", err)
+		}
+	}
+	for _, key := range []struct{ name, spec string }{
+		{"-trusted-ca", o.TrustedCA}, {"-mac-key", o.MACKey}, {"-link-key", o.LinkKey},
+	} {
+		if _, err := serialcomm.ParseKey(key.spec, false); err != nil {
+			invalid("%s: %v", key.name, err)
+		}
+	}
+
+	tokens := map[string]string{"-ok-token": o.OKToken, "-retry-token": o.RetryToken, "-auth-token": o.AuthToken}
+	for _, name := range []string{"-ok-token", "-retry-token", "-auth-token"} {
+		token := tokens[name]
+		switch {
+		case token == "":
+			invalid("%s 为空：发送端须能从反馈中识别该字符串", name)
+		case serialcomm.ShadowsWindowAck(token):
+			invalid("%s %q 是滑动窗口累积确认（如 %s）的前缀，发送端无法区分", name, token, serialcomm.WindowAck(12))
+		}
+	}
+	if o.OKToken == o.RetryToken || o.OKToken == o.AuthToken || o.RetryToken == o.AuthToken {
+		invalid("-ok-token/-retry-token/-auth-token 须各不相同：发送端按字符串区分确认、重传和认证失败")
+	}
+
+	// 只读模式从不回复，依赖回复的功能无法工作
+	if o.ReadOnly && o.PairingCode != "" {
+		invalid("-pairing-code 不能与 -read-only 同时使用：配置下发须回复发送端")
+	}
+	if o.ReadOnly && o.RemoteConfig != "" {
+		invalid("-remote-config 不能与 -read-only 同时使用：配置请求须回复发送端")
+	}
+	if o.StatsAddr != "" {
+		if _, _, err := net.SplitHostPort(o.StatsAddr); err != nil {
+			invalid("-stats-addr %q 无效：应为 主机:端口，如 127.0.0.1:9100", o.StatsAddr)
+		}
+	}
+	for _, path := range []string{o.Dict, o.RemoteConfig} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			invalid("文件 %s 不可用: %v", path, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keys 解析受信任的公钥和CA，每分钟重新获取
+func (o *receiveOptions) keys() (trusted []serialcomm.KeyProvider, ca serialcomm.KeyProvider, err error) {
+	for _, spec := range o.TrustedKeys {
+		key, err := serialcomm.ParseKey(spec, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		trusted = append(trusted, &serialcomm.CachedKey{Provider: key, TTL: time.Minute})
+	}
+	ca, err = serialcomm.ParseKey(o.TrustedCA, false)
+	if err != nil || ca == nil {
+		return trusted, nil, err
+	}
+	return trusted, &serialcomm.CachedKey{Provider: ca, TTL: time.Minute}, nil
+}
+
+// serviceArgs 返回命令行上显式设置的选项（不含--service），安装服务时原样传给服务
+func serviceArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "service" {
+			return
+		}
+		if list, ok := f.Value.(*stringList); ok {
+			for _, value := range *list {
+				args = append(args, fmt.Sprintf("--%s=%s", f.Name, value))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+	})
+	return args
+}
+
+// stringList 可重复的字符串选项
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
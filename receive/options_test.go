@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceiveOptionsValidate(t *testing.T) {
+	valid := receiveOptions{Port: "com7", Baud: 115200, SilenceAfter: 30 * time.Second, OKToken: "OK", RetryToken: "RETRY", AuthToken: "AUTH"}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("有效的参数被拒绝: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(o *receiveOptions)
+		want   []string // 错误中应提到的参数
+	}{
+		{"波特率和静默阈值", func(o *receiveOptions) { o.Baud = 0; o.SilenceAfter = 0 }, []string{"-baud", "-silence-after"}},
+		{"只读模式下的配置下发", func(o *receiveOptions) { o.ReadOnly = true; o.PairingCode = "1234" }, []string{"-pairing-code"}},
+		{"密钥格式", func(o *receiveOptions) { o.TrustedKeys = stringList{"device.pub"}; o.LinkKey = "vault:x" }, []string{"-trusted-key", "-link-key"}},
+		{"统计地址", func(o *receiveOptions) { o.StatsAddr = "9100" }, []string{"-stats-addr"}},
+		{"与累积确认混淆的反馈", func(o *receiveOptions) { o.OKToken = "ACK" }, []string{"-ok-token"}},
+		{"相同的反馈", func(o *receiveOptions) { o.RetryToken = "AUTH" }, []string{"-retry-token"}},
+		{"空反馈", func(o *receiveOptions) { o.AuthToken = "" }, []string{"-auth-token"}},
+	}
+	for _, tc := range tests {
+		o := valid
+		tc.modify(&o)
+		err := o.validate()
+		if err == nil {
+			t.Errorf("%s: 未返回错误", tc.name)
+			continue
+		}
+		for _, field := range tc.want {
+			if !strings.Contains(err.Error(), field) {
+				t.Errorf("%s: 错误未提到 %s: %v", tc.name, field, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"send/internal/serialcomm"
+)
+
+// configControlType 配置控制帧的类型，帧体为该字节后接configRequest的JSON
+const configControlType = 0x13
+
+// configReplyPrefix 回复配置请求的前缀，整行为前缀加configReply的JSON再加换行
+const configReplyPrefix = "CONFIG "
+
+// configRequest 发送端读取或修改本端配置的请求，见发送端的同名类型
+type configRequest struct {
+	Op      string          `json:"op"`
+	Key     string          `json:"key,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Version uint64          `json:"version"`
+}
+
+// configReply 对配置请求的回复，Error非空表示请求被拒绝
+type configReply struct {
+	Version uint64                     `json:"version"`
+	Values  map[string]json.RawMessage `json:"values"`
+	Staged  map[string]json.RawMessage `json:"staged,omitempty"`
+	Error   string                     `json:"error,omitempty"`
+}
+
+// configStore 可由发送端远程修改的配置，提交后写入文件；
+// 修改先暂存，commit时才生效并使版本加一
+type configStore struct {
+	File string `json:"-"`
+
+	Version uint64                     `json:"version"`
+	Values  map[string]json.RawMessage `json:"values"`
+
+	staged    map[string]json.RawMessage
+	committed uint64 // 最近一次commit请求携带的版本，用于识别重发的commit
+}
+
+// loadConfigStore 读取配置文件，文件不存在时从空配置开始
+func loadConfigStore(file string) (*configStore, error) {
+	store := &configStore{File: file, Values: make(map[string]json.RawMessage)}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	err = json.Unmarshal(data, store)
+	if err != nil {
+		return nil, fmt.Errorf("配置文件 %s 无效: %v", file, err)
+	}
+	if store.Values == nil {
+		store.Values = make(map[string]json.RawMessage)
+	}
+	return store, nil
+}
+
+// handle 执行一个配置请求；重发的set和commit得到与第一次相同的结果
+func (s *configStore) handle(req configRequest) configReply {
+	switch req.Op {
+	case "get":
+	case "set":
+		switch {
+		case req.Version != s.Version:
+			return s.reply(fmt.Sprintf("版本冲突: 请求基于版本 %d，当前为 %d", req.Version, s.Version))
+		case req.Key == "" || !json.Valid(req.Value):
+			return s.reply("配置项的键或值无效")
+		}
+		if s.staged == nil {
+			s.staged = make(map[string]json.RawMessage)
+		}
+		s.staged[req.Key] = req.Value
+	case "commit":
+		if req.Version+1 == s.Version && req.Version == s.committed && len(s.staged) == 0 {
+			return s.reply("") // 重发的commit，已经生效
+		}
+		if req.Version != s.Version {
+			return s.reply(fmt.Sprintf("版本冲突: 请求基于版本 %d，当前为 %d", req.Version, s.Version))
+		}
+		if len(s.staged) == 0 {
+			return s.reply("")
+		}
+		err := s.commit()
+		if err != nil {
+			return s.reply(err.Error())
+		}
+		s.committed = req.Version
+	default:
+		return s.reply(fmt.Sprintf("未知操作 %q", req.Op))
+	}
+	return s.reply("")
+}
+
+// commit 使暂存的修改生效并写入文件，写入失败时不改变已生效的配置
+func (s *configStore) commit() error {
+	values := make(map[string]json.RawMessage, len(s.Values)+len(s.staged))
+	for k, v := range s.Values {
+		values[k] = v
+	}
+	for k, v := range s.staged {
+		values[k] = v
+	}
+	data, err := json.MarshalIndent(configStore{Version: s.Version + 1, Values: values}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.File, data, 0644)
+	if err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+	s.Version++
+	s.Values = values
+	s.staged = nil
+	return nil
+}
+
+func (s *configStore) reply(errMsg string) configReply {
+	return configReply{Version: s.Version, Values: s.Values, Staged: s.staged, Error: errMsg}
+}
+
+// configReplyLine 解析配置帧并执行，返回要回复的一行；store为nil时拒绝所有请求
+func configReplyLine(body []byte, store *configStore) (string, error) {
+	var req configRequest
+	err := json.Unmarshal(body[1:], &req)
+	if err != nil {
+		return "", serialcomm.ProtocolError("配置帧无效: %v", err)
+	}
+	reply := configReply{Error: "未启用远程配置"}
+	if store != nil {
+		reply = store.handle(req)
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return "", err
+	}
+	return configReplyPrefix + string(data) + "\n", nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// logControlType 日志控制帧的类型，固件借此通过同一串口输出调试日志，
+// 布局为 类型(1) | 级别(1) | UTF-8文本，不发送反馈，丢失也不重传
+const logControlType = 0x12
+
+// peerLogLevels 日志级别名称，按严重程度递增，超出范围的级别按最后一个处理
+var peerLogLevels = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// peerLogger 把对端日志写入单独的输出，按级别过滤并限速，避免固件刷屏挤占接收端日志
+type peerLogger struct {
+	Out      io.Writer // 如日志文件，为nil时写入接收端自身的日志
+	MinLevel byte      // 低于该级别的日志丢弃
+	Rate     float64   // 每秒允许的行数，0表示不限速
+	Burst    int       // 允许的突发行数
+
+	tokens  float64
+	last    time.Time
+	dropped int // 因限速丢弃、尚未报告的行数
+}
+
+// handle 处理一个日志帧
+func (l *peerLogger) handle(body []byte) error {
+	if len(body) < 2 {
+		return serialcomm.ProtocolError("日志帧长度 %d 不足", len(body))
+	}
+	level := body[1]
+	if level < l.MinLevel {
+		return nil
+	}
+	now := sysClock.Now()
+	if !l.allow(now) {
+		l.dropped++
+		return nil
+	}
+
+	name := peerLogLevels[len(peerLogLevels)-1]
+	if int(level) < len(peerLogLevels) {
+		name = peerLogLevels[level]
+	}
+	line := fmt.Sprintf("[对端][%s] %s", name, strings.TrimRight(string(body[2:]), "\r\n"))
+	if l.dropped > 0 {
+		line = fmt.Sprintf("[对端] 限速丢弃了 %d 行日志\n%s", l.dropped, line)
+		l.dropped = 0
+	}
+	if l.Out == nil {
+		log.Print(line)
+		return nil
+	}
+	_, err := fmt.Fprintf(l.Out, "%s %s\n", now.Format(time.RFC3339Nano), line)
+	return err
+}
+
+// allow 令牌桶限速
+func (l *peerLogger) allow(now time.Time) bool {
+	if l.Rate <= 0 {
+		return true
+	}
+	if l.last.IsZero() {
+		l.tokens = float64(l.Burst)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.Rate
+		if l.tokens > float64(l.Burst) {
+			l.tokens = float64(l.Burst)
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+// priorityFrameMarker 带优先级的帧体的首字节：标记 | 优先级 | 帧体（可为压缩或加密帧）
+const priorityFrameMarker = 0x05
+
+// stripPriority 去掉帧级优先级标记，返回其后的帧体和优先级；未加标记的帧优先级为0
+func stripPriority(data []byte) ([]byte, uint8, error) {
+	if len(data) == 0 || data[0] != priorityFrameMarker {
+		return data, 0, nil
+	}
+	if len(data) < 2 {
+		return nil, 0, fmt.Errorf("优先级帧缺少优先级字节")
+	}
+	return data[2:], data[1], nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// provisionContentType 标识配置下发消息，仅在显式开启配置模式时处理
+const provisionContentType = "application/vnd.serialjson.provision+json"
+
+// errWrongPairingCode 配对码不匹配，接收端据此计数并在次数过多时关闭配置模式
+var errWrongPairingCode = errors.New("配对码不匹配或内容被篡改")
+
+// provisionBundle 发送端下发的密钥材料（均为PEM文本）
+type provisionBundle struct {
+	TrustedKeys []string `json:"trustedKeys"`
+	CACert      string   `json:"caCert,omitempty"`
+}
+
+// openProvisionMessage 用一次性配对码解密配置下发消息
+func openProvisionMessage(pairingCode string, message *Message) (provisionBundle, error) {
+	var bundle provisionBundle
+	sealed, err := base64.StdEncoding.DecodeString(message.Payload)
+	if err != nil {
+		return bundle, fmt.Errorf("解码下发内容失败: %v", err)
+	}
+	if len(sealed) < 16+12 {
+		return bundle, fmt.Errorf("下发内容过短 (%d字节)", len(sealed))
+	}
+
+	key, err := pbkdf2.Key(sha256.New, pairingCode, sealed[:16], 100000, 32)
+	if err != nil {
+		return bundle, fmt.Errorf("派生密钥失败: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return bundle, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return bundle, err
+	}
+	nonce := sealed[16 : 16+gcm.NonceSize()]
+	plain, err := gcm.Open(nil, nonce, sealed[16+gcm.NonceSize():], []byte(provisionContentType))
+	if err != nil {
+		return bundle, errWrongPairingCode
+	}
+
+	err = json.Unmarshal(plain, &bundle)
+	if err != nil {
+		return bundle, fmt.Errorf("解析下发内容失败: %v", err)
+	}
+	return bundle, nil
+}
+
+// storeProvisionBundle 将下发的密钥写入目录，返回可直接用于loadVerifier的文件列表
+func storeProvisionBundle(dir string, bundle provisionBundle) (keyFiles []string, caFile string, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, "", fmt.Errorf("创建目录失败: %v", err)
+	}
+	// 清除上次下发的密钥，避免重新下发后旧公钥在启动时仍被加载
+	stale, _ := filepath.Glob(filepath.Join(dir, "trusted_*.pem"))
+	for _, name := range append(stale, filepath.Join(dir, "ca.pem")) {
+		err = os.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("删除旧密钥失败: %v", err)
+		}
+	}
+	for i, keyPEM := range bundle.TrustedKeys {
+		name := filepath.Join(dir, fmt.Sprintf("trusted_%d.pem", i+1))
+		err = os.WriteFile(name, []byte(keyPEM), 0600)
+		if err != nil {
+			return nil, "", fmt.Errorf("写入公钥失败: %v", err)
+		}
+		keyFiles = append(keyFiles, name)
+	}
+	if bundle.CACert != "" {
+		caFile = filepath.Join(dir, "ca.pem")
+		err = os.WriteFile(caFile, []byte(bundle.CACert), 0600)
+		if err != nil {
+			return nil, "", fmt.Errorf("写入CA证书失败: %v", err)
+		}
+	}
+	return keyFiles, caFile, nil
+}
+
+// provision 解密并保存下发的密钥，返回据此构建的签名校验器
+func provision(pairingCode, dir string, message *Message) (*verifier, error) {
+	bundle, err := openProvisionMessage(pairingCode, message)
+	if err != nil {
+		return nil, err
+	}
+	keyFiles, caFile, err := storeProvisionBundle(dir, bundle)
+	if err != nil {
+		return nil, err
+	}
+	return loadVerifier(keyFiles, caFile)
+}
+
+// loadProvisioned 加载此前配置下发保存在dir中的密钥，目录中没有密钥时返回nil
+func loadProvisioned(dir string) (*verifier, error) {
+	keyFiles, err := filepath.Glob(filepath.Join(dir, "trusted_*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if _, err := os.Stat(caFile); err != nil {
+		caFile = ""
+	}
+	if len(keyFiles) == 0 && caFile == "" {
+		return nil, nil
+	}
+	v, err := loadVerifier(keyFiles, caFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载 %s 中下发的密钥失败: %v", dir, err)
+	}
+	return v, nil
+}
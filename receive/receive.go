@@ -2,16 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/sigurn/crc16"
 	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
 )
 
 type Reading struct {
@@ -48,166 +60,1064 @@ type Message struct {
 	ErrorCode     int    `json:"errorCode"`
 	Payload       string `json:"payload"`
 	ContentType   string `json:"contentType"`
+	Signature     string `json:"signature,omitempty"`   // Payload的Ed25519签名（base64）
+	Certificate   string `json:"certificate,omitempty"` // 签名设备的证书（DER，base64）
+	Sequence      uint64 `json:"sequence,omitempty"`    // 发送端递增的序号，0表示未编号
+	PayloadCRC    string `json:"payloadCRC,omitempty"`  // 解码后payload的CRC32（十六进制），用于端到端校验
+	NoAck         bool   `json:"noAck,omitempty"`       // 发送端不等待确认（遥测），接收端成功处理后不回复
+	Priority      uint8  `json:"priority,omitempty"`    // 发送优先级，数值越大越先发送（如告警为7），0为批量遥测；线上作为帧级优先级字节发送
+
+	// Extra 保存新版本对端添加的未知字段，编码时原样写回，使转发不丢字段
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// messageFields 与Message字段相同但没有自定义编解码方法，避免递归调用
+type messageFields Message
+
+// UnmarshalJSON 解码已知字段，并把未知字段保存到Extra
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var fields messageFields
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(data, &all)
+	if err != nil {
+		return err
+	}
+
+	known := knownJSONFields(reflect.TypeOf(fields))
+	fields.Extra = nil
+	for name, value := range all {
+		if !known(name) {
+			if fields.Extra == nil {
+				fields.Extra = make(map[string]json.RawMessage)
+			}
+			fields.Extra[name] = value
+		}
+	}
+	*m = Message(fields)
+	return nil
 }
 
-func calculateCRC16(data []byte) uint16 {
-	table := crc16.MakeTable(crc16.CRC16_MODBUS)
-	if table == nil {
-		log.Fatal("无法生成CRC16表")
+// MarshalJSON 编码已知字段，并按键名顺序追加Extra中的未知字段
+func (m Message) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(messageFields(m))
+	if err != nil || len(m.Extra) == 0 {
+		return data, err
+	}
+
+	names := make([]string, 0, len(m.Extra))
+	for name := range m.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1]) // 去掉结尾的 }
+	for _, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(m.Extra[name])
 	}
-	crc := crc16.Checksum(data, table)
-	log.Printf("CRC16 计算输入数据长度: %d, 校验和: %x", len(data), crc)
-	return crc
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// knownJSONFields 返回判断JSON键是否对应结构体字段的函数（与encoding/json一样不区分大小写）
+func knownJSONFields(t reflect.Type) func(name string) bool {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		names = append(names, name)
+	}
+	return func(name string) bool {
+		for _, known := range names {
+			if strings.EqualFold(known, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// jsonContent 判断contentType是否表示JSON，未设置时按JSON处理
+func jsonContent(contentType string) bool {
+	return contentType == "" || strings.Contains(contentType, "json")
+}
+
+// payloadChecksum 计算解码后payload的CRC32，链路CRC只保护单跳，该值在源头计算、在最终接收端校验
+func payloadChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// rawFrame 无法按JSON解析、按原样交付的帧
+type rawFrame struct {
+	Raw   []byte `json:"raw"` // 帧内容（JSON中为base64）
+	Error string `json:"error"`
 }
 
 func sendFeedback(port *serial.Port, feedback string) error {
 	_, err := port.Write([]byte(feedback))
 	if err != nil {
-		return fmt.Errorf("发送反馈 %q 失败: %v", feedback, err)
+		return serialcomm.PortError(fmt.Sprintf("发送反馈 %q 失败", feedback), err)
 	}
 	log.Printf("发送反馈: %q", feedback)
 	return nil
 }
 
+// drainTimeout 关闭串口前等待发送缓冲区排空的最长时间
+const drainTimeout = 2 * time.Second
+
+// closePort 先排空驱动发送缓冲区再关闭串口，避免最后的反馈在关闭文件描述符时丢失
+func closePort(port *serial.Port, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	err := serialcomm.DrainPort(ctx, name)
+	if err != nil {
+		log.Printf("%v，直接关闭串口", err)
+	}
+	port.Close()
+}
+
+// reopenPort 读取出错后按退避重新打开串口，成功后清零退避计数；收到停止请求时返回nil
+func reopenPort(config *serial.Config, retry *serialcomm.Backoff, cause error) *serial.Port {
+	for !stopRequested.Load() {
+		retry.Wait(cause)
+		port, err := serial.OpenPort(config)
+		if err == nil {
+			retry.Reset()
+			port.Flush()
+			log.Printf("已重新打开串口 %s", config.Name)
+			return port
+		}
+		cause = err
+	}
+	return nil
+}
+
 func main() {
-	// 配置串口2
-	config := &serial.Config{
-		Name:        "com7", // 替换为你的串口2名称
-		Baud:        115200,
+	var opts receiveOptions
+	opts.registerFlags()
+	// --service: Windows上 install/remove 安装或删除服务，Linux上 unit 输出systemd unit文件
+	service := flag.String("service", "", "服务管理操作（Windows: install|remove，Linux: unit）")
+	// --stats-report: 读取统计历史文件，按天输出每个串口的吞吐和错误率后退出
+	statsReport := flag.String("stats-report", "", "输出统计历史文件的按天趋势报告后退出")
+	// --xmodem-recv / --ymodem-recv: 以XMODEM-CRC或YMODEM接收一个文件后退出，不使用本协议的帧格式
+	xmodemFile := flag.String("xmodem-recv", "", "以XMODEM-CRC接收一个文件并写入该路径后退出")
+	ymodemDir := flag.String("ymodem-recv", "", "以YMODEM接收一个文件并保存到该目录后退出")
+	// --golden-verify: 对端按金标准语料（internal/serialcomm/testdata/golden.json）的顺序输出编码后的帧，逐字节比较后退出
+	goldenCorpus := flag.String("golden-verify", "", "对照金标准语料检查对端输出的帧后退出")
+	goldenCodec := flag.String("golden-codec", "", "只检查语料中该分帧方式的向量，如 lencrc、cobs、modbus")
+	flag.Parse()
+
+	if *statsReport != "" {
+		err := reportHistory(os.Stdout, *statsReport)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 安装服务前同样检查，避免安装一个启动即失败的服务
+	if err := opts.validate(); err != nil {
+		log.Fatalf("参数无效:\n%v", err)
+	}
+
+	const serviceName = "serialjson-receive"
+	if *service != "" {
+		err := manageService(serviceName, *service, serviceArgs())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *goldenCorpus != "" {
+		err := verifyGolden(portConfig(opts), *goldenCorpus, *goldenCodec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *xmodemFile != "" || *ymodemDir != "" {
+		err := receiveTransfer(portConfig(opts), *xmodemFile, *ymodemDir)
+		if err != nil {
+			log.Fatalf("文件传输失败: %v", err)
+		}
+		return
+	}
+
+	err := runService(serviceName, func() { run(opts) })
+	if err != nil {
+		log.Fatalf("服务运行失败: %v", err)
+	}
+}
+
+// portConfig 返回接收使用的串口配置
+func portConfig(opts receiveOptions) *serial.Config {
+	return &serial.Config{
+		Name:        opts.Port,
+		Baud:        opts.Baud,
 		Parity:      serial.ParityNone,
 		ReadTimeout: 500 * time.Millisecond,
 	}
+}
+
+// receiveTransfer 以XMODEM-CRC（file非空）或YMODEM（保存到dir）接收一个文件
+func receiveTransfer(config *serial.Config, file, dir string) error {
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return fmt.Errorf("无法打开串口: %v", err)
+	}
+	defer closePort(port, config.Name)
+	port.Flush()
+
+	receiver := xmodemReceiver{Port: port, Retries: 10, StartupWait: time.Minute}
+	var data []byte
+	if file != "" {
+		data, err = receiver.receive()
+	} else {
+		var name string
+		name, data, err = receiver.receiveFile()
+		file = filepath.Join(dir, filepath.Base(name)) // 只取文件名，防止发送端写到目录之外
+	}
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(file, data, 0644)
+	if err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	log.Printf("文件接收完成: %s (%d字节)", file, len(data))
+	return nil
+}
+
+// stopRequested 收到停止请求（SIGINT/SIGTERM或服务停止）后置位，接收循环在下一次读超时内退出，
+// 使defer的closePort能排空并关闭串口
+var stopRequested atomic.Bool
+
+// notifyStop 收到SIGINT或SIGTERM时请求停止接收
+func notifyStop() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("收到 %v，停止接收", sig)
+		stopRequested.Store(true)
+		signal.Stop(signals) // 再次收到信号时按默认方式立即退出
+	}()
+}
+
+// run 打开串口并持续接收、校验和解析数据帧
+func run(opts receiveOptions) {
+	config := portConfig(opts)
+
+	// 容器中映射的设备可能晚于进程出现，先等待设备节点
+	err := waitForDevice(config.Name, time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// 打开串口
 	port, err := serial.OpenPort(config)
 	if err != nil {
 		log.Fatalf("无法打开串口: %v", err)
 	}
-	defer port.Close()
+	defer func() {
+		if port != nil {
+			closePort(port, config.Name)
+		}
+	}()
+
+	// 串口读取出错（如USB转串口被拔出）后关闭并按退避重新打开，直到成功或收到停止请求
+	reconnect := serialcomm.Backoff{
+		Base:   500 * time.Millisecond,
+		Cap:    5 * time.Second, // 不超过服务停止时的等待时间
+		Jitter: 0.2,
+		OnAttempt: func(attempt int, delay time.Duration, err error) {
+			log.Printf("%v，%v后重新打开串口 (第%d次)", err, delay, attempt)
+		},
+		Clock: sysClock,
+	}
+
+	// 签名校验配置：受信任的设备公钥和/或CA证书，均未配置时不校验
+	trustedKeys, trustedCA, err := opts.keys()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var verify *verifier
+	if len(trustedKeys) > 0 || trustedCA != nil {
+		verify = &verifier{keys: trustedKeys, ca: trustedCA}
+	}
 
-	// 清空串口缓冲区
+	// 此前通过配置下发保存的密钥，未显式配置受信任密钥时使用
+	provisionDir := opts.ProvisionDir
+	if verify == nil {
+		verify, err = loadProvisioned(provisionDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if verify != nil {
+			log.Printf("使用配置下发保存在 %s 的密钥校验签名", provisionDir)
+		}
+	}
+
+	// 帧认证的预共享密钥（十六进制编码），须与发送端一致，为空时不校验HMAC
+	macKey, err := serialcomm.ParseKey(opts.MACKey, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 链路加密的预共享密钥（十六进制编码的AES密钥），须与发送端一致，为空时不解密
+	linkKey, err := serialcomm.ParseKey(opts.LinkKey, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 配置下发模式：需显式设置一次性配对码才会接受发送端下发的密钥
+	pairingCode := opts.PairingCode
+	const maxPairingFailures = 5
+	var pairingFailures int
+
+	// 反馈字符串，需与发送端配置一致
+	okToken := opts.OKToken
+	retryToken := opts.RetryToken
+	authFailToken := opts.AuthToken // 帧的HMAC或签名无效，提示发送端不必重传
+
+	stats := newReceiveStats(config.Baud)
+
+	// 只读模式：作为被动监听端只解析和输出帧，从不向串口写入OK/RETRY
+	// 此时发送端收不到确认，依赖确认的重传不可用，发送端应配置为不等待反馈
+	readOnly := opts.ReadOnly
+	// RS-485半双工：回复前等待发送端的驱动器释放总线，0表示全双工链路立即回复
+	replyTurnaround := opts.ReplyTurnaround
+
+	// 滑动窗口：当前帧是窗口帧时，确认与重传请求都换成累积确认，由发送端按序号释放或重发；
+	// 签名无效等重发也无法解决的拒绝同样推进窗口，避免整个窗口被一条消息卡住
+	window := &windowReceiver{}
+	windowed := false
+	reply := func(feedback string) error {
+		if readOnly {
+			return nil
+		}
+		if windowed {
+			if feedback == okToken || feedback == authFailToken {
+				window.advance()
+			}
+			feedback = window.ack()
+			windowed = false // 每帧只确认一次
+		}
+		sysClock.Sleep(replyTurnaround)
+		err := sendFeedback(port, feedback)
+		if err == nil {
+			stats.recordReply(len(feedback), feedback == retryToken)
+		}
+		return err
+	}
+	if readOnly {
+		log.Println("只读模式：不发送任何反馈")
+	}
+
+	// 清空串口缓冲区，并在丢弃窗口内吸收打开串口时的线路噪声
 	port.Flush()
+	discardWindow := opts.DiscardWindow
+	if discardWindow > 0 {
+		discarded, err := discardInput(port, discardWindow)
+		if err != nil {
+			log.Fatalf("丢弃打开噪声失败: %v", err)
+		}
+		log.Printf("丢弃窗口结束，共丢弃 %d 字节", discarded)
+	}
 	log.Println("串口缓冲区已清空，开始监听串口...")
 
+	notifyStop()
+
+	// 通知服务管理器已就绪，并在接收循环持续运行时喂狗
+	var loopTick atomic.Int64
+	loopTick.Store(sysClock.Now().UnixNano())
+	notifyReady()
+	startWatchdog(func() bool {
+		return sysClock.Since(time.Unix(0, loopTick.Load())) < 10*time.Second
+	})
+
+	// 监视状态线变化（如DCD掉线表示对端断电或断开）
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go watchModemStatus(watchCtx, config.Name, 200*time.Millisecond, func(prev, cur serialcomm.ModemStatus) {
+		log.Printf("状态线变化: %+v -> %+v", prev, cur)
+		if prev.DCD && !cur.DCD {
+			log.Println("载波丢失，对端可能已断电或断开")
+		}
+	})
+
+	// 接收统计，定期输出到日志，并可通过HTTP查询
+	go logStats(stats, time.Minute)
+	statsAddr := opts.StatsAddr // 为空时不提供HTTP统计接口
+	if statsAddr != "" {
+		go serveStats(statsAddr, stats)
+	}
+	historyFile := opts.HistoryFile // 定期追加统计快照，用 --stats-report 查看趋势
+	if historyFile != "" {
+		go statsHistory{File: historyFile, Port: config.Name, Interval: 15 * time.Minute}.run(stats)
+	}
+
+	output := json.NewEncoder(os.Stdout)
+	// 交付队列：确认后的输出按帧级优先级排队，下游处理慢时告警先交付；退出前交付完已排队的消息
+	dispatch := newDispatchQueue(1000)
+	defer dispatch.close()
+
+	// 就绪标记文件：收到第一帧有效数据后创建，如 /tmp/serialjson.ready
+	readyFile := opts.ReadyFile
+	ready := false
+	if readyFile != "" {
+		os.Remove(readyFile) // 清除上次运行遗留的标记
+	}
+
+	// JSON解析失败时的处理：默认请求重传并丢弃；开启deliverRaw后确认并原样交付，
+	// 因为通过CRC校验的"损坏"数据可能只是对端使用的另一种格式
+	deliverRaw := opts.DeliverRaw
+	deliverRawFrame := func(frame []byte, priority uint8, decodeErr error) {
+		log.Printf("交付原始帧 (%d字节，解析错误: %v)", len(frame), decodeErr)
+		if opts.JSONL {
+			raw := rawFrame{Raw: append([]byte(nil), frame...), Error: decodeErr.Error()}
+			dispatch.push(priority, func() {
+				err := output.Encode(raw)
+				if err != nil {
+					log.Printf("输出JSON Lines失败: %v", err)
+				}
+			})
+		}
+	}
+
+	// 学习设备所在的串口，发送端可据此自动路由，为空时不学习
+	routeTableFile := opts.RouteTable
+	var routes *routeTable
+	if routeTableFile != "" {
+		routes, err = loadRouteTable(routeTableFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 压缩字典，编号和内容须与发送端一致，编号0为不带字典的普通DEFLATE
+	dicts := map[byte][]byte{0: nil, builtinDictID: builtinDict}
+	trainedDictFile := opts.Dict // 用发送端 -train-dict 训练的字典，编号为2
+	if trainedDictFile != "" {
+		dicts[2], err = os.ReadFile(trainedDictFile)
+		if err != nil {
+			log.Fatalf("读取字典失败: %v", err)
+		}
+	}
+	const maxDecompressed = 1 << 20 // 解压后最大长度，防止压缩炸弹
+
+	// 超过最大帧长的消息由发送端分片，在此重组
+	fragments := &reassembler{MaxSize: maxDecompressed, Timeout: time.Minute}
+
+	// 序号跟踪，用于发现无确认链路上的静默丢帧
+	var sequences sequenceTracker
+
+	// 重放保护：只接受序号递增的消息（发送端需开启序号），应与macKey或linkKey同时使用
+	var replay *replayGuard
+	if opts.ReplayState != "" {
+		replay = &replayGuard{StateFile: opts.ReplayState}
+	}
+
+	// 状态快照：保留最近的错误和原始帧，收到信号后在接收循环中导出
+	recorder := newStateRecorder(20)
+	var dumpRequested atomic.Bool
+	if opts.DumpFile != "" {
+		notifyDump(func() { dumpRequested.Store(true) })
+	}
+
+	// 长时间运行自检：网关往往连续运行数月，尽早发现内存或goroutine泄漏
+	go selfCheck{
+		Interval:      10 * time.Minute,
+		Warmup:        3,
+		HeapGrowth:    4,
+		GoroutineSlop: 50,
+		OnLeak: func(reason string) {
+			recorder.recordError("疑似资源泄漏: %s", reason)
+		},
+	}.run()
+
 	// 缓冲区和读取逻辑
 	var buffer bytes.Buffer
 	data := make([]byte, 1024)
-	var expectedLength uint32
-	lastDataTime := time.Now()
+	lastDataTime := sysClock.Now()
+
+	// 链路状态：对端（数据或心跳）静默超过阈值时告警，恢复时再通知一次；
+	// 从启动开始计时，使从未收到任何数据的断线也能被发现
+	link := &linkMonitor{
+		SilenceAfter: opts.SilenceAfter, // 应为发送端心跳间隔的数倍
+		OnChange: func(up bool, silentFor time.Duration) {
+			if up {
+				log.Printf("对端恢复，静默了 %v", silentFor.Round(time.Second))
+			} else {
+				log.Printf("对端已静默 %v，可能断线或断电", silentFor.Round(time.Second))
+				recorder.recordError("对端已静默 %v", silentFor.Round(time.Second))
+			}
+		},
+	}
+	link.seen(lastDataTime)
 	timeout := 5 * time.Second // 超时时间
 	const maxLength = 10000    // 最大允许长度（10KB）
 
-	for {
-		// 读取串口数据
-		n, err := port.Read(data)
+	// 分帧方式由 -framing、-length-prefix、-no-terminator、-frame-version、-sync-marker 等选项指定，须与发送端一致，
+	// -accept-versions 可同时接受旧版本的帧；
+	// 与MODBUS从站共用总线时用 -framing modbus，按帧间静默定界
+	codec, err := opts.Framing.Codec(maxLength, opts.Baud)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if macKey != nil {
+		// 每帧重新读取密钥以支持轮换，缓存1分钟避免每帧都访问环境变量、文件或钥匙串
+		macKey = &serialcomm.CachedKey{Provider: macKey, TTL: time.Minute}
+		if _, err := serialcomm.HexKey(macKey); err != nil {
+			log.Fatal(err)
+		}
+		hmacCodec := serialcomm.HMACCodec{Key: macKey, Inner: codec}
+		if err := hmacCodec.Validate(); err != nil {
+			log.Fatalf("-mac-key: %v", err)
+		}
+		codec = hmacCodec
+	}
+	_, resync := codec.(serialcomm.ResyncingCodec)
+	// discard 因错误丢弃一帧后清空缓冲区并请求对端重新开始；能重新同步的分帧保留缓冲区中紧随其后的帧。
+	// 成功处理的帧之后不调用：滑动窗口和合并写出时，后续的帧已经在缓冲区中
+	discard := func() {
+		if !resync {
+			buffer.Reset()
+			port.Flush()
+		}
+	}
+
+	// MODBUS RTU分帧：反馈同样封装为RTU帧，回复前至少保持帧间静默；
+	// 帧以线路静默定界，检测到静默后才把缓冲区中的数据作为一帧解码
+	rtu, gapDelimited := serialcomm.ModbusFraming(codec)
+	var rtuFrame []byte // 已由静默定界、等待解码的RTU帧
+	if c, ok := rtu, gapDelimited; ok {
+		okToken, retryToken, authFailToken = c.WrapToken(okToken), c.WrapToken(retryToken), c.WrapToken(authFailToken)
+		replyTurnaround = max(replyTurnaround, c.Silence())
+	}
+
+	// 对端日志：固件通过日志帧输出的调试日志写入单独的文件，按级别过滤并限速
+	peerLogFile := opts.PeerLogFile // 为空时写入本程序的日志
+	peerLog := &peerLogger{MinLevel: 1, Rate: 20, Burst: 100}
+	if peerLogFile != "" {
+		f, err := os.OpenFile(peerLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
-			log.Printf("读取串口数据失败: %v", err)
-			continue
+			log.Fatalf("打开对端日志文件失败: %v", err)
+		}
+		defer f.Close()
+		peerLog.Out = f
+	}
+
+	// 远程配置：允许发送端通过配置帧读取和修改本端的配置，提交后写入该文件
+	remoteConfigFile := opts.RemoteConfig // 为空时拒绝配置请求
+	var remoteConfig *configStore
+	if remoteConfigFile != "" {
+		remoteConfig, err = loadConfigStore(remoteConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 握手时告知发送端的协议能力
+	localCaps := capabilities{
+		Versions: codecVersions(codec),
+		MaxFrame: maxLength,
+		Dicts:    sortedKeys(dicts),
+		KeyMaps:  sortedKeys(keyMapTables),
+		CRC:      []string{crcAlgorithm},
+		Identity: opts.identity(),
+	}
+	lineNoise := []byte{0x00, 0xFF} // 线路噪声中常见的字节
+	backlog := false                // 缓冲区中可能还有与上一帧一起读到的完整帧，先解码再读串口
+
+	for !stopRequested.Load() {
+		loopTick.Store(sysClock.Now().UnixNano())
+
+		if dumpRequested.Swap(false) {
+			err := writeStateDump(opts.DumpFile, stateDump{
+				Time: sysClock.Now(),
+				Config: map[string]any{
+					"port":        config.Name,
+					"baud":        config.Baud,
+					"readTimeout": config.ReadTimeout.String(),
+					"readOnly":    readOnly,
+					"okToken":     okToken,
+					"retryToken":  retryToken,
+					"maxLength":   maxLength,
+					"deliverRaw":  deliverRaw,
+					"statsAddr":   statsAddr,
+					"pairingCode": redact(pairingCode),
+				},
+				Parser: parserState{
+					Buffered: buffer.Len(),
+					LastData: lastDataTime.Format(time.RFC3339Nano),
+				},
+				Stats: stats.snapshot(),
+			}, recorder)
+			if err != nil {
+				log.Println(err)
+			} else {
+				log.Printf("状态快照已写入 %s", opts.DumpFile)
+			}
+		}
+
+		// 读取串口数据
+		var n int
+		if backlog {
+			backlog = false
+		} else {
+			n, err = port.Read(data)
+			if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+				log.Printf("读取串口数据失败: %v", err)
+				recorder.recordError("读取串口数据失败: %v", err)
+				port.Close()
+				port = reopenPort(config, &reconnect, err)
+				buffer.Reset()
+				continue
+			}
+		}
+		if gapDelimited && buffer.Len() > 0 && rtu.Gap(lastDataTime, sysClock.Now(), n) {
+			rtuFrame = append([]byte(nil), buffer.Bytes()...)
+			buffer.Reset()
 		}
 		if n == 0 {
+			link.check(sysClock.Now())
+
 			// 检查超时
-			if time.Since(lastDataTime) > timeout && buffer.Len() > 0 {
+			if sysClock.Since(lastDataTime) > timeout && buffer.Len() > 0 && !serialcomm.FrameStarted(codec, buffer.Bytes()) {
+				// 只有噪声（如同步标记之前残留的字节），不是未收完的帧，无需请求重传
+				log.Printf("丢弃 %d 字节线路噪声", buffer.Len())
+				buffer.Reset()
+			}
+			if sysClock.Since(lastDataTime) > timeout && buffer.Len() > 0 {
 				log.Printf("接收超时，清空缓冲区（大小: %d）", buffer.Len())
+				recorder.recordError("接收超时，清空缓冲区（大小: %d）", buffer.Len())
 				buffer.Reset()
-				expectedLength = 0
-				_ = sendFeedback(port, "RETRY")
+				_ = reply(retryToken)
 				port.Flush()
 			}
-			continue
+			// 缓冲区中可能还有重新同步后留下的完整帧
+			if buffer.Len() == 0 && rtuFrame == nil {
+				continue
+			}
+		} else {
+			// 更新最后接收时间
+			lastDataTime = sysClock.Now()
+			link.seen(lastDataTime)
+			stats.recordRead(n)
+
+			// 过滤非ASCII字符（只保留32-126和换行符10）
+			buffer.Write(data[:n])
+			log.Printf("接收到 %d 字节，缓冲区大小: %d", n, buffer.Len())
+			// 如需调试原始内容，可以这样打印 hex
+			log.Printf("原始数据 (hex): %x", data[:n])
 		}
 
-		// 更新最后接收时间
-		lastDataTime = time.Now()
+		// 从缓冲区中拆出一帧，数据不足时等待更多数据；帧头前的噪声字节逐个丢弃而不是让整个缓冲区作废
+		var dataPacket []byte
+		switch {
+		case gapDelimited:
+			if rtuFrame == nil {
+				continue
+			}
+			dataPacket, err = codec.Decode(bytes.NewBuffer(rtuFrame))
+			rtuFrame = nil
+			if errors.Is(err, serialcomm.ErrForeignFrame) {
+				continue // 其他从站的流量
+			}
+		case resync:
+			dataPacket, err = codec.Decode(&buffer)
+		default:
+			var dropped int
+			dataPacket, dropped, err = decodeSkippingNoise(codec, &buffer, lineNoise)
+			if dropped > 0 {
+				log.Printf("丢弃帧头前的噪声 %d 字节", dropped)
+			}
+		}
+		if err == serialcomm.ErrIncompleteFrame {
+			continue
+		}
+		if err != nil {
+			recorder.recordError("%v", err)
+			recorder.recordFrame(buffer.Bytes())
+			if errors.Is(err, serialcomm.ErrAuth) {
+				log.Printf("%v，拒绝该帧", err)
+				_ = reply(authFailToken)
+			} else {
+				log.Printf("%v，请求重传", err)
+				_ = reply(retryToken)
+			}
+			discard()
+			continue
+		}
+		recorder.recordFrame(dataPacket)
+		wireSize := len(dataPacket)
+		windowed = false
+		backlog = !gapDelimited && buffer.Len() > 0
 
-		// 过滤非ASCII字符（只保留32-126和换行符10）
-		buffer.Write(data[:n])
-		log.Printf("接收到 %d 字节，缓冲区大小: %d", n, buffer.Len())
-		// 如需调试原始内容，可以这样打印 hex
-		log.Printf("原始数据 (hex): %x", data[:n])
+		// 长度为0的保活帧：说明对端在线，无需解析也不发送反馈
+		if len(dataPacket) == 0 {
+			log.Println("收到保活帧")
+			continue
+		}
 
-		// 读取长度前缀
-		if expectedLength == 0 && buffer.Len() >= 4 {
-			lengthBytes := buffer.Next(4)
-			expectedLength = binary.BigEndian.Uint32(lengthBytes)
-			log.Printf("读取到长度前缀: %d字节（十六进制: %x）", expectedLength, lengthBytes)
+		// 滑动窗口帧：只处理按序到达的帧，重复或跳号的帧不处理，只重发累积确认
+		if isWindowFrame(dataPacket) {
+			body, status, err := window.accept(dataPacket)
+			if err != nil {
+				log.Printf("%v", err)
+				recorder.recordError("%v", err)
+				continue
+			}
+			if status != windowInOrder {
+				log.Printf("跳过重复或跳号的窗口帧，重发确认 %s", window.ack())
+				_ = reply(window.ack())
+				continue
+			}
+			dataPacket = body
+			windowed = true
+		}
 
-			// 验证长度前缀合理性
-			if expectedLength > maxLength || expectedLength == 0 {
-				log.Printf("长度前缀无效 (%d字节)，清空缓冲区并请求重传", expectedLength)
-				buffer.Reset()
-				expectedLength = 0
-				port.Flush()
-				_ = sendFeedback(port, "RETRY")
+		// 分片帧：逐片确认，收齐后按完整帧体继续处理
+		if isFragment(dataPacket) {
+			full, done, err := fragments.add(dataPacket)
+			if errors.Is(err, errStaleFragment) {
+				// 确认后丢弃，使发送端不再重发
+				log.Printf("丢弃分片: %v", err)
+				_ = reply(okToken)
+				continue
+			}
+			if err != nil {
+				log.Printf("重组分片失败: %v", err)
+				recorder.recordError("重组分片失败: %v", err)
+				_ = reply(retryToken)
+				continue
+			}
+			if !done {
+				_ = reply(okToken)
 				continue
 			}
+			log.Printf("分片重组完成: %d字节", len(full))
+			dataPacket = full
 		}
 
-		// 检查是否收到完整数据包（长度+2字节CRC+换行符）
-		if expectedLength > 0 && buffer.Len() >= int(expectedLength)+2 && strings.Contains(buffer.String(), "\n") {
-			// 提取数据和CRC
-			dataPacket := buffer.Next(int(expectedLength))
-			crcBytes := buffer.Next(2)
-			receivedCRC := binary.BigEndian.Uint16(crcBytes)
-			calculatedCRC := calculateCRC16(dataPacket)
-			log.Printf("接收到的CRC: %x，计算的CRC: %x", receivedCRC, calculatedCRC)
+		// 帧级优先级标记在加密之外，取出后交付时写回消息
+		var priority uint8
+		dataPacket, priority, err = stripPriority(dataPacket)
+		if err != nil {
+			log.Printf("%v", err)
+			recorder.recordError("%v", err)
+			_ = reply(retryToken)
+			continue
+		}
 
-			// 验证CRC
-			if receivedCRC != calculatedCRC {
-				log.Printf("CRC校验失败，接收到的CRC: %x，计算的CRC: %x", receivedCRC, calculatedCRC)
-				_ = sendFeedback(port, "RETRY")
-				buffer.Reset()
-				expectedLength = 0
-				port.Flush()
+		// 解密加密帧，配置了链路密钥时只接受加密帧
+		dataPacket, err = decryptFrame(dataPacket, linkKey)
+		if err != nil {
+			log.Printf("%v", err)
+			recorder.recordError("%v", err)
+			_ = reply(retryToken)
+			continue
+		}
+
+		// 控制帧在解密之后处理：配置了链路密钥时只有解密成功的控制帧才会到达这里，
+		// 线路上注入的明文握手、诊断或日志帧与数据帧一样被拒绝
+
+		// 握手帧：回复本端能力，由发送端据此协商
+		if kind, ok := controlType(dataPacket); ok && kind == helloControlType {
+			peer, line, err := helloReply(dataPacket, localCaps)
+			if err != nil {
+				log.Printf("%v", err)
+				recorder.recordError("%v", err)
 				continue
 			}
+			log.Printf("收到握手: 发送端能力 %+v", peer)
+			err = reply(line)
+			if err != nil {
+				log.Printf("回复握手失败: %v", err)
+			}
+			continue
+		}
 
-			// 确保数据包以换行符结束
-			_, err := buffer.ReadBytes('\n')
+		// 诊断帧：对端上报自己的计数器，合并到接收统计，不发送反馈
+		if kind, ok := controlType(dataPacket); ok && kind == diagnosticControlType {
+			counters, err := parseDiagnostic(dataPacket)
 			if err != nil {
-				log.Printf("未找到结束标记，等待更多数据")
+				log.Printf("%v", err)
+				recorder.recordError("%v", err)
 				continue
 			}
+			if prev := stats.recordPeer(counters); prev.Reports > 0 && counters.Resets > prev.Resets {
+				log.Printf("对端自上次上报后复位了 %d 次", counters.Resets-prev.Resets)
+			}
+			log.Printf("对端诊断: 接收错误 %d，缓冲区溢出 %d，复位 %d", counters.RxErrors, counters.Overruns, counters.Resets)
+			continue
+		}
 
-			// 尝试解析JSON
-			var message Message
-			err = json.Unmarshal(dataPacket, &message)
+		// 日志帧：转交对端日志输出，不发送反馈
+		if kind, ok := controlType(dataPacket); ok && kind == logControlType {
+			err := peerLog.handle(dataPacket)
 			if err != nil {
-				log.Printf("JSON解析失败: %v, 数据: %q (十六进制: %x)", err, dataPacket, dataPacket)
-				_ = sendFeedback(port, "RETRY")
-				buffer.Reset()
-				expectedLength = 0
-				port.Flush()
-				continue
+				log.Printf("处理对端日志失败: %v", err)
 			}
-			// 打印消息
-			log.Printf("接收并解析消息: %+v\n", message)
+			continue
+		}
 
-			// 成功解析，发送确认
-			err = sendFeedback(port, "OK")
+		// 配置帧：执行读取或修改请求并回复结果
+		if kind, ok := controlType(dataPacket); ok && kind == configControlType {
+			line, err := configReplyLine(dataPacket, remoteConfig)
 			if err != nil {
-				log.Printf("发送确认失败: %v", err)
+				log.Printf("%v", err)
+				recorder.recordError("%v", err)
+				continue
 			}
-			//如果解析成功，base64解包具体消息内容
-			payloadData, err := base64.StdEncoding.DecodeString(message.Payload)
+			log.Printf("处理配置请求: %s", strings.TrimSpace(line))
+			err = reply(line)
 			if err != nil {
-				log.Printf("解码Payload失败: %v", err)
+				log.Printf("回复配置请求失败: %v", err)
+			}
+			continue
+		}
+
+		// 其他控制帧与保活帧一样不发送反馈；未知类型计数后跳过，
+		// 使新版本对端的扩展不会被当作损坏的数据帧反复重传
+		if kind, ok := controlType(dataPacket); ok {
+			log.Printf("跳过未知控制帧 (类型 %#x，%d字节)", kind, len(dataPacket))
+			stats.recordUnknownControl(kind)
+			continue
+		}
+
+		// 解压字典压缩的帧，未压缩的帧原样返回
+		dataPacket, err = decompressFrame(dataPacket, dicts, maxDecompressed)
+		if err != nil {
+			log.Printf("解压帧失败: %v", err)
+			recorder.recordError("解压帧失败: %v", err)
+			_ = reply(retryToken)
+			discard()
+			continue
+		}
+
+		// 还原键名缩短的帧，映射表版本由帧自带
+		dataPacket, err = expandFrame(dataPacket)
+		if err != nil {
+			log.Printf("还原帧失败: %v", err)
+			recorder.recordError("还原帧失败: %v", err)
+			_ = reply(retryToken)
+			discard()
+			continue
+		}
+
+		// 尝试解析JSON
+		var message Message
+		err = json.Unmarshal(dataPacket, &message)
+		if err != nil {
+			log.Printf("JSON解析失败: %v, 数据: %q (十六进制: %x)", err, dataPacket, dataPacket)
+			recorder.recordError("JSON解析失败: %v", err)
+			// 帧已通过CRC校验，说明数据完整，只是格式不同：按配置确认并原样交付
+			if deliverRaw {
+				_ = reply(okToken)
+				deliverRawFrame(dataPacket, priority, err)
 				continue
 			}
-			var payload Payload
+			_ = reply(retryToken)
+			discard()
+			continue
+		}
+		if priority > 0 {
+			message.Priority = priority
+		}
+
+		// 校验签名：签名无效的消息在确认之前拒绝，回复AUTH使发送端不再重发，也不交付；
+		// 须在重放检查之前，否则伪造消息的序号会推进重放状态
+		signature := verifyUnsigned
+		if verify != nil {
+			signature, err = verify.verify(&message)
+			log.Printf("签名校验结果: %v", signature)
+			if signature == verifyFailed {
+				log.Printf("拒绝签名无效的消息: %v", err)
+				recorder.recordError("拒绝签名无效的消息: %v", err)
+				if !message.NoAck {
+					_ = reply(authFailToken)
+				}
+				discard()
+				continue
+			}
+			// 配置下发消息由配对码认证，不要求签名
+			if signature == verifyUnsigned && opts.RequireSignature && message.ContentType != provisionContentType {
+				log.Println("拒绝未签名的消息：已配置受信任的密钥")
+				recorder.recordError("拒绝未签名的消息")
+				if !message.NoAck {
+					_ = reply(authFailToken)
+				}
+				discard()
+				continue
+			}
+		}
+
+		// base64解包具体消息内容并做端到端校验，失败时在确认之前请求重传：
+		// 链路CRC只保护单跳，中继转发时损坏的payload须由源头重发
+		payloadData, err := base64.StdEncoding.DecodeString(message.Payload)
+		if err != nil {
+			log.Printf("解码Payload失败: %v，请求重传", err)
+			recorder.recordError("解码Payload失败: %v", err)
+			if !message.NoAck {
+				_ = reply(retryToken)
+			}
+			continue
+		}
+		if message.PayloadCRC != "" && payloadChecksum(payloadData) != message.PayloadCRC {
+			log.Printf("端到端校验失败: 期望 %s，计算得到 %s，请求重传", message.PayloadCRC, payloadChecksum(payloadData))
+			recorder.recordError("端到端校验失败: 期望 %s", message.PayloadCRC)
+			if !message.NoAck {
+				_ = reply(retryToken)
+			}
+			continue
+		}
+
+		// 解析JSON格式的Payload；与信封解析失败一样，默认请求重传，开启deliverRaw时确认并原样交付
+		var payload Payload
+		if message.ContentType != provisionContentType && jsonContent(message.ContentType) {
 			err = json.Unmarshal(payloadData, &payload)
 			if err != nil {
 				log.Printf("解析Payload失败: %v", err)
+				recorder.recordError("解析Payload失败: %v", err)
+				feedback := retryToken
+				if deliverRaw {
+					feedback = okToken
+					deliverRawFrame(dataPacket, priority, err)
+				}
+				if !message.NoAck {
+					_ = reply(feedback)
+				}
 				continue
 			}
-			log.Printf("解析的Payload: %+v\n", payload)
+		}
 
-			// 重置状态
-			buffer.Reset()
-			expectedLength = 0
-			port.Flush()
+		// 疑似重放的消息不交付；仍按正常流程确认，使确认丢失后重发的同一帧不会被反复重发
+		if replay != nil {
+			err = replay.check(message.Sequence)
+			if err != nil && !errors.Is(err, serialcomm.ErrProtocol) {
+				log.Printf("%v，请求重传", err)
+				recorder.recordError("%v", err)
+				_ = reply(retryToken)
+				continue
+			}
+			if err != nil {
+				log.Printf("拒绝消息: %v", err)
+				recorder.recordError("拒绝消息: %v", err)
+				stats.recordReplay()
+				if !message.NoAck {
+					_ = reply(okToken)
+				}
+				continue
+			}
+		}
+
+		// 配置下发消息：仅在配置模式下处理，保存成功后才确认，成功后配对码作废；
+		// 配对码错误次数达到上限后关闭配置模式，防止在线路上穷举配对码
+		if message.ContentType == provisionContentType {
+			feedback := retryToken
+			switch {
+			case pairingCode == "":
+				log.Println("未开启配置模式，拒绝配置下发消息")
+				feedback = authFailToken
+			default:
+				provisioned, err := provision(pairingCode, provisionDir, &message)
+				switch {
+				case errors.Is(err, errWrongPairingCode):
+					pairingFailures++
+					log.Printf("配置下发失败: %v (第%d/%d次)", err, pairingFailures, maxPairingFailures)
+					recorder.recordError("配置下发失败: %v", err)
+					feedback = authFailToken
+					if pairingFailures >= maxPairingFailures {
+						pairingCode = ""
+						log.Println("配对码错误次数过多，已关闭配置模式，需重新设置配对码")
+					}
+				case err != nil:
+					log.Printf("配置下发失败: %v", err)
+					recorder.recordError("配置下发失败: %v", err)
+				default:
+					verify = provisioned
+					pairingCode = "" // 配对码只能使用一次
+					feedback = okToken
+					log.Printf("配置下发完成，密钥已保存到 %s", provisionDir)
+				}
+			}
+			if !message.NoAck {
+				_ = reply(feedback)
+			}
+			discard()
+			continue
+		}
+
+		// 打印消息
+		log.Printf("接收并解析消息: %+v\n", message)
+
+		stats.recordFrame(len(dataPacket), wireSize, message.ContentType)
+		if gap, ok := sequences.observe(message.Sequence); ok {
+			log.Printf("检测到序号缺口: 期望 %d，收到 %d，丢失 %d 帧", gap.Expected, gap.Received, gap.Missing)
+			recorder.recordError("检测到序号缺口: 期望 %d，收到 %d，丢失 %d 帧", gap.Expected, gap.Received, gap.Missing)
+			stats.recordGap(gap.Missing)
+		}
+		if !ready {
+			markReady(readyFile)
+			ready = true
+		}
+
+		// 成功解析，发送确认；发送端标记为免确认的消息不回复
+		if !message.NoAck {
+			err = reply(okToken)
+			if err != nil {
+				log.Printf("发送确认失败: %v", err)
+				recorder.recordError("发送确认失败: %v", err)
+			}
+		}
+
+		// 只交付通过全部校验和解码的消息，按帧级优先级排队输出
+		if opts.JSONL {
+			annotated := signature.annotate(message)
+			dispatch.push(priority, func() {
+				err := output.Encode(annotated)
+				if err != nil {
+					log.Printf("输出JSON Lines失败: %v", err)
+				}
+			})
+		}
+
+		log.Printf("解析的Payload: %+v\n", payload)
+		stats.recordDevice(payload.Event.DeviceName)
+		if routes != nil && payload.Event.DeviceName != "" {
+			movedFrom, err := routes.learn(payload.Event.DeviceName, config.Name)
+			if err != nil {
+				log.Printf("更新路由表失败: %v", err)
+				recorder.recordError("更新路由表失败: %v", err)
+			}
+			if movedFrom != "" {
+				log.Printf("路由冲突: 设备 %s 从 %s 移动到了 %s", payload.Event.DeviceName, movedFrom, config.Name)
+			}
 		}
 
 		// 防止CPU过载
-		time.Sleep(10 * time.Millisecond)
+		sysClock.Sleep(10 * time.Millisecond)
 	}
+	log.Println("接收循环已退出，关闭串口")
 }
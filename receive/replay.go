@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"send/internal/serialcomm"
+)
+
+// replayGuard 拒绝序号不大于已接受最大序号的消息，防止截获的帧被重放；
+// 序号只有在帧经过HMAC或链路加密认证时才无法伪造，未认证的链路上该检查只能挡住原样重放
+type replayGuard struct {
+	StateFile string // 保存已接受的最大序号，使接收端重启后仍能拒绝旧帧，为空时只保存在内存
+	last      uint64
+	loaded    bool
+}
+
+// check 校验序号并在通过时记录，未编号（序号为0）的消息一律拒绝
+func (g *replayGuard) check(seq uint64) error {
+	if !g.loaded {
+		err := g.load()
+		if err != nil {
+			return err
+		}
+	}
+	if seq == 0 {
+		return serialcomm.ProtocolError("消息未编号，无法校验重放")
+	}
+	if seq <= g.last {
+		return serialcomm.ProtocolError("序号 %d 不大于已接受的 %d，疑似重放", seq, g.last)
+	}
+	g.last = seq
+	if g.StateFile == "" {
+		return nil
+	}
+	err := os.WriteFile(g.StateFile, []byte(strconv.FormatUint(seq, 10)+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("写入重放状态文件失败: %v", err)
+	}
+	return nil
+}
+
+// load 从状态文件读取已接受的最大序号，文件不存在时从0开始
+func (g *replayGuard) load() error {
+	g.loaded = true
+	if g.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(g.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取重放状态文件失败: %v", err)
+	}
+	g.last, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("重放状态文件 %s 内容无效: %v", g.StateFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"send/internal/serialcomm"
+)
+
+func TestReplayGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		seqs    []uint64
+		wantErr []bool
+	}{
+		{"递增序号", []uint64{1, 2, 10}, []bool{false, false, false}},
+		{"未编号", []uint64{0}, []bool{true}},
+		{"重放与倒退", []uint64{5, 5, 4, 6}, []bool{false, true, true, false}},
+	}
+	for _, tc := range tests {
+		g := &replayGuard{}
+		for i, seq := range tc.seqs {
+			err := g.check(seq)
+			if (err != nil) != tc.wantErr[i] {
+				t.Errorf("%s: 序号 %d 返回 %v，期望出错 %v", tc.name, seq, err, tc.wantErr[i])
+			}
+			if err != nil && !errors.Is(err, serialcomm.ErrProtocol) {
+				t.Errorf("%s: 拒绝应为ErrProtocol类错误: %v", tc.name, err)
+			}
+		}
+	}
+}
+
+func TestReplayGuardPersists(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "replay")
+	if err := (&replayGuard{StateFile: state}).check(7); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后仍拒绝旧序号
+	restarted := &replayGuard{StateFile: state}
+	if err := restarted.check(7); !errors.Is(err, serialcomm.ErrProtocol) {
+		t.Errorf("重启后重放序号7返回 %v，期望被拒绝", err)
+	}
+	if err := restarted.check(8); err != nil {
+		t.Errorf("重启后新序号被拒绝: %v", err)
+	}
+
+	// 状态文件损坏是I/O类错误，不能当作重放
+	if err := os.WriteFile(state, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := (&replayGuard{StateFile: state}).check(9)
+	if err == nil || errors.Is(err, serialcomm.ErrProtocol) {
+		t.Errorf("损坏的状态文件返回 %v，期望非协议错误", err)
+	}
+}
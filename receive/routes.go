@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// learnedRoute 学习到的设备所在串口
+type learnedRoute struct {
+	Port     string    `json:"port"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// routeTable 根据入站流量学习设备名到串口的映射，并持久化到文件供发送端路由使用；
+// 多个接收进程（每个串口一个）可共用同一个文件
+type routeTable struct {
+	mu     sync.Mutex
+	path   string
+	routes map[string]learnedRoute
+}
+
+// loadRouteTable 加载路由表文件，文件不存在时从空表开始
+func loadRouteTable(path string) (*routeTable, error) {
+	t := &routeTable{path: path}
+	routes, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	t.routes = routes
+	return t, nil
+}
+
+func (t *routeTable) read() (map[string]learnedRoute, error) {
+	routes := make(map[string]learnedRoute)
+	data, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return routes, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取路由表失败: %v", err)
+	}
+	err = json.Unmarshal(data, &routes)
+	if err != nil {
+		return nil, fmt.Errorf("解析路由表 %s 失败: %v", t.path, err)
+	}
+	return routes, nil
+}
+
+// learn 记录设备出现在port上；设备从其他串口移动过来时返回原串口（冲突），
+// 映射变化或距上次记录超过一分钟时写回文件
+func (t *routeTable) learn(device, port string) (movedFrom string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := sysClock.Now()
+	prev, ok := t.routes[device]
+	if ok && prev.Port == port && now.Sub(prev.LastSeen) < time.Minute {
+		return "", nil
+	}
+	if ok && prev.Port != port {
+		movedFrom = prev.Port
+	}
+
+	// 写回前重新读取，合并其他接收进程学习到的映射
+	routes, err := t.read()
+	if err != nil {
+		return movedFrom, err
+	}
+	routes[device] = learnedRoute{Port: port, LastSeen: now}
+	t.routes = routes
+
+	data, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		return movedFrom, err
+	}
+	tmp := t.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return movedFrom, fmt.Errorf("写入路由表失败: %v", err)
+	}
+	err = os.Rename(tmp, t.path)
+	if err != nil {
+		return movedFrom, fmt.Errorf("写入路由表失败: %v", err)
+	}
+	return movedFrom, nil
+}
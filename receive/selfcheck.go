@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+)
+
+// selfCheck 长时间运行时监视自身的堆和goroutine增长，怀疑泄漏时告警
+type selfCheck struct {
+	Interval      time.Duration // 采样间隔
+	Warmup        int           // 前几次采样用于建立基线
+	HeapGrowth    float64       // 堆使用超过基线的倍数时告警，如 2 表示翻倍
+	GoroutineSlop int           // goroutine数超过基线的数量时告警
+	OnLeak        func(reason string)
+}
+
+// resourceSample 一次资源采样
+type resourceSample struct {
+	HeapAlloc  uint64
+	Goroutines int
+}
+
+func sampleResources() resourceSample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return resourceSample{HeapAlloc: mem.HeapAlloc, Goroutines: runtime.NumGoroutine()}
+}
+
+// run 持续采样，基线取预热期间的最大值，避免把启动时的正常增长误判为泄漏
+func (c selfCheck) run() {
+	var baseline resourceSample
+	for i := 0; ; i++ {
+		sysClock.Sleep(c.Interval)
+		sample := sampleResources()
+		if i < c.Warmup {
+			baseline.HeapAlloc = max(baseline.HeapAlloc, sample.HeapAlloc)
+			baseline.Goroutines = max(baseline.Goroutines, sample.Goroutines)
+			continue
+		}
+
+		if c.HeapGrowth > 0 && float64(sample.HeapAlloc) > float64(baseline.HeapAlloc)*c.HeapGrowth {
+			c.report("堆使用 %d 字节，超过基线 %d 字节的 %.1f 倍", sample.HeapAlloc, baseline.HeapAlloc, c.HeapGrowth)
+		}
+		if c.GoroutineSlop > 0 && sample.Goroutines > baseline.Goroutines+c.GoroutineSlop {
+			c.report("goroutine数 %d，基线为 %d", sample.Goroutines, baseline.Goroutines)
+		}
+	}
+}
+
+func (c selfCheck) report(format string, args ...any) {
+	reason := fmt.Sprintf(format, args...)
+	log.Printf("疑似资源泄漏: %s", reason)
+	if c.OnLeak != nil {
+		c.OnLeak(reason)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+// sequenceTracker 跟踪发送端序号，发现缺失的帧
+type sequenceTracker struct {
+	last uint64
+}
+
+// gapEvent 描述一次检测到的序号缺口
+type gapEvent struct {
+	Expected uint64 // 期望收到的序号
+	Received uint64 // 实际收到的序号
+	Missing  uint64 // 缺失的帧数
+}
+
+// observe 记录收到的序号，存在缺口时返回缺口信息；
+// 序号不大于上次（重复帧或发送端重置）时只重新同步，不计为丢失
+func (t *sequenceTracker) observe(seq uint64) (gapEvent, bool) {
+	if seq == 0 {
+		return gapEvent{}, false
+	}
+	last := t.last
+	t.last = seq
+	if last == 0 || seq <= last {
+		return gapEvent{}, false
+	}
+	if seq == last+1 {
+		return gapEvent{}, false
+	}
+	return gapEvent{Expected: last + 1, Received: seq, Missing: seq - last - 1}, true
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runService systemd直接监管进程，无需额外处理
+func runService(name string, run func()) error {
+	run()
+	return nil
+}
+
+// manageService 输出systemd unit文件内容，由用户保存到 /etc/systemd/system/<name>.service
+func manageService(name, action string, args []string) error {
+	if action != "unit" {
+		return fmt.Errorf("不支持的服务操作 %q（Linux上可用: unit）", action)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Printf(`[Unit]
+Description=%s
+After=network.target
+
+[Service]
+Type=notify
+ExecStart=%s %s
+WatchdogSec=30
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, name, exe, strings.Join(args, " "))
+	return nil
+}
+
+// sdNotify 向systemd发送状态通知，未由systemd启动时什么也不做
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("连接systemd通知套接字失败: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyReady 通知systemd服务已就绪（Type=notify）
+func notifyReady() {
+	err := sdNotify("READY=1")
+	if err != nil {
+		log.Printf("通知systemd就绪失败: %v", err)
+	}
+}
+
+// startWatchdog 按WATCHDOG_USEC的一半间隔喂狗，alive返回false时停止喂狗让systemd重启进程
+func startWatchdog(alive func() bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		for {
+			sysClock.Sleep(interval)
+			if !alive() {
+				log.Println("接收循环无响应，停止喂狗")
+				continue
+			}
+			err := sdNotify("WATCHDOG=1")
+			if err != nil {
+				log.Printf("喂狗失败: %v", err)
+			}
+		}
+	}()
+}
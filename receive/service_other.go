@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+
+package main
+
+import "fmt"
+
+// runService 当前平台没有服务管理集成，直接运行
+func runService(name string, run func()) error {
+	run()
+	return nil
+}
+
+func manageService(name, action string, args []string) error {
+	return fmt.Errorf("当前平台不支持服务管理")
+}
+
+func notifyReady() {}
+
+func startWatchdog(alive func() bool) {}
@@ -0,0 +1,103 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// runService 由服务控制管理器启动时以Windows服务方式运行，否则直接运行
+func runService(name string, run func()) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return fmt.Errorf("检测服务环境失败: %v", err)
+	}
+	if !isService {
+		run()
+		return nil
+	}
+	return svc.Run(name, &windowsService{run: run})
+}
+
+// windowsService 在后台运行接收循环，并响应停止和关机请求
+type windowsService struct {
+	run func()
+}
+
+func (s *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			// 等待接收循环退出并关闭串口，超时后不再等待
+			changes <- svc.Status{State: svc.StopPending}
+			stopRequested.Store(true)
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+			}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// manageService 安装或删除Windows服务，安装后服务以当前参数自动启动
+func manageService(name, action string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("连接服务控制管理器失败: %v", err)
+	}
+	defer m.Disconnect()
+
+	switch action {
+	case "install":
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		exe, err = filepath.Abs(exe)
+		if err != nil {
+			return err
+		}
+		s, err := m.CreateService(name, exe, mgr.Config{
+			DisplayName: name,
+			StartType:   mgr.StartAutomatic,
+		}, args...)
+		if err != nil {
+			return fmt.Errorf("安装服务失败: %v", err)
+		}
+		s.Close()
+		return nil
+	case "remove":
+		s, err := m.OpenService(name)
+		if err != nil {
+			return fmt.Errorf("服务 %s 不存在: %v", name, err)
+		}
+		defer s.Close()
+		return s.Delete()
+	default:
+		return fmt.Errorf("不支持的服务操作 %q（可用: install, remove）", action)
+	}
+}
+
+// notifyReady Windows服务的就绪状态已由Execute上报，这里无需处理
+func notifyReady() {}
+
+// startWatchdog Windows服务没有看门狗机制
+func startWatchdog(alive func() bool) {}
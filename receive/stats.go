@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// frameSizeBuckets 帧大小直方图的桶上限（字节），最后一个桶收集超过上限的帧
+var frameSizeBuckets = []int{64, 128, 256, 512, 1024, 2048, 4096, 8192}
+
+// receiveStats 接收统计：帧大小分布以及按内容类型、设备的计数，用于容量规划
+type receiveStats struct {
+	mu            sync.Mutex
+	frames        int64
+	bytes         int64
+	sizeCounts    []int64
+	byContentType map[string]int64
+	byDevice      map[string]int64
+	gaps          int64
+	lostFrames    int64
+	unknownCtrl   map[byte]int64
+	replays       int64
+	peer          peerCounters // 对端通过诊断帧上报的计数器
+
+	// 链路用量：按波特率推算理论容量，用于判断是否需要压缩、批量发送或提高波特率
+	baud      int
+	started   time.Time
+	rxBytes   int64 // 从串口读到的全部字节（含帧头、CRC、换行和无效数据）
+	txBytes   int64 // 写入串口的反馈字节
+	bodyBytes int64 // 通过校验的帧体字节（压缩时为压缩后的大小）
+	retries   int64 // 发出的重传请求次数
+}
+
+// statsSnapshot 某一时刻的统计数据
+type statsSnapshot struct {
+	Frames        int64            `json:"frames"`
+	Bytes         int64            `json:"bytes"`
+	SizeBuckets   []int            `json:"sizeBuckets"`
+	SizeCounts    []int64          `json:"sizeCounts"` // 比SizeBuckets多一个溢出桶
+	ByContentType map[string]int64 `json:"byContentType"`
+	ByDevice      map[string]int64 `json:"byDevice"`
+	Gaps          int64            `json:"gaps"`           // 检测到的序号缺口次数
+	LostFrames    int64            `json:"lostFrames"`     // 按序号推算的累计丢失帧数
+	UnknownCtrl   map[string]int64 `json:"unknownControl"` // 按类型统计跳过的未知控制帧
+	Replays       int64            `json:"replays"`        // 因序号疑似重放而拒绝的消息数
+	Peer          peerCounters     `json:"peer"`           // 对端上报的计数器，与本端统计合起来覆盖链路两端
+	Link          linkUsage        `json:"link"`
+}
+
+// linkUsage 链路用量与理论容量的对比
+type linkUsage struct {
+	Baud             int     `json:"baud"`
+	CapacityBps      float64 `json:"capacityBytesPerSec"` // 8N1下每字节10位
+	RxBps            float64 `json:"rxBytesPerSec"`
+	TxBps            float64 `json:"txBytesPerSec"`
+	Utilization      float64 `json:"utilization"`      // 接收方向占理论容量的比例
+	OverheadFraction float64 `json:"overheadFraction"` // 帧头、CRC、反馈、重传及无效数据占总字节的比例
+	Retries          int64   `json:"retries"`
+}
+
+func newReceiveStats(baud int) *receiveStats {
+	return &receiveStats{
+		baud:          baud,
+		started:       sysClock.Now(),
+		sizeCounts:    make([]int64, len(frameSizeBuckets)+1),
+		byContentType: make(map[string]int64),
+		byDevice:      make(map[string]int64),
+		unknownCtrl:   make(map[byte]int64),
+	}
+}
+
+// recordRead 记录从串口读到的字节数
+func (s *receiveStats) recordRead(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rxBytes += int64(n)
+}
+
+// recordReply 记录写入串口的反馈
+func (s *receiveStats) recordReply(n int, retry bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txBytes += int64(n)
+	if retry {
+		s.retries++
+	}
+}
+
+// recordFrame 记录一个通过校验的帧，size为解压后的大小，wireSize为线上帧体大小
+func (s *receiveStats) recordFrame(size, wireSize int, contentType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames++
+	s.bytes += int64(size)
+	s.bodyBytes += int64(wireSize)
+	bucket := len(frameSizeBuckets)
+	for i, limit := range frameSizeBuckets {
+		if size <= limit {
+			bucket = i
+			break
+		}
+	}
+	s.sizeCounts[bucket]++
+	if contentType == "" {
+		contentType = "unknown"
+	}
+	s.byContentType[contentType]++
+}
+
+// recordDevice 记录一条来自指定设备的事件
+func (s *receiveStats) recordDevice(deviceName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byDevice[deviceName]++
+}
+
+// recordUnknownControl 记录一个被跳过的未知控制帧
+func (s *receiveStats) recordUnknownControl(kind byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unknownCtrl[kind]++
+}
+
+// recordReplay 记录一条因疑似重放被拒绝的消息
+func (s *receiveStats) recordReplay() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replays++
+}
+
+// recordPeer 合并对端上报的计数器并返回上一次的值；对端的计数是累计值，直接取最新的一次
+func (s *receiveStats) recordPeer(c peerCounters) peerCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.peer
+	c.Reports = prev.Reports + 1
+	c.UpdatedAt = sysClock.Now()
+	s.peer = c
+	return prev
+}
+
+// recordGap 记录一次序号缺口
+func (s *receiveStats) recordGap(missing uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gaps++
+	s.lostFrames += int64(missing)
+}
+
+// snapshot 返回当前统计数据的副本
+func (s *receiveStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := statsSnapshot{
+		Frames:        s.frames,
+		Bytes:         s.bytes,
+		SizeBuckets:   frameSizeBuckets,
+		SizeCounts:    append([]int64(nil), s.sizeCounts...),
+		ByContentType: make(map[string]int64, len(s.byContentType)),
+		ByDevice:      make(map[string]int64, len(s.byDevice)),
+		UnknownCtrl:   make(map[string]int64, len(s.unknownCtrl)),
+		Gaps:          s.gaps,
+		LostFrames:    s.lostFrames,
+		Replays:       s.replays,
+		Peer:          s.peer,
+		Link:          s.linkUsage(),
+	}
+	for k, v := range s.byContentType {
+		snap.ByContentType[k] = v
+	}
+	for k, v := range s.byDevice {
+		snap.ByDevice[k] = v
+	}
+	for k, v := range s.unknownCtrl {
+		snap.UnknownCtrl[fmt.Sprintf("%#x", k)] = v
+	}
+	return snap
+}
+
+// linkUsage 计算链路用量，调用方需持有锁
+func (s *receiveStats) linkUsage() linkUsage {
+	usage := linkUsage{
+		Baud:        s.baud,
+		CapacityBps: float64(s.baud) / 10,
+		Retries:     s.retries,
+	}
+	elapsed := sysClock.Since(s.started).Seconds()
+	if elapsed > 0 {
+		usage.RxBps = float64(s.rxBytes) / elapsed
+		usage.TxBps = float64(s.txBytes) / elapsed
+	}
+	if usage.CapacityBps > 0 {
+		usage.Utilization = usage.RxBps / usage.CapacityBps
+	}
+	if total := s.rxBytes + s.txBytes; total > 0 {
+		usage.OverheadFraction = 1 - float64(s.bodyBytes)/float64(total)
+	}
+	return usage
+}
+
+// logStats 按固定间隔把统计数据以JSON写入日志
+func logStats(stats *receiveStats, interval time.Duration) {
+	for {
+		sysClock.Sleep(interval)
+		snap := stats.snapshot()
+		data, err := json.Marshal(snap)
+		if err != nil {
+			log.Printf("序列化统计数据失败: %v", err)
+			continue
+		}
+		log.Printf("接收统计: %s", data)
+		log.Printf("链路利用率 %.1f%%（%.0f/%.0f 字节/秒），开销占比 %.1f%%，重传请求 %d 次",
+			snap.Link.Utilization*100, snap.Link.RxBps, snap.Link.CapacityBps,
+			snap.Link.OverheadFraction*100, snap.Link.Retries)
+	}
+}
+
+// serveStats 在addr上提供 /stats（JSON快照）和 /debug/vars（expvar），供轻量部署直接抓取
+func serveStats(addr string, stats *receiveStats) {
+	expvar.Publish("receiveStats", expvar.Func(func() any { return stats.snapshot() }))
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(stats.snapshot())
+		if err != nil {
+			log.Printf("输出统计数据失败: %v", err)
+		}
+	})
+
+	log.Printf("统计接口监听于 %s", addr)
+	err := http.ListenAndServe(addr, mux)
+	log.Printf("统计接口已退出: %v", err)
+}
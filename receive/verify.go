@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"send/internal/serialcomm"
+)
+
+// verifyStatus 消息签名的校验结果
+type verifyStatus int
+
+const (
+	verifyUnsigned verifyStatus = iota // 消息未签名
+	verifyOK                           // 签名有效
+	verifyFailed                       // 签名无效或签名者不受信任
+)
+
+func (s verifyStatus) String() string {
+	switch s {
+	case verifyUnsigned:
+		return "未签名"
+	case verifyOK:
+		return "已验证"
+	default:
+		return "校验失败"
+	}
+}
+
+// signatureStatusKey JSON Lines输出中记录签名校验结果的字段，总是由接收端填写，发送端无法伪造
+const signatureStatusKey = "signatureStatus"
+
+// annotate 把校验结果写入消息的附加字段，供下游按签名状态过滤：unsigned或verified
+func (s verifyStatus) annotate(message Message) Message {
+	value := `"unsigned"`
+	if s == verifyOK {
+		value = `"verified"`
+	}
+	extra := make(map[string]json.RawMessage, len(message.Extra)+1)
+	for k, v := range message.Extra {
+		extra[k] = v
+	}
+	extra[signatureStatusKey] = json.RawMessage(value)
+	message.Extra = extra
+	return message
+}
+
+// verifier 使用受信任的公钥集合或CA证书校验消息签名，每次校验都从提供者获取以支持轮换
+type verifier struct {
+	keys []serialcomm.KeyProvider // PKIX PEM格式的Ed25519公钥
+	ca   serialcomm.KeyProvider   // PEM格式的CA证书，可为nil
+}
+
+// loadVerifier 使用公钥文件和CA文件构建校验器，caFile可为空
+func loadVerifier(keyFiles []string, caFile string) (*verifier, error) {
+	v := &verifier{}
+	for _, keyFile := range keyFiles {
+		v.keys = append(v.keys, serialcomm.FileKey{Path: keyFile})
+	}
+	if caFile != "" {
+		v.ca = serialcomm.FileKey{Path: caFile}
+	}
+	// 预先获取一次，尽早暴露配置错误
+	if _, err := v.publicKeys(); err != nil {
+		return nil, err
+	}
+	if _, err := v.roots(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// publicKeys 获取并解析当前受信任的公钥
+func (v *verifier) publicKeys() ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for i, provider := range v.keys {
+		keyPEM, err := provider.Key()
+		if err != nil {
+			return nil, fmt.Errorf("获取第%d个公钥失败: %v", i+1, err)
+		}
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, fmt.Errorf("第%d个公钥不是PEM格式", i+1)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析第%d个公钥失败: %v", i+1, err)
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("第%d个公钥类型 %T 不是Ed25519", i+1, key)
+		}
+		keys = append(keys, edKey)
+	}
+	return keys, nil
+}
+
+// roots 获取当前的CA证书池，未配置CA时返回nil
+func (v *verifier) roots() (*x509.CertPool, error) {
+	if v.ca == nil {
+		return nil, nil
+	}
+	caPEM, err := v.ca.Key()
+	if err != nil {
+		return nil, fmt.Errorf("获取CA证书失败: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("CA证书中没有有效证书")
+	}
+	return roots, nil
+}
+
+// verify 校验消息Payload的签名，携带证书时先用CA校验证书链再用证书公钥验签
+func (v *verifier) verify(message *Message) (verifyStatus, error) {
+	if message.Signature == "" {
+		return verifyUnsigned, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return verifyFailed, fmt.Errorf("解码签名失败: %v", err)
+	}
+
+	if message.Certificate != "" {
+		roots, err := v.roots()
+		if err != nil {
+			return verifyFailed, err
+		}
+		if roots == nil {
+			return verifyFailed, fmt.Errorf("消息携带证书但未配置CA")
+		}
+		der, err := base64.StdEncoding.DecodeString(message.Certificate)
+		if err != nil {
+			return verifyFailed, fmt.Errorf("解码证书失败: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return verifyFailed, fmt.Errorf("解析证书失败: %v", err)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		if err != nil {
+			return verifyFailed, fmt.Errorf("证书链校验失败: %v", err)
+		}
+		key, ok := cert.PublicKey.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, []byte(message.Payload), signature) {
+			return verifyFailed, fmt.Errorf("签名与证书 %q 不匹配", cert.Subject.CommonName)
+		}
+		return verifyOK, nil
+	}
+
+	keys, err := v.publicKeys()
+	if err != nil {
+		return verifyFailed, err
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, []byte(message.Payload), signature) {
+			return verifyOK, nil
+		}
+	}
+	return verifyFailed, fmt.Errorf("签名不匹配任何受信任的公钥")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"send/internal/serialcomm"
+)
+
+// windowFrameMarker 滑动窗口帧的首字节：标记 | 会话(1) | 序号(2) | 发送端最早未确认的序号(2) | 帧体，
+// 序号为大端；接收端只处理按序到达的帧，并以 "ACK"+5位十进制序号 累积确认此前的所有帧
+const windowFrameMarker = 0x06
+
+// windowHeaderSize 滑动窗口帧头的长度
+const windowHeaderSize = 6
+
+// windowStatus 滑动窗口帧相对于期望序号的位置
+type windowStatus int
+
+const (
+	windowInOrder   windowStatus = iota // 正是期望的下一帧
+	windowDuplicate                     // 已处理过（确认丢失后的重发）
+	windowGap                           // 前面有帧丢失，等待发送端从丢失处重发
+)
+
+// isWindowFrame 判断是否为滑动窗口帧
+func isWindowFrame(data []byte) bool {
+	return len(data) > 0 && data[0] == windowFrameMarker
+}
+
+// windowReceiver 滑动窗口的接收状态；发送端重启后会话号改变，按新会话的最早未确认序号重新开始
+type windowReceiver struct {
+	active   bool
+	session  byte
+	expected uint16
+}
+
+// accept 解析窗口帧头，返回帧体和该帧相对于期望序号的位置
+func (w *windowReceiver) accept(data []byte) ([]byte, windowStatus, error) {
+	if len(data) < windowHeaderSize {
+		return nil, 0, fmt.Errorf("滑动窗口帧过短 (%d字节)", len(data))
+	}
+	session := data[1]
+	seq := binary.BigEndian.Uint16(data[2:4])
+	base := binary.BigEndian.Uint16(data[4:6])
+	// 新会话，或发送端已放弃期望序号之前的帧：从发送端最早未确认的帧开始
+	if !w.active || session != w.session || int16(base-w.expected) > 0 {
+		w.active, w.session, w.expected = true, session, base
+	}
+	switch diff := int16(seq - w.expected); {
+	case diff == 0:
+		return data[windowHeaderSize:], windowInOrder, nil
+	case diff < 0:
+		return nil, windowDuplicate, nil
+	default:
+		return nil, windowGap, nil
+	}
+}
+
+// advance 期望的帧已处理完毕（接受或永久拒绝），期望下一帧
+func (w *windowReceiver) advance() {
+	w.expected++
+}
+
+// ack 返回累积确认：期望序号之前的帧都已处理
+func (w *windowReceiver) ack() string {
+	return serialcomm.WindowAck(w.expected - 1)
+}
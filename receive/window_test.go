@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// windowFrame 按发送端的格式构造滑动窗口帧
+func windowFrame(session byte, seq, base uint16, body string) []byte {
+	frame := []byte{windowFrameMarker, session}
+	frame = binary.BigEndian.AppendUint16(frame, seq)
+	frame = binary.BigEndian.AppendUint16(frame, base)
+	return append(frame, body...)
+}
+
+func TestWindowReceiver(t *testing.T) {
+	type step struct {
+		frame   []byte
+		want    windowStatus
+		process bool   // 按序的帧处理后推进窗口
+		ack     string // 此后的累积确认
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"按序到达", []step{
+			{windowFrame(1, 0, 0, "a"), windowInOrder, true, "ACK00000"},
+			{windowFrame(1, 1, 0, "b"), windowInOrder, true, "ACK00001"},
+		}},
+		{"丢帧后跳号与重发", []step{
+			{windowFrame(1, 0, 0, "a"), windowInOrder, true, "ACK00000"},
+			{windowFrame(1, 2, 1, "c"), windowGap, false, "ACK00000"},
+			{windowFrame(1, 1, 1, "b"), windowInOrder, true, "ACK00001"},
+			{windowFrame(1, 1, 1, "b"), windowDuplicate, false, "ACK00001"},
+		}},
+		{"未处理的帧不推进", []step{
+			{windowFrame(1, 5, 5, "a"), windowInOrder, false, "ACK00004"},
+			{windowFrame(1, 5, 5, "a"), windowInOrder, true, "ACK00005"},
+		}},
+		{"发送端重启后按新会话重新开始", []step{
+			{windowFrame(1, 40, 40, "a"), windowInOrder, true, "ACK00040"},
+			{windowFrame(2, 0, 0, "b"), windowInOrder, true, "ACK00000"},
+		}},
+		{"序号回绕", []step{
+			{windowFrame(1, 0xFFFF, 0xFFFE, "a"), windowGap, false, "ACK65533"},
+			{windowFrame(1, 0xFFFE, 0xFFFE, "z"), windowInOrder, true, "ACK65534"},
+			{windowFrame(1, 0xFFFF, 0xFFFE, "a"), windowInOrder, true, "ACK65535"},
+			{windowFrame(1, 0, 0xFFFE, "b"), windowInOrder, true, "ACK00000"},
+		}},
+	}
+	for _, tc := range tests {
+		w := &windowReceiver{}
+		for i, s := range tc.steps {
+			_, status, err := w.accept(s.frame)
+			if err != nil || status != s.want {
+				t.Fatalf("%s 第%d步: 得到 %v, %v，期望 %v", tc.name, i+1, status, err, s.want)
+			}
+			if s.process {
+				w.advance()
+			}
+			if got := w.ack(); got != s.ack {
+				t.Fatalf("%s 第%d步: 确认 %s，期望 %s", tc.name, i+1, got, s.ack)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/sigurn/crc16"
+
+	"send/internal/serialcomm"
+)
+
+// XMODEM/YMODEM控制字节
+const (
+	xmodemSOH = 0x01 // 128字节数据块
+	xmodemSTX = 0x02 // 1024字节数据块
+	xmodemEOT = 0x04
+	xmodemACK = 0x06
+	xmodemNAK = 0x15
+	xmodemCAN = 0x18
+	xmodemC   = 'C' // 请求CRC模式
+)
+
+var xmodemTable = crc16.MakeTable(crc16.CRC16_XMODEM)
+
+// errTransferDone 表示YMODEM批次结束（收到空的0号块）
+var errTransferDone = errors.New("传输结束")
+
+// xmodemReceiver 以XMODEM-CRC或YMODEM接收文件，用于与现有的终端工具和固件对接；
+// 与本协议的帧格式无关，传输期间串口上不能有其他流量
+type xmodemReceiver struct {
+	Port        io.ReadWriter
+	Retries     int           // 每块的最大NAK次数
+	StartupWait time.Duration // 等待发送端开始发送的时间
+}
+
+// receive 以XMODEM-CRC接收数据，同时接受128和1024字节的数据块；
+// XMODEM不携带文件大小，返回的数据包含最后一块的填充字节
+func (r xmodemReceiver) receive() ([]byte, error) {
+	var data bytes.Buffer
+	err := r.receiveBlocks(&data, 1)
+	return data.Bytes(), err
+}
+
+// receiveFile 以YMODEM接收单个文件，按0号块中的大小去掉填充，
+// 发送端随后的其他文件不接收
+func (r xmodemReceiver) receiveFile() (string, []byte, error) {
+	header, err := r.start(0)
+	if err != nil {
+		return "", nil, err
+	}
+	fields := bytes.SplitN(header, []byte{0}, 3)
+	name := string(fields[0])
+	if name == "" {
+		return "", nil, serialcomm.ProtocolError("YMODEM批次中没有文件")
+	}
+	size := -1
+	if len(fields) > 1 {
+		sizeField, _, _ := bytes.Cut(fields[1], []byte(" "))
+		size, err = strconv.Atoi(string(sizeField))
+		if err != nil {
+			size = -1
+		}
+	}
+	err = r.write(xmodemACK)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var data bytes.Buffer
+	err = r.receiveBlocks(&data, 1)
+	if err != nil {
+		return name, nil, err
+	}
+	content := data.Bytes()
+	if size >= 0 && size <= len(content) {
+		content = content[:size]
+	}
+
+	// 发送端以空的0号块结束批次；本端只接收一个文件，之后取消
+	header, err = r.start(0)
+	if err == nil && header[0] == 0 {
+		err = r.write(xmodemACK)
+	} else if err == nil {
+		_ = r.write(xmodemCAN, xmodemCAN)
+		log.Println("YMODEM批次中还有其他文件，已取消")
+	}
+	return name, content, err
+}
+
+// start 发送'C'请求CRC模式，直到收到块号为want的第一块；
+// 块号不符的块是上一次中断的传输残留的重发，丢弃后继续等待，不能混入本次传输
+func (r xmodemReceiver) start(want byte) ([]byte, error) {
+	deadline := sysClock.Now().Add(r.StartupWait)
+	for sysClock.Now().Before(deadline) {
+		err := r.write(xmodemC)
+		if err != nil {
+			return nil, err
+		}
+		header, err := xmodemReadByte(r.Port, 3*time.Second)
+		if errors.Is(err, serialcomm.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		num, block, err := r.readBlock(header)
+		if err != nil {
+			log.Printf("首块无效: %v", err)
+			continue
+		}
+		if num != want {
+			log.Printf("丢弃块号为 %d 的残留数据块，等待块 %d", num, want)
+			continue
+		}
+		return block, nil
+	}
+	return nil, &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: fmt.Errorf("%v内发送端未开始传输", r.StartupWait)}
+}
+
+// receiveBlocks 接收从块号next开始的数据块直到EOT；重复的块确认后丢弃
+func (r xmodemReceiver) receiveBlocks(out *bytes.Buffer, next byte) error {
+	var header byte
+	var err error
+	if next == 1 && out.Len() == 0 {
+		// 第一块前需要请求CRC模式
+		var block []byte
+		block, err = r.start(next)
+		if err != nil {
+			return err
+		}
+		out.Write(block)
+		next++
+		err = r.write(xmodemACK)
+		if err != nil {
+			return err
+		}
+	}
+
+	naks := 0
+	for {
+		header, err = xmodemReadByte(r.Port, 10*time.Second)
+		if err == nil && header == xmodemEOT {
+			return r.write(xmodemACK)
+		}
+		if err == nil && header == xmodemCAN {
+			return &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: errors.New("发送端取消了传输")}
+		}
+		var num byte
+		var block []byte
+		if err == nil {
+			num, block, err = r.readBlock(header)
+		}
+		if err != nil && !errors.Is(err, serialcomm.ErrTimeout) && !errors.Is(err, serialcomm.ErrProtocol) {
+			return err
+		}
+		if err != nil {
+			naks++
+			if naks > r.Retries {
+				_ = r.write(xmodemCAN, xmodemCAN)
+				return fmt.Errorf("连续%d块接收失败: %w", naks, err)
+			}
+			log.Printf("数据块接收失败: %v，请求重发", err)
+			err = r.write(xmodemNAK)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		naks = 0
+		switch num {
+		case next:
+			out.Write(block)
+			next++
+		case next - 1:
+			// 确认丢失后的重发，不重复写入
+		default:
+			_ = r.write(xmodemCAN, xmodemCAN)
+			return serialcomm.ProtocolError("块号不连续: 期望 %d，收到 %d", next, num)
+		}
+		err = r.write(xmodemACK)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readBlock 读取以header开头的一块的其余部分并校验块号和CRC
+func (r xmodemReceiver) readBlock(header byte) (byte, []byte, error) {
+	size := 0
+	switch header {
+	case xmodemSOH:
+		size = 128
+	case xmodemSTX:
+		size = 1024
+	default:
+		return 0, nil, serialcomm.ProtocolError("未知的块头 %#x", header)
+	}
+	packet := make([]byte, 2+size+2)
+	for i := range packet {
+		b, err := xmodemReadByte(r.Port, time.Second)
+		if err != nil {
+			return 0, nil, err
+		}
+		packet[i] = b
+	}
+	num, block := packet[0], packet[2:2+size]
+	if packet[1] != 0xFF-num {
+		return 0, nil, serialcomm.ProtocolError("块号 %d 与反码 %d 不匹配", num, packet[1])
+	}
+	if got, want := binary.BigEndian.Uint16(packet[2+size:]), crc16.Checksum(block, xmodemTable); got != want {
+		return 0, nil, serialcomm.ProtocolError("数据块 %d CRC错误: 期望 %04x，收到 %04x", num, want, got)
+	}
+	return num, block, nil
+}
+
+func (r xmodemReceiver) write(b ...byte) error {
+	_, err := r.Port.Write(b)
+	if err != nil {
+		return serialcomm.PortError("写入控制字节失败", err)
+	}
+	return nil
+}
+
+// xmodemReadByte 读取一个字节，超时返回serialcomm.ErrTimeout类别的错误
+func xmodemReadByte(r io.Reader, timeout time.Duration) (byte, error) {
+	b := make([]byte, 1)
+	start := sysClock.Now()
+	for sysClock.Since(start) < timeout {
+		n, err := r.Read(b)
+		if n == 1 {
+			return b[0], nil
+		}
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return 0, serialcomm.PortError("读取失败", err)
+		}
+		sysClock.Sleep(10 * time.Millisecond) // 防止CPU过载
+	}
+	return 0, &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: fmt.Errorf("%v内未收到数据", timeout)}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// windowFrameMarker 滑动窗口帧的首字节：标记 | 会话(1) | 序号(2) | 最早未确认的序号(2) | 帧体，序号为大端
+const windowFrameMarker = 0x06
+
+// windowHeaderSize 滑动窗口帧头的长度
+const windowHeaderSize = 6
+
+// maxAckWindow 窗口的上限，远小于16位序号空间的一半，接收端才能区分重复帧与新帧
+const maxAckWindow = 1000
+
+// ackPrefix 接收端累积确认的前缀，其后为5位十进制序号
+const ackPrefix = serialcomm.WindowAckPrefix
+
+// maxCoalesceBytes 合并缓冲达到该长度时立即写出，不再等待更多的帧
+const maxCoalesceBytes = 4096
+
+// windowedFrame 已发出、等待累积确认的一帧
+type windowedFrame struct {
+	seq  uint16
+	data []byte
+	done chan<- error // 确认或放弃时写入结果，为nil时不通知（如消息中间的分片）
+}
+
+// ackWindow 滑动窗口发送：最多Size帧在途，接收端按序号累积确认，不必逐帧等待往返；
+// 超时未收到新的确认时从最早未确认的帧起全部重发（回退N帧），连续MaxRetries次仍无进展时放弃在途的帧。
+// 接收端对窗口帧总是确认，发送前应清除消息的noAck。
+// Coalesce大于0时，窗口帧各自分帧后先放入合并缓冲，在等待确认前、缓冲已满或队列空闲Coalesce后一次写出，
+// 大量小消息不再各占一次串口写入
+type ackWindow struct {
+	Port       *serial.Port
+	Size       int
+	Timeout    time.Duration
+	MaxRetries int
+	Coalesce   time.Duration
+	Hooks      []preSendHook // 每次实际写入串口前执行，含超时重发
+	// OnResult 每帧确认或放弃时调用，用于记录对端健康状态
+	OnResult func(err error)
+
+	session  byte
+	next     uint16
+	inflight []windowedFrame
+	retries  int
+	feedback []byte // 尚未解析完的确认字节
+	pending  []byte // 合并缓冲：已分帧、尚未写出的帧
+	buffered int    // 合并缓冲中的帧数
+}
+
+// newAckWindow 以随机会话号创建窗口，接收端据此识别发送端重启
+func newAckWindow(size int, timeout time.Duration, maxRetries int) *ackWindow {
+	var session [1]byte
+	_, _ = io.ReadFull(sysRand, session[:])
+	return &ackWindow{Size: size, Timeout: timeout, MaxRetries: maxRetries, session: session[0]}
+}
+
+// send 窗口有空位时发出一帧后立即返回，结果在确认或放弃时写入done；窗口满时先等待确认。
+// 返回的错误表示串口失败，此时所有在途的帧都已以该错误结束
+func (w *ackWindow) send(data []byte, done chan<- error) error {
+	for len(w.inflight) >= w.Size {
+		if err := w.await(); err != nil {
+			if done != nil {
+				done <- err
+			}
+			return err
+		}
+	}
+	frame := windowedFrame{seq: w.next, data: data, done: done}
+	w.next++
+	w.inflight = append(w.inflight, frame)
+	if err := w.write(frame); err != nil {
+		w.fail(err)
+		return err
+	}
+	return nil
+}
+
+// drain 等待所有在途的帧确认或被放弃
+func (w *ackWindow) drain() error {
+	for len(w.inflight) > 0 {
+		if err := w.await(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write 加上窗口帧头后发送，开启合并时放入合并缓冲
+func (w *ackWindow) write(frame windowedFrame) error {
+	body := make([]byte, windowHeaderSize, windowHeaderSize+len(frame.data))
+	body[0] = windowFrameMarker
+	body[1] = w.session
+	binary.BigEndian.PutUint16(body[2:4], frame.seq)
+	binary.BigEndian.PutUint16(body[4:6], w.inflight[0].seq)
+	body = append(body, frame.data...)
+	if w.Coalesce <= 0 {
+		if err := runPreSendHooks(w.Port, w.Hooks); err != nil {
+			return err
+		}
+		return sendData(w.Port, body)
+	}
+
+	encoded, err := framing.Encode(body)
+	if err != nil {
+		return err
+	}
+	w.pending = append(w.pending, encoded...)
+	w.buffered++
+	if len(w.pending) >= maxCoalesceBytes {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush 把合并缓冲中的帧一次写出，各帧保持各自的分帧
+func (w *ackWindow) flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if err := runPreSendHooks(w.Port, w.Hooks); err != nil {
+		return err
+	}
+	_, err := w.Port.Write(w.pending)
+	if err != nil {
+		return serialcomm.PortError(fmt.Sprintf("合并写出%d帧失败", w.buffered), err)
+	}
+	log.Printf("合并写出%d帧: %d字节", w.buffered, len(w.pending))
+	w.pending, w.buffered = w.pending[:0], 0
+	return nil
+}
+
+// idle 发送队列空闲时写出合并缓冲，失败时结束所有在途的帧
+func (w *ackWindow) idle() error {
+	if err := w.flush(); err != nil {
+		w.fail(err)
+		return err
+	}
+	return nil
+}
+
+// await 等待一次确认并释放已确认的帧；超时则重发全部在途帧，重试用尽时放弃它们
+func (w *ackWindow) await() error {
+	if err := w.flush(); err != nil {
+		w.fail(err)
+		return err
+	}
+	acked, err := w.readAck()
+	switch {
+	case err == nil:
+		if w.release(acked) {
+			w.retries = 0
+		}
+		return nil
+	case !errors.Is(err, errFeedbackTimeout):
+		w.fail(err)
+		return err
+	}
+
+	w.retries++
+	if w.retries > w.MaxRetries {
+		w.retries = 0
+		w.fail(&serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: fmt.Errorf("连续%d次超时未收到确认，放弃%d帧", w.MaxRetries+1, len(w.inflight))})
+		return nil
+	}
+	log.Printf("%v 内未收到新的确认，从序号 %d 起重发 %d 帧 (第%d/%d次)", w.Timeout, w.inflight[0].seq, len(w.inflight), w.retries, w.MaxRetries)
+	for _, frame := range w.inflight {
+		if err := w.write(frame); err != nil {
+			w.fail(err)
+			return err
+		}
+	}
+	if err := w.flush(); err != nil {
+		w.fail(err)
+		return err
+	}
+	return nil
+}
+
+// release 释放累积确认覆盖的帧，确认不在窗口内（重复或过时）时返回false
+func (w *ackWindow) release(acked uint16) bool {
+	for i, frame := range w.inflight {
+		if frame.seq != acked {
+			continue
+		}
+		for _, f := range w.inflight[:i+1] {
+			w.finish(f, nil)
+		}
+		w.inflight = w.inflight[i+1:]
+		return true
+	}
+	return false
+}
+
+// fail 以err结束所有在途的帧
+func (w *ackWindow) fail(err error) {
+	for _, f := range w.inflight {
+		w.finish(f, err)
+	}
+	w.inflight = nil
+	w.pending, w.buffered = nil, 0
+}
+
+func (w *ackWindow) finish(frame windowedFrame, err error) {
+	if frame.done != nil {
+		frame.done <- err
+	}
+	if w.OnResult != nil {
+		w.OnResult(err)
+	}
+}
+
+// readAck 在Timeout内读取一条累积确认 "ACK"+5位十进制序号，跳过其他字节
+func (w *ackWindow) readAck() (uint16, error) {
+	buf := make([]byte, 64)
+	start := sysClock.Now()
+	for {
+		if i := bytes.Index(w.feedback, []byte(ackPrefix)); i >= 0 {
+			w.feedback = w.feedback[i:]
+			if end := len(ackPrefix) + 5; len(w.feedback) >= end {
+				digits := string(w.feedback[len(ackPrefix):end])
+				w.feedback = w.feedback[end:]
+				seq, err := strconv.ParseUint(digits, 10, 16)
+				if err == nil {
+					return uint16(seq), nil
+				}
+				log.Printf("跳过无效的确认 %q", ackPrefix+digits)
+				continue
+			}
+		} else if len(w.feedback) > len(ackPrefix) {
+			w.feedback = w.feedback[len(w.feedback)-len(ackPrefix):] // 只保留可能是前缀开头的字节
+		}
+
+		if sysClock.Since(start) >= w.Timeout {
+			return 0, fmt.Errorf("%w (%v)", errFeedbackTimeout, w.Timeout)
+		}
+		n, err := w.Port.Read(buf)
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return 0, serialcomm.PortError("读取确认失败", err)
+		}
+		w.feedback = append(w.feedback, buf[:n]...)
+		if n == 0 {
+			sysClock.Sleep(10 * time.Millisecond) // 防止CPU过载
+		}
+	}
+}
+
+// sendWindowed 经滑动窗口发送一条消息，超过最大帧长时分片，只有最后一片的结果写入done；
+// 串口失败时关闭串口，下次发送时重新打开
+func sendWindowed(w *ackWindow, port *serial.Port, config *serial.Config, settings openSettings, data []byte, policy retryPolicy, done chan<- error) (*serial.Port, error) {
+	var err error
+	if policy.Health != nil {
+		port, err = policy.Health.admit(port, config, settings, policy.Feedback)
+	} else if port == nil {
+		port, err = openPort(config, settings)
+	}
+	if err == nil && port == nil {
+		port, err = openPort(config, settings)
+	}
+	if err != nil {
+		done <- err
+		return port, err
+	}
+	w.Port = port
+
+	chunks := [][]byte{data}
+	if limit := frameLimit(policy); limit > 0 && len(data) > limit-windowHeaderSize {
+		chunks, err = splitFragments(data, limit-windowHeaderSize)
+		if err != nil {
+			done <- err
+			return port, err
+		}
+	}
+	for i, chunk := range chunks {
+		var result chan<- error
+		if i == len(chunks)-1 {
+			result = done
+		}
+		if err = w.send(chunk, result); err != nil {
+			if result == nil {
+				done <- err // 前面的分片失败，最后一片不会再发送
+			}
+			if errors.Is(err, serialcomm.ErrPort) {
+				port.Close()
+				port, w.Port = nil, nil
+			}
+			return port, err
+		}
+	}
+	return port, nil
+}
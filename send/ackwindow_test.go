@@ -0,0 +1,226 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/ptytest"
+	"send/internal/serialcomm"
+)
+
+// windowPeer 在伪终端主端模拟滑动窗口的接收端：按序号接收窗口帧，每帧之后回复reply的结果
+type windowPeer struct {
+	master *os.File
+
+	mu   sync.Mutex
+	seqs []uint16 // 按到达顺序收到的序号，含重发
+}
+
+// startWindowPeer 打开伪终端并启动模拟对端，返回对端和接在从端上的窗口；
+// reply为nil时按序累积确认：序号连续时确认该序号，否则重复确认最后一个连续的序号
+func startWindowPeer(t *testing.T, size int, reply func(seq uint16, expected *uint16) string) (*windowPeer, *ackWindow) {
+	t.Helper()
+	master, slave := ptytest.Open(t)
+	port, err := serial.OpenPort(&serial.Config{Name: slave, Baud: 115200, ReadTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("打开从端失败: %v", err)
+	}
+	t.Cleanup(func() { port.Close() })
+
+	if reply == nil {
+		reply = func(seq uint16, expected *uint16) string {
+			if seq == *expected {
+				*expected++
+			}
+			return serialcomm.WindowAck(*expected - 1)
+		}
+	}
+	peer := &windowPeer{master: master}
+	go peer.serve(reply)
+
+	w := newAckWindow(size, 200*time.Millisecond, 3)
+	w.Port = port
+	return peer, w
+}
+
+// serve 解码主端收到的帧并回复，主端关闭时返回
+func (p *windowPeer) serve(reply func(seq uint16, expected *uint16) string) {
+	var buffer bytes.Buffer
+	var expected uint16
+	buf := make([]byte, 256)
+	for {
+		n, err := p.master.Read(buf)
+		if err != nil {
+			return
+		}
+		buffer.Write(buf[:n])
+		for {
+			body, err := framing.Decode(&buffer)
+			if err != nil {
+				break // 帧尚不完整
+			}
+			if len(body) < windowHeaderSize || body[0] != windowFrameMarker {
+				continue
+			}
+			seq := binary.BigEndian.Uint16(body[2:4])
+			p.mu.Lock()
+			p.seqs = append(p.seqs, seq)
+			p.mu.Unlock()
+			if ack := reply(seq, &expected); ack != "" {
+				p.master.Write([]byte(ack))
+			}
+		}
+	}
+}
+
+// received 返回对端按到达顺序收到的序号
+func (p *windowPeer) received() []uint16 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]uint16(nil), p.seqs...)
+}
+
+// sendAll 经窗口发送每条数据，返回各自的结果通道
+func sendAll(t *testing.T, w *ackWindow, data ...string) []chan error {
+	t.Helper()
+	var results []chan error
+	for _, d := range data {
+		done := make(chan error, 1)
+		if err := w.send([]byte(d), done); err != nil {
+			t.Fatalf("发送 %q 失败: %v", d, err)
+		}
+		results = append(results, done)
+	}
+	return results
+}
+
+// expectResults 检查每帧的结果，want为nil时期望成功
+func expectResults(t *testing.T, results []chan error, want error) {
+	t.Helper()
+	for i, done := range results {
+		select {
+		case err := <-done:
+			if (want == nil && err != nil) || (want != nil && !errors.Is(err, want)) {
+				t.Errorf("第%d帧的结果为 %v，期望 %v", i, err, want)
+			}
+		default:
+			t.Errorf("第%d帧没有结果", i)
+		}
+	}
+}
+
+func TestAckWindowCumulativeAck(t *testing.T) {
+	// 对端只在第三帧到达后确认一次，一条累积确认释放全部三帧
+	peer, w := startWindowPeer(t, 4, func(seq uint16, expected *uint16) string {
+		if seq == 2 {
+			return serialcomm.WindowAck(2)
+		}
+		return ""
+	})
+	results := sendAll(t, w, "a", "b", "c")
+	if len(w.inflight) != 3 {
+		t.Fatalf("在途 %d 帧，期望窗口未满时不等待确认", len(w.inflight))
+	}
+	if err := w.drain(); err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, results, nil)
+	if got, want := peer.received(), []uint16{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("对端收到序号 %v，期望 %v（不应重发）", got, want)
+	}
+}
+
+func TestAckWindowFullWaitsForAck(t *testing.T) {
+	// 窗口为2时第三帧须等到前面的帧被确认后才发出
+	peer, w := startWindowPeer(t, 2, nil)
+	results := sendAll(t, w, "a", "b", "c")
+	if len(w.inflight) > 2 {
+		t.Errorf("在途 %d 帧，超过窗口", len(w.inflight))
+	}
+	if err := w.drain(); err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, results, nil)
+	if got, want := peer.received(), []uint16{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("对端收到序号 %v，期望 %v", got, want)
+	}
+}
+
+func TestAckWindowGoBackN(t *testing.T) {
+	// 对端第一次丢失序号1，之后的序号2不连续，只重复确认0；超时后发送端须从1起全部重发，
+	// 对端不单独确认序号1，只有1和2在同一轮重发中到达时才会收到累积确认
+	dropped := false
+	peer, w := startWindowPeer(t, 4, func(seq uint16, expected *uint16) string {
+		if seq == 1 && !dropped {
+			dropped = true
+			return ""
+		}
+		if seq == *expected {
+			*expected++
+		}
+		if seq == 1 {
+			return ""
+		}
+		return serialcomm.WindowAck(*expected - 1)
+	})
+	results := sendAll(t, w, "a", "b", "c")
+	if err := w.drain(); err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, results, nil)
+	if got, want := peer.received(), []uint16{0, 1, 2, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("对端收到序号 %v，期望 %v", got, want)
+	}
+}
+
+func TestAckWindowGivesUp(t *testing.T) {
+	// 对端从不确认，重试用尽后在途的帧以ErrNack结束
+	peer, w := startWindowPeer(t, 4, func(uint16, *uint16) string { return "" })
+	w.Timeout, w.MaxRetries = 50*time.Millisecond, 1
+	results := sendAll(t, w, "a", "b")
+	if err := w.drain(); err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, results, serialcomm.ErrNack)
+	if got, want := peer.received(), []uint16{0, 1, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("对端收到序号 %v，期望 %v", got, want)
+	}
+}
+
+func TestAckWindowCoalesce(t *testing.T) {
+	peer, w := startWindowPeer(t, 8, nil)
+	w.Coalesce = 10 * time.Millisecond
+	results := sendAll(t, w, "a", "b", "c")
+	if w.buffered != 3 {
+		t.Fatalf("合并缓冲中有 %d 帧，期望 3", w.buffered)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := peer.received(); len(got) != 0 {
+		t.Fatalf("写出合并缓冲之前对端已收到 %v", got)
+	}
+
+	// 队列空闲时一次写出
+	if err := w.idle(); err != nil {
+		t.Fatal(err)
+	}
+	if w.buffered != 0 || len(w.pending) != 0 {
+		t.Errorf("写出后合并缓冲未清空: %d帧", w.buffered)
+	}
+	if err := w.drain(); err != nil {
+		t.Fatal(err)
+	}
+	expectResults(t, results, nil)
+	if got, want := peer.received(), []uint16{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("对端收到序号 %v，期望 %v", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// auditRecord 一条出站命令的审计记录
+type auditRecord struct {
+	Time          time.Time `json:"time"`
+	Operator      string    `json:"operator"`
+	Port          string    `json:"port"`
+	CorrelationID string    `json:"correlationID"`
+	ContentType   string    `json:"contentType"`
+	Bytes         int       `json:"bytes"`
+	Result        string    `json:"result"` // "ok" 或 "failed"
+	Error         string    `json:"error,omitempty"`
+}
+
+// auditWriter 审计记录的输出目标
+type auditWriter interface {
+	WriteAudit(record auditRecord) error
+}
+
+// fileAudit 以JSON Lines追加写入审计文件
+type fileAudit struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openFileAudit 以只追加方式打开审计文件
+func openFileAudit(path string) (*fileAudit, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计文件失败: %v", err)
+	}
+	return &fileAudit{file: f}, nil
+}
+
+func (a *fileAudit) WriteAudit(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *fileAudit) Close() error {
+	return a.file.Close()
+}
+
+// auditor 将出站命令的结果写入所有配置的审计目标
+type auditor struct {
+	Operator string
+	Port     string
+	Writers  []auditWriter
+}
+
+// currentOperator 返回当前系统用户名，作为默认的操作者
+func currentOperator() string {
+	u, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return u.Username
+}
+
+// record 记录一次发送的结果，任一审计目标写入失败都会返回错误
+func (a *auditor) record(message Message, size int, sendErr error) error {
+	record := auditRecord{
+		Time:          sysClock.Now(),
+		Operator:      a.Operator,
+		Port:          a.Port,
+		CorrelationID: message.CorrelationID,
+		ContentType:   message.ContentType,
+		Bytes:         size,
+		Result:        "ok",
+	}
+	if sendErr != nil {
+		record.Result = "failed"
+		record.Error = sendErr.Error()
+	}
+	for _, w := range a.Writers {
+		if err := w.WriteAudit(record); err != nil {
+			return fmt.Errorf("写入审计记录失败: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package main
+
+import "fmt"
+
+// syslogAudit 当前平台没有syslog
+type syslogAudit struct{}
+
+func openSyslogAudit(tag string) (*syslogAudit, error) {
+	return nil, fmt.Errorf("当前平台不支持syslog")
+}
+
+func (a *syslogAudit) WriteAudit(record auditRecord) error {
+	return fmt.Errorf("当前平台不支持syslog")
+}
@@ -0,0 +1,31 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// syslogAudit 将审计记录以JSON形式写入系统日志
+type syslogAudit struct {
+	w *syslog.Writer
+}
+
+// openSyslogAudit 连接本机syslog，tag用于区分来源
+func openSyslogAudit(tag string) (*syslogAudit, error) {
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("连接syslog失败: %v", err)
+	}
+	return &syslogAudit{w: w}, nil
+}
+
+func (a *syslogAudit) WriteAudit(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return a.w.Notice(string(line))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tarm/serial"
+)
+
+// broadcastResult 广播到单个串口的结果
+type broadcastResult struct {
+	Port string
+	Err  error
+}
+
+// broadcast 并发地向多个串口发送同一数据（各自打开串口并按策略重试），按串口名返回每个串口的结果
+func broadcast(configs []*serial.Config, settings openSettings, data []byte, hooks []preSendHook, policy retryPolicy) []broadcastResult {
+	results := make([]broadcastResult, len(configs))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func(i int, config *serial.Config) {
+			defer wg.Done()
+			port, err := sendWithRetry(nil, config, settings, data, hooks, policy)
+			if port != nil {
+				closePort(port, config.Name)
+			}
+			results[i] = broadcastResult{Port: config.Name, Err: err}
+		}(i, config)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(a, b int) bool { return results[a].Port < results[b].Port })
+	return results
+}
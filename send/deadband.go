@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filterRule 单个资源的发送过滤规则
+type filterRule struct {
+	Deadband    float64       // 数值变化不超过该值时不发送，0表示任何变化都发送
+	MinInterval time.Duration // 同一资源两次发送的最小间隔
+}
+
+// parseFilterRule 解析 -filter 的取值：资源名=死区[/最小间隔]，如 Int8=2/1m、Status=0
+func parseFilterRule(spec string) (string, filterRule, error) {
+	resource, value, ok := strings.Cut(spec, "=")
+	if !ok || resource == "" {
+		return "", filterRule{}, fmt.Errorf("%q 应为 资源名=死区[/最小间隔]，如 Int8=2/1m", spec)
+	}
+	deadband, interval, _ := strings.Cut(value, "/")
+	var rule filterRule
+	var err error
+	rule.Deadband, err = strconv.ParseFloat(deadband, 64)
+	if err != nil || rule.Deadband < 0 || math.IsNaN(rule.Deadband) || math.IsInf(rule.Deadband, 0) {
+		return "", filterRule{}, fmt.Errorf("%q 的死区 %q 应为非负数，0表示任何变化都发送", spec, deadband)
+	}
+	if interval != "" {
+		rule.MinInterval, err = time.ParseDuration(interval)
+		if err != nil || rule.MinInterval < 0 {
+			return "", filterRule{}, fmt.Errorf("%q 的最小间隔 %q 应为非负的时长，如 30s", spec, interval)
+		}
+	}
+	return resource, rule, nil
+}
+
+// sentReading 资源上次发送的值和时间
+type sentReading struct {
+	Value string    `json:"value"`
+	Sent  time.Time `json:"sent"`
+}
+
+// telemetryFilter 按资源过滤未变化或变化很小的读数，节省慢速链路的带宽；
+// 上次发送的值保存在状态文件中，使每次启动一个发送进程时过滤仍然有效
+type telemetryFilter struct {
+	Rules     map[string]filterRule // 键为资源名
+	StateFile string
+}
+
+// apply 过滤消息payload中的读数，返回过滤后的消息以及是否还有需要发送的读数；
+// 通过过滤的读数即记为已发送，发送失败不会回退状态
+func (f telemetryFilter) apply(message Message) (Message, bool, error) {
+	payloadData, err := base64.StdEncoding.DecodeString(message.Payload)
+	if err != nil {
+		return message, false, fmt.Errorf("解码Payload失败: %v", err)
+	}
+	var payload Payload
+	err = json.Unmarshal(payloadData, &payload)
+	if err != nil {
+		return message, false, fmt.Errorf("解析Payload失败: %v", err)
+	}
+
+	state := make(map[string]sentReading)
+	data, err := os.ReadFile(f.StateFile)
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return message, false, fmt.Errorf("读取过滤状态失败: %v", err)
+	}
+
+	now := sysClock.Now()
+	var kept []Reading
+	for _, reading := range payload.Event.Readings {
+		key := reading.DeviceName + "/" + reading.ResourceName
+		rule, ok := f.Rules[reading.ResourceName]
+		last, seen := state[key]
+		if ok && seen && !rule.shouldSend(last, reading.Value, now) {
+			continue
+		}
+		kept = append(kept, reading)
+		state[key] = sentReading{Value: reading.Value, Sent: now}
+	}
+	if len(kept) == 0 {
+		return message, false, nil
+	}
+
+	data, err = json.Marshal(state)
+	if err != nil {
+		return message, false, err
+	}
+	err = os.WriteFile(f.StateFile, data, 0644)
+	if err != nil {
+		return message, false, fmt.Errorf("写入过滤状态失败: %v", err)
+	}
+
+	if len(kept) != len(payload.Event.Readings) {
+		payload.Event.Readings = kept
+		payloadData, err = json.Marshal(payload)
+		if err != nil {
+			return message, false, err
+		}
+		message.Payload = base64.StdEncoding.EncodeToString(payloadData)
+	}
+	return message, true, nil
+}
+
+// shouldSend 判断读数相对上次发送是否需要发送：先受最小间隔限制，再比较死区；非数值读数按是否相等判断
+func (r filterRule) shouldSend(last sentReading, value string, now time.Time) bool {
+	if now.Sub(last.Sent) < r.MinInterval {
+		return false
+	}
+	prev, err1 := strconv.ParseFloat(last.Value, 64)
+	cur, err2 := strconv.ParseFloat(value, 64)
+	if err1 != nil || err2 != nil {
+		return value != last.Value
+	}
+	return math.Abs(cur-prev) > r.Deadband || (r.Deadband == 0 && cur != prev)
+}
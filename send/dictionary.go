@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// dictFrameMarker 字典压缩帧的首字节，JSON帧总以 { 开头，不会与之冲突
+const dictFrameMarker = 0x01
+
+// builtinDictID 内置EdgeX字典的编号
+const builtinDictID = 1
+
+// builtinDict 内置字典：典型的EdgeX消息信封及payload的base64前缀，越常见的内容越靠后
+var builtinDict = []byte(`"readings":[{"id":"","origin":,"deviceName":"","resourceName":"","profileName":"","valueType":"Int8","value":""}]` +
+	`eyJhcGlWZXJzaW9uIjoidjMiLCJyZXF1ZXN0SWQiOiI` +
+	`{"apiVersion":"v3","receivedTopic":"","correlationID":"","requestID":"","errorCode":0,"payload":"","contentType":"application/json"}`)
+
+// compressWithDict 使用共享字典压缩帧体：标记字节 | 字典编号 | DEFLATE数据；
+// 通用gzip对200字节左右的小帧几乎无效，预置字典能让首次出现的键名也被压缩
+func compressWithDict(data []byte, dictID byte, dict []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(dictFrameMarker)
+	buf.WriteByte(dictID)
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, dict)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// trainDictionary 从抓包的帧体中训练字典：统计JSON中被引号分隔的片段出现次数，
+// 按次数从少到多拼接，使最常见的片段位于字典末尾（DEFLATE引用距离最短）
+func trainDictionary(captures [][]byte, size int) []byte {
+	counts := make(map[string]int)
+	for _, capture := range captures {
+		for _, segment := range strings.Split(string(capture), `"`) {
+			if len(segment) >= 3 {
+				counts[`"`+segment+`"`]++
+			}
+		}
+	}
+
+	segments := make([]string, 0, len(counts))
+	for segment, n := range counts {
+		if n > 1 {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if counts[segments[i]] != counts[segments[j]] {
+			return counts[segments[i]] > counts[segments[j]]
+		}
+		return segments[i] < segments[j]
+	})
+
+	// 从最常见的片段开始挑选直到填满，再反转顺序
+	var picked []string
+	total := 0
+	for _, segment := range segments {
+		if total+len(segment) > size {
+			continue
+		}
+		picked = append(picked, segment)
+		total += len(segment)
+	}
+	var dict bytes.Buffer
+	for i := len(picked) - 1; i >= 0; i-- {
+		dict.WriteString(picked[i])
+	}
+	return dict.Bytes()
+}
+
+// trainDictionaryFiles 读取抓包文件（每行一个帧体JSON）训练字典并写入out
+func trainDictionaryFiles(out string, files []string) error {
+	var captures [][]byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("读取抓包文件失败: %v", err)
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(line) > 0 {
+				captures = append(captures, line)
+			}
+		}
+	}
+	dict := trainDictionary(captures, 32*1024) // DEFLATE窗口为32KB
+	err := os.WriteFile(out, dict, 0644)
+	if err != nil {
+		return fmt.Errorf("写入字典失败: %v", err)
+	}
+	fmt.Printf("已从 %d 个帧训练字典 (%d字节) 到 %s\n", len(captures), len(dict), out)
+	return nil
+}
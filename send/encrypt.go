@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+
+	"send/internal/serialcomm"
+)
+
+// encryptedFrameMarker 加密帧的首字节，其后为 随机数(12) | AES-GCM密文及认证标签
+const encryptedFrameMarker = 0x04
+
+// linkCipher 由链路预共享密钥创建AES-GCM，密钥为十六进制编码的16/24/32字节
+func linkCipher(key serialcomm.KeyProvider) (cipher.AEAD, error) {
+	secret, err := serialcomm.HexKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("链路密钥无效: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFrame 用链路密钥加密帧体，随机数随帧发送，标记字节作为附加认证数据
+func encryptFrame(data []byte, key serialcomm.KeyProvider) ([]byte, error) {
+	gcm, err := linkCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(sysRand, nonce); err != nil {
+		return nil, fmt.Errorf("生成随机数失败: %v", err)
+	}
+	frame := append([]byte{encryptedFrameMarker}, nonce...)
+	return gcm.Seal(frame, nonce, data, []byte{encryptedFrameMarker}), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// fragmentFrameMarker 分片帧的首字节，其后为 消息编号(2) | 分片序号(2) | 分片总数(2)，均为大端序
+const fragmentFrameMarker = 0x03
+
+// fragmentHeaderSize 分片帧头的长度
+const fragmentHeaderSize = 7
+
+// splitFragments 把超过maxLength的帧体切分为带编号的分片帧，每个分片帧不超过maxLength
+func splitFragments(data []byte, maxLength int) ([][]byte, error) {
+	chunkSize := maxLength - fragmentHeaderSize
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("最大帧长 %d 不足以容纳分片头", maxLength)
+	}
+	count := (len(data) + chunkSize - 1) / chunkSize
+	if count > 0xFFFF {
+		return nil, fmt.Errorf("数据过大，需要 %d 个分片", count)
+	}
+
+	var id [2]byte
+	_, err := io.ReadFull(sysRand, id[:])
+	if err != nil {
+		return nil, fmt.Errorf("生成分片消息编号失败: %v", err)
+	}
+
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*chunkSize, len(data))
+		fragment := make([]byte, fragmentHeaderSize, fragmentHeaderSize+end-i*chunkSize)
+		fragment[0] = fragmentFrameMarker
+		copy(fragment[1:3], id[:])
+		binary.BigEndian.PutUint16(fragment[3:5], uint16(i))
+		binary.BigEndian.PutUint16(fragment[5:7], uint16(count))
+		fragments = append(fragments, append(fragment, data[i*chunkSize:end]...))
+	}
+	return fragments, nil
+}
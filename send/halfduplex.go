@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// halfDuplex RS-485等半双工总线的发送控制：对端回复期间不能发送，否则两端驱动器同时驱动总线，
+// 双方的数据都会损坏。发送前先等待收发切换时间，再等总线空闲一段时间
+type halfDuplex struct {
+	Turnaround  time.Duration // 收发切换时间，给对端的驱动器留出释放总线的时间
+	IdleTime    time.Duration // 总线至少空闲这么久才视为空闲，精度受串口ReadTimeout限制
+	IdleTimeout time.Duration // 等待总线空闲的最长时间，超时放弃本次发送
+}
+
+// waitIdle 读取并丢弃总线上的数据，直到连续IdleTime没有收到任何字节，返回丢弃的字节数
+func (h halfDuplex) waitIdle(port *serial.Port) (int, error) {
+	buf := make([]byte, 256)
+	start := sysClock.Now()
+	lastByte := start
+	discarded := 0
+	for sysClock.Since(lastByte) < h.IdleTime {
+		if sysClock.Since(start) > h.IdleTimeout {
+			return discarded, fmt.Errorf("总线在 %v 内未空闲（已丢弃 %d 字节）", h.IdleTimeout, discarded)
+		}
+		n, err := port.Read(buf)
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return discarded, serialcomm.PortError("检测总线空闲失败", err)
+		}
+		if n > 0 {
+			discarded += n
+			lastByte = sysClock.Now()
+		}
+	}
+	return discarded, nil
+}
+
+// hook 将收发切换和空闲检测包装为发送前钩子
+func (h halfDuplex) hook() preSendHook {
+	return func(port *serial.Port) error {
+		sysClock.Sleep(h.Turnaround)
+		if h.IdleTime <= 0 {
+			return nil
+		}
+		discarded, err := h.waitIdle(port)
+		if discarded > 0 {
+			log.Printf("等待总线空闲，丢弃他人的 %d 字节", discarded)
+		}
+		return err
+	}
+}
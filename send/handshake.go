@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// helloControlType 握手控制帧的类型，帧体为该字节后接本端能力的JSON
+const helloControlType = 0x10
+
+// helloReplyPrefix 接收端回复握手的前缀，整行为前缀加能力JSON再加换行
+const helloReplyPrefix = "HELLO "
+
+// crcAlgorithm 帧校验使用的CRC算法名称，握手时交换
+const crcAlgorithm = "crc16-modbus"
+
+// peerIdentity 握手时通告的设备身份，字段均可为空
+type peerIdentity struct {
+	Serial   string `json:"serial,omitempty"`   // 设备序列号
+	Model    string `json:"model,omitempty"`    // 设备型号
+	Firmware string `json:"firmware,omitempty"` // 固件版本，如 1.4.2
+}
+
+// capabilities 握手时交换的协议能力，列表为空表示不支持或未使用该特性
+type capabilities struct {
+	Versions []int    `json:"versions,omitempty"` // 帧版本头，不使用版本头时为空
+	MaxFrame int      `json:"maxFrame"`           // 最大帧体长度，0表示不限
+	Dicts    []int    `json:"dicts,omitempty"`    // 压缩字典编号，0为不带字典的普通DEFLATE
+	KeyMaps  []int    `json:"keyMaps,omitempty"`  // 键名映射表版本
+	CRC      []string `json:"crc"`                // CRC算法，按优先级排列
+
+	Identity *peerIdentity `json:"identity,omitempty"` // 本端身份，旧版本不通告
+}
+
+// codecVersions 返回发送使用的版本头，穿过认证和同步标记等外层包装
+func codecVersions(codec serialcomm.FrameCodec) []int {
+	switch c := codec.(type) {
+	case serialcomm.VersionedCodec:
+		return []int{int(c.Version)}
+	case serialcomm.HMACCodec:
+		return codecVersions(c.Inner)
+	case serialcomm.SyncCodec:
+		return codecVersions(c.Inner)
+	}
+	return nil
+}
+
+// 握手时可能被降级的特性
+const (
+	featureVersion     = "version"     // 帧版本头
+	featureCompression = "compression" // 字典压缩
+	featureKeyMap      = "keymap"      // 键名缩短
+)
+
+// downgrade 握手时因对端不支持而关闭或降低的一项特性
+type downgrade struct {
+	Feature string `json:"feature"` // featureVersion等
+	From    string `json:"from"`    // 本端配置的取值
+	To      string `json:"to"`      // 降级后的取值，off表示关闭
+	Reason  string `json:"reason"`
+}
+
+// report 以结构化的JSON输出降级警告，便于日志采集按 event 字段告警
+func (d downgrade) report(port string) {
+	line, _ := json.Marshal(struct {
+		Event string `json:"event"`
+		Port  string `json:"port"`
+		downgrade
+	}{"protocol_downgrade", port, d})
+	log.Printf("警告: 协议降级 %s", line)
+}
+
+// negotiationPolicy 协商时如何处理对端不支持的特性
+type negotiationPolicy struct {
+	// AllowDowngrade 对端不支持本端的压缩字典或键名映射表时关闭该特性，不支持本端的帧版本时
+	// 改用Versions中双方都支持的最高版本，并逐项返回降级；为false时返回错误
+	AllowDowngrade bool
+	Versions       []int // 本端能够使用的全部帧版本（-accept-versions）
+
+	// Gates 特性对对端固件版本的要求，对端固件低于要求或未通告版本时自动关闭该特性并返回降级，
+	// 不受AllowDowngrade限制
+	Gates []firmwareGate
+
+	// OnPeerIdentified 收到对端回复后、协商之前以对端通告的身份调用（旧版本对端为nil），
+	// 返回错误时握手失败，如对端不在允许列表中
+	OnPeerIdentified func(identity *peerIdentity) error
+}
+
+// firmwareGate 某项特性要求的最低对端固件版本，如只对1.4及以上的固件开启压缩
+type firmwareGate struct {
+	Feature string // featureCompression或featureKeyMap
+	Min     string // 最低固件版本，如 1.4
+}
+
+// parseFirmwareGate 解析 -require-firmware 的取值，如 compression>=1.4
+func parseFirmwareGate(spec string) (firmwareGate, error) {
+	feature, min, ok := strings.Cut(spec, ">=")
+	if !ok {
+		return firmwareGate{}, fmt.Errorf("%q 应为 特性>=固件版本，如 compression>=1.4", spec)
+	}
+	if feature != featureCompression && feature != featureKeyMap {
+		return firmwareGate{}, fmt.Errorf("%q 中的特性 %q 无效：应为 %s 或 %s", spec, feature, featureCompression, featureKeyMap)
+	}
+	if _, ok := parseFirmwareVersion(min); !ok {
+		return firmwareGate{}, fmt.Errorf("%q 中的固件版本 %q 无效：应为以点分隔的数字，如 1.4.2", spec, min)
+	}
+	return firmwareGate{Feature: feature, Min: min}, nil
+}
+
+// parseFirmwareVersion 解析以点分隔的固件版本，允许前缀v，如 v1.4.2
+func parseFirmwareVersion(version string) ([]int, bool) {
+	fields := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	parts := make([]int, len(fields))
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// satisfiedBy 判断对端通告的固件版本是否达到要求，版本缺少的段按0比较，如 1.4 与 1.4.0 相同；
+// 对端未通告版本或版本无法解析时视为不满足
+func (g firmwareGate) satisfiedBy(identity *peerIdentity) bool {
+	if identity == nil {
+		return false
+	}
+	have, ok := parseFirmwareVersion(identity.Firmware)
+	if !ok {
+		return false
+	}
+	want, _ := parseFirmwareVersion(g.Min)
+	for i := 0; i < max(len(have), len(want)); i++ {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// peerPattern 对端允许列表中的一项，各字段为通配符（支持 * ?），为空表示不限
+type peerPattern struct {
+	Serial, Model, Firmware string
+}
+
+// parsePeerPattern 解析 -allow-peer 的取值，如 model=TH-*,firmware=1.4.*
+func parsePeerPattern(spec string) (peerPattern, error) {
+	var p peerPattern
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || value == "" {
+			return p, fmt.Errorf("%q 应为逗号分隔的 键=通配符，键为 serial、model 或 firmware", spec)
+		}
+		if _, err := path.Match(value, ""); err != nil {
+			return p, fmt.Errorf("%q 中的通配符 %q 无效: %v", spec, value, err)
+		}
+		switch key {
+		case "serial":
+			p.Serial = value
+		case "model":
+			p.Model = value
+		case "firmware":
+			p.Firmware = value
+		default:
+			return p, fmt.Errorf("%q 中的键 %q 无效：应为 serial、model 或 firmware", spec, key)
+		}
+	}
+	return p, nil
+}
+
+// matches 判断对端身份是否符合该项的全部字段
+func (p peerPattern) matches(identity peerIdentity) bool {
+	for _, field := range []struct{ pattern, value string }{
+		{p.Serial, identity.Serial}, {p.Model, identity.Model}, {p.Firmware, identity.Firmware},
+	} {
+		if field.pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(field.pattern, field.value); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAllowlist 对端身份符合允许列表中的任意一项时返回nil；列表为空时不限制，
+// 列表非空而对端未通告身份（旧固件）时拒绝
+func checkAllowlist(allowlist []peerPattern, identity *peerIdentity) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	if identity == nil {
+		return &serialcomm.LinkError{Kind: serialcomm.ErrAuth, Err: errors.New("对端未通告身份，无法按允许列表核对")}
+	}
+	for _, p := range allowlist {
+		if p.matches(*identity) {
+			return nil
+		}
+	}
+	return &serialcomm.LinkError{Kind: serialcomm.ErrAuth, Err: fmt.Errorf("对端 %+v 不在允许列表中", *identity)}
+}
+
+// negotiate 按本端将要使用的能力与对端能力求交集，本端使用了对端不支持的特性时返回错误，
+// 或按policy降级并返回各项降级；最大帧长取两端的较小值
+func negotiate(local, peer capabilities, policy negotiationPolicy) (capabilities, []downgrade, error) {
+	agreed := capabilities{MaxFrame: local.MaxFrame}
+	if peer.MaxFrame > 0 && (agreed.MaxFrame == 0 || peer.MaxFrame < agreed.MaxFrame) {
+		agreed.MaxFrame = peer.MaxFrame
+	}
+	var downgrades []downgrade
+	for _, check := range []struct {
+		name, feature string
+		local, peer   []int
+		fallback      []int
+		agreed        *[]int
+	}{
+		{"帧版本", featureVersion, local.Versions, peer.Versions, policy.Versions, &agreed.Versions},
+		{"压缩字典", featureCompression, local.Dicts, peer.Dicts, nil, &agreed.Dicts},
+		{"键名映射表版本", featureKeyMap, local.KeyMaps, peer.KeyMaps, nil, &agreed.KeyMaps},
+	} {
+		for _, v := range check.local {
+			if slices.Contains(check.peer, v) {
+				*check.agreed = append(*check.agreed, v)
+				continue
+			}
+			err := fmt.Errorf("对端不支持%s %d (对端支持 %v)", check.name, v, check.peer)
+			if !policy.AllowDowngrade {
+				return agreed, downgrades, err
+			}
+			to := "off"
+			if check.feature == featureVersion {
+				common := slices.DeleteFunc(slices.Clone(check.fallback), func(f int) bool { return !slices.Contains(check.peer, f) })
+				if len(common) == 0 {
+					return agreed, downgrades, err
+				}
+				best := slices.Max(common)
+				*check.agreed = append(*check.agreed, best)
+				to = strconv.Itoa(best)
+			}
+			downgrades = append(downgrades, downgrade{Feature: check.feature, From: strconv.Itoa(v), To: to, Reason: err.Error()})
+		}
+	}
+	for _, gate := range policy.Gates {
+		enabled := map[string]*[]int{featureCompression: &agreed.Dicts, featureKeyMap: &agreed.KeyMaps}[gate.Feature]
+		if len(*enabled) == 0 || gate.satisfiedBy(peer.Identity) {
+			continue
+		}
+		firmware := "未通告"
+		if peer.Identity != nil && peer.Identity.Firmware != "" {
+			firmware = peer.Identity.Firmware
+		}
+		downgrades = append(downgrades, downgrade{
+			Feature: gate.Feature,
+			From:    strconv.Itoa((*enabled)[0]),
+			To:      "off",
+			Reason:  fmt.Sprintf("对端固件 %s 低于 %s 要求的 %s", firmware, gate.Feature, gate.Min),
+		})
+		*enabled = nil
+	}
+	for _, crc := range local.CRC {
+		if slices.Contains(peer.CRC, crc) {
+			agreed.CRC = []string{crc}
+			break
+		}
+	}
+	if len(agreed.CRC) == 0 {
+		return agreed, downgrades, fmt.Errorf("没有共同的CRC算法 (本端 %v，对端 %v)", local.CRC, peer.CRC)
+	}
+	return agreed, downgrades, nil
+}
+
+// withSendVersion 返回改用version发送的分帧方式，穿过认证和同步标记等外层包装
+func withSendVersion(codec serialcomm.FrameCodec, version byte) serialcomm.FrameCodec {
+	switch c := codec.(type) {
+	case serialcomm.VersionedCodec:
+		c.Version = version
+		return c
+	case serialcomm.HMACCodec:
+		c.Inner = withSendVersion(c.Inner, version)
+		return c
+	case serialcomm.SyncCodec:
+		c.Inner = withSendVersion(c.Inner, version)
+		return c
+	}
+	return codec
+}
+
+// acceptedVersions 返回分帧方式能够使用的全部版本头，穿过认证和同步标记等外层包装
+func acceptedVersions(codec serialcomm.FrameCodec) []int {
+	switch c := codec.(type) {
+	case serialcomm.VersionedCodec:
+		var versions []int
+		for v := range c.Versions {
+			versions = append(versions, int(v))
+		}
+		slices.Sort(versions)
+		return versions
+	case serialcomm.HMACCodec:
+		return acceptedVersions(c.Inner)
+	case serialcomm.SyncCodec:
+		return acceptedVersions(c.Inner)
+	}
+	return nil
+}
+
+// handshake 发送本端能力并等待对端回复，返回协商结果和按policy做出的降级；
+// 旧版本接收端会把握手帧当作未知控制帧跳过，此时在timeout后返回错误；
+// 配置了链路密钥时握手帧加密发送，对端拒绝明文的控制帧
+func handshake(port *serial.Port, local capabilities, key serialcomm.KeyProvider, timeout time.Duration, policy negotiationPolicy) (capabilities, []downgrade, error) {
+	body, err := json.Marshal(local)
+	if err != nil {
+		return capabilities{}, nil, fmt.Errorf("序列化本端能力失败: %v", err)
+	}
+	frame := append([]byte{helloControlType}, body...)
+	if key != nil {
+		frame, err = encryptFrame(frame, key)
+		if err != nil {
+			return capabilities{}, nil, err
+		}
+	}
+	port.Flush()
+	err = sendData(port, frame)
+	if err != nil {
+		return capabilities{}, nil, err
+	}
+
+	line, err := readReplyLine(port, helloReplyPrefix, timeout)
+	if err != nil {
+		return capabilities{}, nil, fmt.Errorf("%w，对端可能不支持握手", err)
+	}
+	var peer capabilities
+	err = json.Unmarshal(line, &peer)
+	if err != nil {
+		return capabilities{}, nil, serialcomm.ProtocolError("握手回复无效: %v", err)
+	}
+	if policy.OnPeerIdentified != nil {
+		if err := policy.OnPeerIdentified(peer.Identity); err != nil {
+			return capabilities{}, nil, err
+		}
+	}
+	agreed, downgrades, err := negotiate(local, peer, policy)
+	agreed.Identity = peer.Identity
+	return agreed, downgrades, err
+}
+
+// readReplyLine 读取以prefix开头的一行回复并去掉前缀，前缀之前的残留反馈被忽略
+func readReplyLine(port *serial.Port, prefix string, timeout time.Duration) ([]byte, error) {
+	var received []byte
+	buf := make([]byte, 256)
+	start := sysClock.Now()
+
+	for sysClock.Since(start) < timeout {
+		n, err := port.Read(buf)
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return nil, serialcomm.PortError("读取回复失败", err)
+		}
+		received = append(received, buf[:n]...)
+		if i := bytes.Index(received, []byte(prefix)); i >= 0 {
+			line := received[i+len(prefix):]
+			if end := bytes.IndexByte(line, '\n'); end >= 0 {
+				return line[:end], nil
+			}
+		}
+		if len(received) > 64*1024 {
+			return nil, serialcomm.ProtocolError("回复过长")
+		}
+		sysClock.Sleep(10 * time.Millisecond) // 防止CPU过载
+	}
+	return nil, fmt.Errorf("%w (%v)", errFeedbackTimeout, timeout)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiateDowngrade(t *testing.T) {
+	local := capabilities{Versions: []int{3}, MaxFrame: 1000, Dicts: []int{2}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}}
+	tests := []struct {
+		name    string
+		peer    capabilities
+		policy  negotiationPolicy
+		want    []downgrade
+		wantErr bool
+	}{
+		{
+			name: "对端全部支持",
+			peer: capabilities{Versions: []int{1, 3}, MaxFrame: 500, Dicts: []int{0, 2}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}},
+		},
+		{
+			name:    "不允许降级时报错",
+			peer:    capabilities{Versions: []int{3}, Dicts: []int{0}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}},
+			wantErr: true,
+		},
+		{
+			name:   "关闭压缩和键名缩短",
+			peer:   capabilities{Versions: []int{3}, Dicts: []int{0}, CRC: []string{crcAlgorithm}},
+			policy: negotiationPolicy{AllowDowngrade: true},
+			want: []downgrade{
+				{Feature: featureCompression, From: "2", To: "off", Reason: "对端不支持压缩字典 2 (对端支持 [0])"},
+				{Feature: featureKeyMap, From: "1", To: "off", Reason: "对端不支持键名映射表版本 1 (对端支持 [])"},
+			},
+		},
+		{
+			name:   "改用双方都支持的最高帧版本",
+			peer:   capabilities{Versions: []int{1, 2}, Dicts: []int{2}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}},
+			policy: negotiationPolicy{AllowDowngrade: true, Versions: []int{1, 2, 3}},
+			want:   []downgrade{{Feature: featureVersion, From: "3", To: "2", Reason: "对端不支持帧版本 3 (对端支持 [1 2])"}},
+		},
+		{
+			name:    "没有共同的帧版本时仍报错",
+			peer:    capabilities{Versions: []int{4}, Dicts: []int{2}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}},
+			policy:  negotiationPolicy{AllowDowngrade: true, Versions: []int{1, 3}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		agreed, downgrades, err := negotiate(local, tc.peer, tc.policy)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: 错误 %v，期望出错 %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(downgrades, tc.want) {
+			t.Errorf("%s: 降级 %+v，期望 %+v", tc.name, downgrades, tc.want)
+		}
+		for _, d := range downgrades {
+			if d.Feature == featureVersion && !reflect.DeepEqual(agreed.Versions, []int{2}) {
+				t.Errorf("%s: 协商的帧版本 %v，期望 [2]", tc.name, agreed.Versions)
+			}
+		}
+	}
+}
+
+func TestPeerAllowlist(t *testing.T) {
+	var allowlist []peerPattern
+	for _, spec := range []string{"model=TH-*,firmware=1.4.*", "serial=SN0001"} {
+		p, err := parsePeerPattern(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowlist = append(allowlist, p)
+	}
+	tests := []struct {
+		identity *peerIdentity
+		allowed  bool
+	}{
+		{&peerIdentity{Model: "TH-100", Firmware: "1.4.2"}, true},
+		{&peerIdentity{Model: "TH-100", Firmware: "1.3.9"}, false},
+		{&peerIdentity{Serial: "SN0001", Model: "XX", Firmware: "0.1"}, true},
+		{&peerIdentity{Firmware: "1.4.0"}, false},
+		{nil, false},
+	}
+	for _, tc := range tests {
+		err := checkAllowlist(allowlist, tc.identity)
+		if (err == nil) != tc.allowed {
+			t.Errorf("%+v: 错误 %v，期望允许 %v", tc.identity, err, tc.allowed)
+		}
+	}
+	if err := checkAllowlist(nil, nil); err != nil {
+		t.Errorf("允许列表为空时不应限制: %v", err)
+	}
+
+	for _, spec := range []string{"model", "vendor=x", "model=[", "model="} {
+		if _, err := parsePeerPattern(spec); err == nil {
+			t.Errorf("无效的 -allow-peer %q 未被拒绝", spec)
+		}
+	}
+}
+
+func TestFirmwareGates(t *testing.T) {
+	gate, err := parseFirmwareGate("compression>=1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := capabilities{Dicts: []int{2}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}}
+	peer := capabilities{Dicts: []int{2}, KeyMaps: []int{1}, CRC: []string{crcAlgorithm}}
+	tests := []struct {
+		firmware string // 为空表示对端未通告身份
+		enabled  bool
+	}{
+		{"1.4", true},
+		{"1.4.0", true},
+		{"v1.10", true},
+		{"2", true},
+		{"1.3.9", false},
+		{"1.4-beta", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		peer.Identity = nil
+		if tc.firmware != "" {
+			peer.Identity = &peerIdentity{Firmware: tc.firmware}
+		}
+		agreed, downgrades, err := negotiate(local, peer, negotiationPolicy{Gates: []firmwareGate{gate}})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.firmware, err)
+		}
+		if enabled := len(agreed.Dicts) > 0; enabled != tc.enabled {
+			t.Errorf("固件 %q: 压缩开启 %v，期望 %v", tc.firmware, enabled, tc.enabled)
+		}
+		if !tc.enabled && (len(downgrades) != 1 || downgrades[0].Feature != featureCompression) {
+			t.Errorf("固件 %q: 降级 %+v，期望关闭压缩", tc.firmware, downgrades)
+		}
+		if !reflect.DeepEqual(agreed.KeyMaps, []int{1}) {
+			t.Errorf("固件 %q: 未设要求的键名缩短被关闭", tc.firmware)
+		}
+	}
+
+	for _, spec := range []string{"compression", "encryption>=1.0", "keymap>=1.x", "keymap>="} {
+		if _, err := parseFirmwareGate(spec); err == nil {
+			t.Errorf("无效的 -require-firmware %q 未被拒绝", spec)
+		}
+	}
+}
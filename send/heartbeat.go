@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// heartbeat 在链路空闲时定期发送保活帧，使接收端能区分对端失联和暂时没有数据；
+// 与其他写操作共用锁，避免保活帧插入到正在分块发送的帧中间
+type heartbeat struct {
+	Interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time // 最后一次写串口的时间
+}
+
+// do 在持有写锁时执行fn，并把本次视为一次发送
+func (h *heartbeat) do(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn()
+	h.last = sysClock.Now()
+}
+
+// run 每隔Interval检查一次，距上次发送超过Interval时发送保活帧，直到stop关闭
+func (h *heartbeat) run(port *serial.Port, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		sysClock.Sleep(h.Interval)
+
+		h.mu.Lock()
+		if sysClock.Since(h.last) >= h.Interval {
+			err := sendKeepAlive(port)
+			if err != nil {
+				log.Printf("发送心跳失败: %v", err)
+			}
+			h.last = sysClock.Now()
+		}
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+)
+
+// keyMapFrameMarker 键名缩短帧的首字节，其后一个字节为映射表版本
+const keyMapFrameMarker = 0x02
+
+// inlinePayloadKey 缩短后的消息中，内嵌（非base64）payload JSON使用的键
+const inlinePayloadKey = "P"
+
+// keyMapTables 按版本划分的键名映射表（长键名 -> 短键名），已发布的版本不能修改，只能新增版本
+var keyMapTables = map[byte]map[string]string{
+	1: {
+		// 消息信封
+		"apiVersion":    "a",
+		"receivedTopic": "t",
+		"correlationID": "c",
+		"requestID":     "r",
+		"errorCode":     "e",
+		"payload":       "p",
+		"contentType":   "ct",
+		"signature":     "sg",
+		"certificate":   "cr",
+		"sequence":      "sq",
+		// EdgeX事件与读数
+		"requestId":    "ri",
+		"event":        "ev",
+		"id":           "i",
+		"deviceName":   "d",
+		"profileName":  "pn",
+		"sourceName":   "sn",
+		"origin":       "o",
+		"readings":     "rd",
+		"resourceName": "rn",
+		"valueType":    "vt",
+		"value":        "v",
+	},
+}
+
+// renameKeys 按映射表重命名JSON中所有对象的键，保持键的顺序和其他内容不变，输出为紧凑格式
+func renameKeys(data []byte, table map[string]string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	type container struct {
+		object    bool // 是否为对象（否则为数组）
+		expectKey bool // 对象中下一个记号是否为键
+		first     bool // 是否尚未写入任何成员
+	}
+	var stack []container
+	var buf bytes.Buffer
+
+	// valueDone 在一个完整的值写入后，让所在对象转而期待下一个键
+	valueDone := func() {
+		if n := len(stack); n > 0 && stack[n-1].object {
+			stack[n-1].expectKey = true
+		}
+	}
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			buf.WriteByte(byte(d))
+			stack = stack[:len(stack)-1]
+			valueDone()
+			continue
+		}
+
+		isKey := false
+		if n := len(stack); n > 0 {
+			top := &stack[n-1]
+			isKey = top.object && top.expectKey
+			if top.object && !top.expectKey {
+				buf.WriteByte(':')
+			} else if !top.first {
+				buf.WriteByte(',')
+			}
+			top.first = false
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			buf.WriteByte(byte(d))
+			stack = append(stack, container{object: d == '{', expectKey: true, first: true})
+			continue
+		}
+
+		if s, ok := tok.(string); ok && isKey {
+			if renamed, ok := table[s]; ok {
+				tok = renamed
+			}
+		}
+		encoded, err := json.Marshal(tok)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+		if isKey {
+			stack[len(stack)-1].expectKey = false
+		} else {
+			valueDone()
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// shortenMessage 用指定版本的映射表编码消息：标记字节 | 表版本 | 短键名JSON。
+// payload为JSON时以内嵌对象代替base64，键名同样缩短，在低波特率链路上收益明显。
+// 签名和PayloadCRC针对原始字节，重新序列化可能改变空白、转义或与短键名同名的键，
+// 因此只有接收端按同样方式还原后逐字节一致时才内嵌，否则payload保持base64原样；
+// 信封本身也无法无损还原时返回错误
+func shortenMessage(message Message, version byte) ([]byte, error) {
+	table, ok := keyMapTables[version]
+	if !ok {
+		return nil, fmt.Errorf("未知的键名映射表版本 %d", version)
+	}
+	original, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadData, err := base64.StdEncoding.DecodeString(message.Payload)
+	if err == nil && json.Valid(payloadData) {
+		inline, err := renameKeys(payloadData, table)
+		if err != nil {
+			return nil, err
+		}
+		inlined := message
+		extra := make(map[string]json.RawMessage, len(message.Extra)+1)
+		for k, v := range message.Extra {
+			extra[k] = v
+		}
+		extra[inlinePayloadKey] = inline
+		inlined.Extra = extra
+		inlined.Payload = ""
+
+		short, err := shortenEnvelope(inlined, table)
+		if err != nil {
+			return nil, err
+		}
+		if expandsTo(short, table, original) {
+			return append([]byte{keyMapFrameMarker, version}, short...), nil
+		}
+		log.Printf("payload内嵌后无法逐字节还原，保持base64")
+	}
+
+	short, err := shortenEnvelope(message, table)
+	if err != nil {
+		return nil, err
+	}
+	if !expandsTo(short, table, original) {
+		return nil, fmt.Errorf("消息中有与短键名冲突的键，无法无损缩短")
+	}
+	return append([]byte{keyMapFrameMarker, version}, short...), nil
+}
+
+// shortenEnvelope 序列化消息并缩短所有键名
+func shortenEnvelope(message Message, table map[string]string) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return renameKeys(data, table)
+}
+
+// expandsTo 按接收端expandFrame的步骤还原缩短后的JSON，检查结果与original逐字节一致
+func expandsTo(short []byte, table map[string]string, original []byte) bool {
+	reverse := make(map[string]string, len(table))
+	for long, short := range table {
+		reverse[short] = long
+	}
+	expanded, err := renameKeys(short, reverse)
+	if err != nil {
+		return false
+	}
+	var message Message
+	if json.Unmarshal(expanded, &message) != nil {
+		return false
+	}
+	if inline, ok := message.Extra[inlinePayloadKey]; ok {
+		payload, err := renameKeys(inline, reverse)
+		if err != nil {
+			return false
+		}
+		message.Payload = base64.StdEncoding.EncodeToString(payload)
+		delete(message.Extra, inlinePayloadKey)
+	}
+	restored, err := json.Marshal(message)
+	return err == nil && bytes.Equal(restored, original)
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setModemLines 通过另开一个文件描述符设置串口的DTR/RTS电平（nil表示不改动）
+func setModemLines(name string, dtr, rts *bool) error {
+	if dtr == nil && rts == nil {
+		return nil
+	}
+	f, err := os.OpenFile(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return fmt.Errorf("打开串口 %s 设置控制线失败: %v", name, err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	if dtr != nil {
+		if *dtr {
+			err = unix.IoctlSetPointerInt(fd, unix.TIOCMBIS, unix.TIOCM_DTR)
+		} else {
+			err = unix.IoctlSetPointerInt(fd, unix.TIOCMBIC, unix.TIOCM_DTR)
+		}
+		if err != nil {
+			return fmt.Errorf("设置DTR失败: %v", err)
+		}
+	}
+	if rts != nil {
+		if *rts {
+			err = unix.IoctlSetPointerInt(fd, unix.TIOCMBIS, unix.TIOCM_RTS)
+		} else {
+			err = unix.IoctlSetPointerInt(fd, unix.TIOCMBIC, unix.TIOCM_RTS)
+		}
+		if err != nil {
+			return fmt.Errorf("设置RTS失败: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// setModemLines 当前平台的串口库未暴露控制线接口，仅在需要设置时返回错误
+func setModemLines(name string, dtr, rts *bool) error {
+	if dtr == nil && rts == nil {
+		return nil
+	}
+	return fmt.Errorf("当前平台不支持设置串口 %s 的DTR/RTS", name)
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// sendOptions 发送端可在命令行设置的参数，默认值即未配置时的行为
+type sendOptions struct {
+	Port          string
+	Baud          int
+	SettleDelay   time.Duration
+	DiscardWindow time.Duration
+	DTR           lineFlag
+	RTS           lineFlag
+	Framing       serialcomm.Framing // 分帧方式，须与接收端一致
+
+	// 对端使用的反馈字符串，可重复；未指定的类别使用 OK/RETRY/AUTH，旧固件可能回复 "ACK"/"NAK" 等
+	OKTokens    stringList
+	RetryTokens stringList
+	AuthTokens  stringList
+
+	SequenceFile string
+	EndToEndCRC  bool
+	FilterRules  stringList // 按资源的死区和最小间隔，如 Int8=2/1m
+	FilterState  string     // 各资源上次发送的值，跨进程保存
+	LinkNoAck    bool
+	Ack          string // 本条消息的确认要求：required、none，为空时沿用链路默认值
+	SignKey      string
+	SignCert     string
+
+	Canonical            bool
+	RawEnvelope          string
+	KeyMapVersion        uint
+	CompressionThreshold int
+	Dict                 string
+	MACKey               string
+	LinkKey              string
+
+	WindowPeriod  time.Duration
+	WindowOffset  time.Duration
+	WindowLength  time.Duration
+	Emergency     bool
+	BusTurnaround time.Duration
+	BusIdle       time.Duration
+	WakeGPIO      int // 唤醒脚的sysfs GPIO编号，-1为不唤醒
+	ReadyGPIO     int // 就绪脚的sysfs GPIO编号，-1为脉冲后等待WakeDelay
+	WakePulse     time.Duration
+	WakeDelay     time.Duration
+	ReadyTimeout  time.Duration
+
+	MaxFrameLength int
+	LatencyBudget  time.Duration
+	Standby        string
+	AckWindow      int           // 流模式下最多在途的帧数，0为逐帧停等确认
+	Coalesce       time.Duration // 滑动窗口模式下合并写出的等待时间，0为逐帧写出
+
+	AuditFile  string
+	PeerState  string
+	Broadcast  string
+	RouteTable string
+	Routes     string // 带标签的串口和路由规则，按消息的设备名选择串口
+	Handshake  bool
+	Downgrade  bool       // 握手时关闭对端不支持的特性而不是报错
+	AllowPeers stringList // 握手时只接受身份符合其中一项的对端
+	Gates      stringList // 特性对对端固件版本的要求，如 compression>=1.4
+	Heartbeat  time.Duration
+	OrderBy    string // 流模式的排序域：topic、device，为空时所有消息只按优先级排序
+
+	PairingCode   string
+	ProvisionKeys stringList
+	ProvisionCA   string
+}
+
+// registerFlags 注册发送参数的命令行选项
+func (o *sendOptions) registerFlags() {
+	flag.StringVar(&o.Port, "port", "COM6", "串口名称")
+	flag.IntVar(&o.Baud, "baud", 115200, "波特率")
+	o.Framing.RegisterFlags(flag.CommandLine)
+	flag.DurationVar(&o.SettleDelay, "settle", 2*time.Second, "打开串口后等待对端稳定的时间，Arduino类开发板复位约需1~2秒")
+	flag.DurationVar(&o.DiscardWindow, "discard-window", 200*time.Millisecond, "首次发送前丢弃对端启动输出的时间窗口")
+	flag.Var(&o.DTR, "dtr", "打开后设置DTR电平 on|off，不设置时保持驱动默认（置为off可避免Arduino复位）")
+	flag.Var(&o.RTS, "rts", "打开后设置RTS电平 on|off，不设置时保持驱动默认")
+
+	flag.StringVar(&o.SequenceFile, "sequence-file", "", "序号文件，设置后为消息编号，接收端据此发现丢帧")
+	flag.BoolVar(&o.EndToEndCRC, "e2e-crc", false, "在源头计算payload的CRC32，由最终接收端校验")
+	flag.Var(&o.FilterRules, "filter", "资源的发送过滤规则 资源名=死区[/最小间隔]，如 Int8=2/1m，可重复；未超出死区或间隔过短的读数不发送")
+	flag.StringVar(&o.FilterState, "filter-state", "", "保存各资源上次发送的值的文件，使用 -filter 时必须指定")
+	flag.BoolVar(&o.LinkNoAck, "no-ack", false, "链路默认不等待确认（遥测链路）")
+	flag.StringVar(&o.Ack, "ack", "", "本条消息的确认要求: required|none，不设置时沿用链路默认值")
+	flag.StringVar(&o.SignKey, "sign-key", "", "签名私钥（PKCS#8 PEM格式的Ed25519私钥），如 file:device.key、env:SERIALJSON_SIGN_KEY")
+	flag.StringVar(&o.SignCert, "sign-cert", "", "随签名发送的设备证书，供接收端用CA校验")
+
+	flag.BoolVar(&o.Canonical, "canonical", false, "以规范JSON序列化，键按字典序排列，便于签名和逐字节比对")
+	flag.StringVar(&o.RawEnvelope, "raw-envelope", "", "直接发送该文件中已序列化的消息信封，不解码再编码")
+	flag.UintVar(&o.KeyMapVersion, "keymap", 0, "按该版本的映射表缩短键名，0为不缩短（接收端需有相同版本的映射表）")
+	flag.IntVar(&o.CompressionThreshold, "compress", 0, "帧体达到该字节数时用共享字典压缩，0为不压缩")
+	flag.StringVar(&o.Dict, "dict", "", "用 -train-dict 训练的压缩字典（编号2），不设置时使用内置字典")
+	flag.StringVar(&o.MACKey, "mac-key", "", "帧认证的预共享密钥（十六进制），如 env:SERIALJSON_MAC_KEY，须与接收端一致")
+	flag.StringVar(&o.LinkKey, "link-key", "", "链路加密的预共享密钥（十六进制AES密钥），如 env:SERIALJSON_LINK_KEY，须与接收端一致")
+
+	flag.DurationVar(&o.WindowPeriod, "window-period", 0, "共享总线的时隙周期，0为不限制发送时间")
+	flag.DurationVar(&o.WindowOffset, "window-offset", 0, "本端时隙在周期内的起点")
+	flag.DurationVar(&o.WindowLength, "window-length", 0, "本端时隙的长度")
+	flag.BoolVar(&o.Emergency, "emergency", false, "紧急消息，不受发送时隙限制")
+	flag.DurationVar(&o.BusTurnaround, "bus-turnaround", 0, "RS-485半双工发送前的收发切换时间，0为全双工链路")
+	flag.DurationVar(&o.BusIdle, "bus-idle", 20*time.Millisecond, "RS-485半双工发送前要求总线空闲的时间")
+	flag.IntVar(&o.WakeGPIO, "wake-gpio", -1, "每次发送前在该GPIO（sysfs编号，须已导出为输出）输出唤醒脉冲，用于电池供电的对端，-1为不唤醒")
+	flag.IntVar(&o.ReadyGPIO, "ready-gpio", -1, "唤醒后等待该GPIO（sysfs编号，须已导出为输入）变高再发送，-1为脉冲后固定等待 -wake-delay")
+	flag.DurationVar(&o.WakePulse, "wake-pulse", 10*time.Millisecond, "唤醒脉冲宽度")
+	flag.DurationVar(&o.WakeDelay, "wake-delay", 50*time.Millisecond, "没有就绪脚时唤醒脉冲后的等待时间")
+	flag.DurationVar(&o.ReadyTimeout, "ready-timeout", time.Second, "等待就绪脚变高的超时时间")
+
+	flag.IntVar(&o.MaxFrameLength, "max-frame", 10000, "帧体超过该长度时分片发送，须不超过接收端的最大长度")
+	flag.DurationVar(&o.LatencyBudget, "latency-budget", 0, "消息从首次发送到确认的时间预算，过期即放弃，0为不限")
+	flag.StringVar(&o.Standby, "standby", "", "冷备串口，主串口连续传输失败时切换")
+	flag.IntVar(&o.AckWindow, "ack-window", 0, "流模式下最多在途的帧数，接收端按序号累积确认，0为逐帧停等确认")
+	flag.DurationVar(&o.Coalesce, "coalesce", 0, "滑动窗口模式下把该时间内排队的帧合并为一次串口写入（各帧保持各自的分帧），0为逐帧写出")
+
+	flag.StringVar(&o.AuditFile, "audit-file", "", "出站命令审计文件")
+	flag.StringVar(&o.PeerState, "peer-state", "", "对端状态文件，设置后连续发送失败的对端被隔离")
+	flag.StringVar(&o.Broadcast, "broadcast", "", "并发发送到这些串口（逗号分隔），如 COM6,COM8")
+	flag.StringVar(&o.Routes, "routes", "", "路由配置文件（JSON），为串口加标签并按设备名规则选择串口，如设备 Boiler-* 发往标签 rack=rack3 的串口")
+	flag.StringVar(&o.RouteTable, "route-table", "", "接收端学习到的设备-串口路由表，按消息的设备名选择串口")
+	flag.BoolVar(&o.Handshake, "handshake", false, "发送前与接收端交换协议能力（旧版本接收端不支持）")
+	flag.Var(&o.AllowPeers, "allow-peer", "只与身份符合的对端通信，如 model=TH-*,firmware=1.4.*（键为serial、model、firmware，值支持 * ? 通配），可重复，需要 -handshake")
+	flag.Var(&o.Gates, "require-firmware", "特性要求的最低对端固件版本，如 compression>=1.4（特性为compression或keymap），对端固件较低或未通告版本时自动关闭该特性，可重复，需要 -handshake")
+	flag.BoolVar(&o.Downgrade, "allow-downgrade", false, "握手时对端不支持本端的压缩字典、键名映射表或帧版本时降级继续发送，并输出结构化的降级警告；默认报错退出")
+	flag.DurationVar(&o.Heartbeat, "heartbeat", 0, "交互模式下空闲时发送心跳的间隔，0为不发送")
+	flag.StringVar(&o.OrderBy, "order-by", "", "流模式下同一主题(topic)或设备(device)的消息严格按到达顺序发送，只在不同主题或设备之间按优先级插队")
+
+	flag.Var(&o.OKTokens, "ok-token", "对端表示确认的反馈字符串，可重复，默认 OK")
+	flag.Var(&o.RetryTokens, "retry-token", "对端请求重传的反馈字符串，可重复，默认 RETRY")
+	flag.Var(&o.AuthTokens, "auth-token", "对端表示认证失败（不必重传）的反馈字符串，可重复，默认 AUTH")
+
+	flag.StringVar(&o.PairingCode, "pairing-code", "", "配对码，设置后先向出厂设备下发密钥（接收端需同时开启配置模式）")
+	flag.Var(&o.ProvisionKeys, "provision-key", "下发给接收端信任的公钥文件，通常为本机签名公钥，可重复")
+	flag.StringVar(&o.ProvisionCA, "provision-ca", "", "下发给接收端的CA证书文件")
+}
+
+// validate 检查参数取值和组合，返回的错误逐条列出每个无效参数及修正方法
+func (o *sendOptions) validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if o.Port == "" {
+		invalid("-port 为空：请指定串口名称，如 COM6 或 /dev/ttyUSB0")
+	}
+	if o.Baud <= 0 {
+		invalid("-baud %d 无效：应为正数，如 9600 或 115200", o.Baud)
+	}
+	if o.SettleDelay < 0 || o.DiscardWindow < 0 {
+		invalid("-settle/-discard-window 不能为负数：不需要等待时设为0")
+	}
+	if err := o.Framing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if o.Standby != "" && o.Standby == o.Port {
+		invalid("-standby 与 -port 相同：冷备串口应为另一个串口")
+	}
+
+	seen := map[string]string{}
+	for _, list := range []struct {
+		name   string
+		tokens stringList
+	}{{"-ok-token", o.OKTokens}, {"-retry-token", o.RetryTokens}, {"-auth-token", o.AuthTokens}} {
+		for _, token := range list.tokens {
+			switch {
+			case token == "":
+				invalid("%s 为空：须为对端回复的字符串", list.name)
+			case seen[token] != "" && seen[token] != list.name:
+				invalid("%s %q 已用于 %s：同一字符串无法区分确认、重传和认证失败", list.name, token, seen[token])
+			case o.AckWindow > 0 && serialcomm.ShadowsWindowAck(token):
+				invalid("%s %q 是滑动窗口累积确认（如 %s）的前缀，不能与 -ack-window 同时使用", list.name, token, serialcomm.WindowAck(12))
+			}
+			seen[token] = list.name
+		}
+	}
+
+	if _, err := o.messageAck(); err != nil {
+		invalid("%v", err)
+	}
+	if _, err := o.telemetryFilter(); err != nil {
+		invalid("-filter %v", err)
+	}
+	switch {
+	case len(o.FilterRules) > 0 && o.FilterState == "":
+		invalid("-filter 需要 -filter-state：上次发送的值须保存在文件中，每次发送才能与之比较")
+	case len(o.FilterRules) == 0 && o.FilterState != "":
+		invalid("-filter-state 需要 -filter：没有过滤规则时不记录状态")
+	case len(o.FilterRules) > 0 && o.RawEnvelope != "":
+		invalid("-filter 不能与 -raw-envelope 同时使用：原始信封按原样透传，不过滤读数")
+	}
+	for _, key := range []struct{ name, spec string }{
+		{"-sign-key", o.SignKey}, {"-sign-cert", o.SignCert}, {"-mac-key", o.MACKey}, {"-link-key", o.LinkKey},
+	} {
+		if _, err := serialcomm.ParseKey(key.spec, false); err != nil {
+			invalid("%s: %v", key.name, err)
+		}
+	}
+	if o.SignKey == "" && o.SignCert != "" {
+		invalid("-sign-cert 需要 -sign-key：证书随签名发送，未签名时无意义")
+	}
+
+	if o.KeyMapVersion != 0 {
+		if _, ok := keyMapTables[byte(o.KeyMapVersion)]; !ok || o.KeyMapVersion > 0xFF {
+			invalid("-keymap %d 不是已发布的映射表版本：可用版本为 %v，0为不缩短", o.KeyMapVersion, keyMapVersions())
+		}
+		if o.RawEnvelope != "" {
+			invalid("-keymap 不能与 -raw-envelope 同时使用：原始信封按原样透传，不缩短键名")
+		}
+	}
+	if o.CompressionThreshold < 0 {
+		invalid("-compress %d 无效：应为帧体字节数阈值，如 128，0为不压缩", o.CompressionThreshold)
+	}
+	if o.Dict != "" && o.CompressionThreshold == 0 {
+		invalid("-dict 需要 -compress：未开启压缩时字典不会被使用")
+	}
+	if o.MaxFrameLength < 0 {
+		invalid("-max-frame %d 无效：应为接收端的最大帧长，0为只按分帧方式的上限分片", o.MaxFrameLength)
+	}
+
+	switch {
+	case o.WindowPeriod < 0 || o.WindowOffset < 0 || o.WindowLength < 0:
+		invalid("-window-period/-window-offset/-window-length 不能为负数")
+	case o.WindowPeriod == 0 && (o.WindowOffset > 0 || o.WindowLength > 0):
+		invalid("-window-offset/-window-length 需要 -window-period：请同时指定时隙周期")
+	case o.WindowPeriod > 0 && o.WindowLength == 0:
+		invalid("-window-length 为0：开启时隙后须指定本端时隙长度，如 100ms")
+	case o.WindowPeriod > 0 && o.WindowOffset+o.WindowLength > o.WindowPeriod:
+		invalid("时隙 %v+%v 超出周期 %v：-window-offset 与 -window-length 之和不能超过 -window-period", o.WindowOffset, o.WindowLength, o.WindowPeriod)
+	}
+	if o.BusTurnaround < 0 {
+		invalid("-bus-turnaround 不能为负数：全双工链路设为0")
+	}
+	if o.BusTurnaround > 0 && o.BusIdle <= 0 {
+		invalid("-bus-idle 应为正数：半双工发送前须确认总线空闲，如 20ms")
+	}
+	switch {
+	case o.WakeGPIO < -1 || o.ReadyGPIO < -1:
+		invalid("-wake-gpio/-ready-gpio 无效：应为sysfs GPIO编号，-1为不使用")
+	case o.ReadyGPIO >= 0 && o.WakeGPIO < 0:
+		invalid("-ready-gpio 需要 -wake-gpio：就绪脚只在唤醒脉冲之后检查")
+	case o.WakeGPIO >= 0 && o.ReadyGPIO == o.WakeGPIO:
+		invalid("-ready-gpio 与 -wake-gpio 相同：唤醒脚和就绪脚应为不同的引脚")
+	case o.WakeGPIO >= 0 && (o.WakePulse <= 0 || o.WakeDelay < 0 || o.ReadyTimeout <= 0):
+		invalid("-wake-pulse/-ready-timeout 应为正数，-wake-delay 不能为负数")
+	}
+	switch {
+	case o.AckWindow < 0 || o.AckWindow > maxAckWindow:
+		invalid("-ack-window %d 无效：应在0到%d之间，0为逐帧停等确认", o.AckWindow, maxAckWindow)
+	case o.AckWindow > 0 && o.BusTurnaround > 0:
+		invalid("-ack-window 不能与 -bus-turnaround 同时使用：半双工总线上须逐帧等待应答")
+	}
+	switch {
+	case o.Coalesce < 0:
+		invalid("-coalesce 不能为负数：逐帧写出时设为0")
+	case o.Coalesce > 0 && o.AckWindow == 0:
+		invalid("-coalesce 需要 -ack-window：逐帧停等确认时没有可合并的帧")
+	}
+	if o.LatencyBudget < 0 || o.Heartbeat < 0 {
+		invalid("-latency-budget/-heartbeat 不能为负数：不限制或不发送时设为0")
+	}
+
+	if o.Downgrade && !o.Handshake {
+		invalid("-allow-downgrade 需要 -handshake：只有握手才能得知对端不支持哪些特性")
+	}
+	if len(o.AllowPeers) > 0 && !o.Handshake {
+		invalid("-allow-peer 需要 -handshake：对端在握手时通告身份")
+	}
+	if _, err := o.peerAllowlist(); err != nil {
+		invalid("-allow-peer %v", err)
+	}
+	if len(o.Gates) > 0 && !o.Handshake {
+		invalid("-require-firmware 需要 -handshake：对端在握手时通告固件版本")
+	}
+	if _, err := o.firmwareGates(); err != nil {
+		invalid("-require-firmware %v", err)
+	}
+	if o.OrderBy != "" && o.OrderBy != "topic" && o.OrderBy != "device" {
+		invalid("-order-by %q 无效：应为 topic 或 device，为空时只按优先级排序", o.OrderBy)
+	}
+
+	if o.PairingCode != "" && len(o.ProvisionKeys) == 0 && o.ProvisionCA == "" {
+		invalid("-pairing-code 需要 -provision-key 或 -provision-ca：没有可下发的内容")
+	}
+	if o.PairingCode == "" && (len(o.ProvisionKeys) > 0 || o.ProvisionCA != "") {
+		invalid("-provision-key/-provision-ca 需要 -pairing-code：出厂设备只接受带配对码的下发")
+	}
+	if o.Routes != "" {
+		if _, _, err := loadRoutes(o.Routes); err != nil {
+			invalid("-routes: %v", err)
+		}
+	}
+	for _, path := range append([]string{o.RawEnvelope, o.Dict, o.ProvisionCA}, o.ProvisionKeys...) {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			invalid("文件 %s 不可用: %v", path, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateModes 检查运行模式：modes为各模式选项是否指定，只能指定一个；
+// 同时检查模板、OTA镜像和对端配置等模式参数，返回逐条列出问题的错误
+func validateModes(modes map[string]bool, templatePath string, vars setFlags, otaImage string, configSet setFlags) error {
+	var errs []error
+	var selected []string
+	for name, on := range modes {
+		if on {
+			selected = append(selected, name)
+		}
+	}
+	sort.Strings(selected)
+	if len(selected) > 1 {
+		errs = append(errs, fmt.Errorf("%s 不能同时使用：每次只能运行一种模式", strings.Join(selected, "、")))
+	}
+
+	if templatePath == "" && len(vars) > 0 {
+		errs = append(errs, fmt.Errorf("-set 需要 -template：模板参数只用于渲染模板"))
+	}
+	if templatePath != "" {
+		if _, err := os.Stat(templatePath); err != nil {
+			errs = append(errs, fmt.Errorf("-template 文件不可用: %v", err))
+		}
+	}
+	for key := range vars {
+		if strings.ContainsAny(key, " .{}") {
+			errs = append(errs, fmt.Errorf("-set %s 无效：参数名不能含空格、点或花括号，模板中按 {{.%s}} 引用", key, key))
+		}
+	}
+	if otaImage != "" {
+		info, err := os.Stat(otaImage)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("-ota 镜像不可用: %v", err))
+		case info.IsDir() || info.Size() == 0:
+			errs = append(errs, fmt.Errorf("-ota %s 不是非空的固件镜像文件", otaImage))
+		}
+	}
+	for key, value := range configSet {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("-config-set %s= 缺少值：字符串值可直接写，其他类型写JSON，如 %s=9600", key, key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keyMapVersions 返回已发布的映射表版本
+func keyMapVersions() []int {
+	var versions []int
+	for version := range keyMapTables {
+		versions = append(versions, int(version))
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// broadcastPorts 返回 -broadcast 指定的串口
+func (o *sendOptions) broadcastPorts() []string {
+	var ports []string
+	for _, name := range strings.Split(o.Broadcast, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ports = append(ports, name)
+		}
+	}
+	return ports
+}
+
+// orderingDomain 按 -order-by 返回消息所属的排序域，无法确定时返回空串（不受顺序约束）
+func (o *sendOptions) orderingDomain(message Message) string {
+	switch o.OrderBy {
+	case "topic":
+		return message.ReceivedTopic
+	case "device":
+		device, err := messageDevice(message)
+		if err != nil {
+			log.Printf("无法确定消息 %s 的设备名，不限制其顺序: %v", message.CorrelationID, err)
+			return ""
+		}
+		return device
+	}
+	return ""
+}
+
+// messageAck 解析 -ack
+func (o *sendOptions) messageAck() (ackMode, error) {
+	switch o.Ack {
+	case "":
+		return ackDefault, nil
+	case "required":
+		return ackRequired, nil
+	case "none":
+		return ackNone, nil
+	}
+	return ackDefault, fmt.Errorf("-ack 应为 required 或 none，而不是 %q", o.Ack)
+}
+
+// wakeHook 按 -wake-gpio 和 -ready-gpio 返回发送前的唤醒钩子，未指定唤醒脚时返回nil
+func (o *sendOptions) wakeHook() preSendHook {
+	if o.WakeGPIO < 0 {
+		return nil
+	}
+	wake := gpioWake{
+		Wake:         newSysfsPin(o.WakeGPIO),
+		Pulse:        o.WakePulse,
+		ReadyDelay:   o.WakeDelay,
+		ReadyTimeout: o.ReadyTimeout,
+	}
+	if o.ReadyGPIO >= 0 {
+		wake.Ready = newSysfsPin(o.ReadyGPIO)
+	}
+	return wake.hook()
+}
+
+// peerAllowlist 解析 -allow-peer
+func (o *sendOptions) peerAllowlist() ([]peerPattern, error) {
+	var allowlist []peerPattern
+	for _, spec := range o.AllowPeers {
+		p, err := parsePeerPattern(spec)
+		if err != nil {
+			return nil, err
+		}
+		allowlist = append(allowlist, p)
+	}
+	return allowlist, nil
+}
+
+// firmwareGates 解析 -require-firmware
+func (o *sendOptions) firmwareGates() ([]firmwareGate, error) {
+	var gates []firmwareGate
+	for _, spec := range o.Gates {
+		gate, err := parseFirmwareGate(spec)
+		if err != nil {
+			return nil, err
+		}
+		gates = append(gates, gate)
+	}
+	return gates, nil
+}
+
+// telemetryFilter 按 -filter 和 -filter-state 返回读数过滤器，未指定规则时返回nil
+func (o *sendOptions) telemetryFilter() (*telemetryFilter, error) {
+	if len(o.FilterRules) == 0 {
+		return nil, nil
+	}
+	filter := &telemetryFilter{Rules: make(map[string]filterRule), StateFile: o.FilterState}
+	for _, spec := range o.FilterRules {
+		resource, rule, err := parseFilterRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := filter.Rules[resource]; dup {
+			return nil, fmt.Errorf("资源 %s 的规则重复", resource)
+		}
+		filter.Rules[resource] = rule
+	}
+	return filter, nil
+}
+
+// lineFlag 可选的控制线电平，未设置时为nil
+type lineFlag struct {
+	level *bool
+}
+
+func (f *lineFlag) String() string {
+	switch {
+	case f == nil || f.level == nil:
+		return ""
+	case *f.level:
+		return "on"
+	}
+	return "off"
+}
+
+func (f *lineFlag) Set(value string) error {
+	var level bool
+	switch value {
+	case "on":
+		level = true
+	case "off":
+	default:
+		return fmt.Errorf("电平应为 on 或 off，而不是 %q", value)
+	}
+	f.level = &level
+	return nil
+}
+
+// feedback 返回对端使用的反馈字符串，未指定的类别使用本协议的默认值
+func (o *sendOptions) feedback() feedbackTokens {
+	tokens := defaultFeedback
+	if len(o.OKTokens) > 0 {
+		tokens.OK = o.OKTokens
+	}
+	if len(o.RetryTokens) > 0 {
+		tokens.Retry = o.RetryTokens
+	}
+	if len(o.AuthTokens) > 0 {
+		tokens.AuthFail = o.AuthTokens
+	}
+	return tokens
+}
+
+// stringList 可重复的字符串选项
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
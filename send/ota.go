@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// 对端固件为OTA提供的配置项：接收完镜像后在otaHashKey中给出镜像的SHA-256（十六进制），
+// 提交otaSwapKey为true后切换到新镜像并重启，新固件启动后应答握手，并在otaRunningKey中给出正在运行的镜像的SHA-256
+const (
+	otaHashKey    = "ota.imageSHA256"
+	otaSwapKey    = "ota.swap"
+	otaRunningKey = "ota.runningSHA256"
+)
+
+// otaResult OTA升级的结构化结果，Stage为完成或失败时所处的阶段
+type otaResult struct {
+	Image         string        `json:"image"`
+	Size          int           `json:"size"`
+	SHA256        string        `json:"sha256"`
+	PeerSHA256    string        `json:"peerSHA256,omitempty"`
+	RunningSHA256 string        `json:"runningSHA256,omitempty"` // 重启后对端报告的正在运行的镜像
+	Stage         string        `json:"stage"`                   // transfer、verify、swap、reboot、confirm或done
+	Elapsed       time.Duration `json:"elapsed"`
+	Peer          *capabilities `json:"peer,omitempty"` // 新固件在握手中报告的能力
+	Error         string        `json:"error,omitempty"`
+}
+
+// otaUpdate 组合文件传输、配置帧和握手完成一次固件升级：
+// 以YMODEM推送镜像，读取对端计算的哈希并核对，提交切换命令，等待新固件应答握手，再核对新固件正在运行的镜像
+type otaUpdate struct {
+	Port          *serial.Port
+	Transfer      xmodemSender
+	Config        configClient
+	Hello         capabilities  // 握手时发送的本端能力
+	RebootTimeout time.Duration // 提交切换后等待新固件应答握手的时间
+	// Reopen 重新打开串口：USB转串口的对端重启后会重新枚举，旧的串口句柄失效；为nil时一直使用Port
+	Reopen func() (*serial.Port, error)
+}
+
+// run 执行升级，任一阶段失败时停止并在结果中记录阶段和错误
+func (u otaUpdate) run(image string, data []byte) (otaResult, error) {
+	start := sysClock.Now()
+	sum := sha256.Sum256(data)
+	result := otaResult{Image: image, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+	fail := func(stage string, err error) (otaResult, error) {
+		result.Stage = stage
+		result.Elapsed = sysClock.Since(start)
+		result.Error = err.Error()
+		return result, fmt.Errorf("OTA在%s阶段失败: %w", stage, err)
+	}
+
+	err := u.Transfer.sendFile(filepath.Base(image), data)
+	if err != nil {
+		return fail("transfer", err)
+	}
+
+	reply, err := u.Config.get()
+	if err != nil {
+		return fail("verify", err)
+	}
+	err = json.Unmarshal(reply.Values[otaHashKey], &result.PeerSHA256)
+	if err != nil {
+		return fail("verify", serialcomm.ProtocolError("对端未给出有效的镜像哈希 (%s): %v", otaHashKey, err))
+	}
+	if result.PeerSHA256 != result.SHA256 {
+		return fail("verify", serialcomm.ProtocolError("镜像哈希不一致: 本端 %s，对端 %s", result.SHA256, result.PeerSHA256))
+	}
+
+	_, err = u.Config.set(otaSwapKey, json.RawMessage("true"), reply.Version)
+	if err != nil {
+		return fail("swap", err)
+	}
+	// 对端可能在回复commit之前就已重启，回复超时不算失败，重启后核对正在运行的镜像
+	_, err = u.Config.commit(reply.Version)
+	if err != nil && !errors.Is(err, errFeedbackTimeout) {
+		return fail("swap", err)
+	}
+
+	peer, err := u.awaitReboot()
+	if err != nil {
+		return fail("reboot", err)
+	}
+	result.Peer = &peer
+
+	reply, err = u.Config.get()
+	if err != nil {
+		return fail("confirm", err)
+	}
+	err = json.Unmarshal(reply.Values[otaRunningKey], &result.RunningSHA256)
+	if err != nil {
+		return fail("confirm", serialcomm.ProtocolError("新固件未给出正在运行的镜像哈希 (%s): %v", otaRunningKey, err))
+	}
+	if result.RunningSHA256 != result.SHA256 {
+		return fail("confirm", serialcomm.ProtocolError("对端运行的不是推送的镜像: 推送 %s，运行 %s", result.SHA256, result.RunningSHA256))
+	}
+
+	result.Stage = "done"
+	result.Elapsed = sysClock.Since(start)
+	return result, nil
+}
+
+// awaitReboot 在RebootTimeout内反复握手直到新固件应答；串口失效时（USB重新枚举）关闭后按退避重新打开
+func (u *otaUpdate) awaitReboot() (capabilities, error) {
+	reopen := serialcomm.Backoff{Base: 200 * time.Millisecond, Cap: 5 * time.Second, Jitter: 0.2, Clock: sysClock, Rand: randFloat}
+	deadline := sysClock.Now().Add(u.RebootTimeout)
+	var err error
+	for {
+		if u.Port != nil {
+			var peer capabilities
+			peer, _, err = handshake(u.Port, u.Hello, u.Config.Key, u.Config.Timeout, negotiationPolicy{})
+			if err == nil {
+				return peer, nil
+			}
+		}
+		if sysClock.Now().After(deadline) {
+			return capabilities{}, fmt.Errorf("%v内新固件未应答握手: %w", u.RebootTimeout, err)
+		}
+		if u.Reopen == nil || !errors.Is(err, serialcomm.ErrPort) {
+			continue
+		}
+
+		if u.Port != nil {
+			u.Port.Close()
+			u.Port, u.Config.Port = nil, nil
+		}
+		reopen.Wait(err)
+		var port *serial.Port
+		port, err = u.Reopen()
+		if err != nil {
+			log.Printf("重新打开串口失败，对端可能仍在重新枚举: %v", err)
+			continue
+		}
+		u.Port, u.Config.Port = port, port
+		reopen.Reset()
+	}
+}
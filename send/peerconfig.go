@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// configControlType 配置控制帧的类型，帧体为该字节后接configRequest的JSON
+const configControlType = 0x13
+
+// configReplyPrefix 对端回复配置请求的前缀，整行为前缀加configReply的JSON再加换行
+const configReplyPrefix = "CONFIG "
+
+// configRequest 读取或修改对端配置的请求：get读取已生效的配置，set暂存一项修改，
+// commit使暂存的修改生效；set和commit须带上读到的版本号，版本不一致时对端拒绝，防止覆盖他人的修改
+type configRequest struct {
+	Op      string          `json:"op"`
+	Key     string          `json:"key,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Version uint64          `json:"version"`
+}
+
+// configReply 对端的回复，Error非空表示请求被拒绝
+type configReply struct {
+	Version uint64                     `json:"version"`
+	Values  map[string]json.RawMessage `json:"values"`
+	Staged  map[string]json.RawMessage `json:"staged,omitempty"`
+	Error   string                     `json:"error,omitempty"`
+}
+
+// configClient 通过链路读取和修改对端配置；请求未收到回复时重发，
+// 对端对重复的set和commit按幂等处理，因此回复丢失后重发是安全的
+type configClient struct {
+	Port       *serial.Port
+	Timeout    time.Duration          // 等待每次回复的时间
+	MaxRetries int                    // 未收到回复时的最大重发次数
+	Key        serialcomm.KeyProvider // 链路密钥，配置后请求加密发送，对端拒绝明文的配置帧
+}
+
+func (c configClient) get() (configReply, error) {
+	return c.do(configRequest{Op: "get"})
+}
+
+func (c configClient) set(key string, value json.RawMessage, version uint64) (configReply, error) {
+	return c.do(configRequest{Op: "set", Key: key, Value: value, Version: version})
+}
+
+func (c configClient) commit(version uint64) (configReply, error) {
+	return c.do(configRequest{Op: "commit", Version: version})
+}
+
+// do 发送请求并等待回复，超时后重发
+func (c configClient) do(req configRequest) (configReply, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return configReply{}, fmt.Errorf("序列化配置请求失败: %v", err)
+	}
+	frame := append([]byte{configControlType}, body...)
+	if c.Key != nil {
+		frame, err = encryptFrame(frame, c.Key)
+		if err != nil {
+			return configReply{}, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		c.Port.Flush()
+		err = sendData(c.Port, frame)
+		if err != nil {
+			return configReply{}, err
+		}
+		var line []byte
+		line, err = readReplyLine(c.Port, configReplyPrefix, c.Timeout)
+		if errors.Is(err, errFeedbackTimeout) && attempt < c.MaxRetries {
+			log.Printf("配置请求 %s 未收到回复，重发 (第%d/%d次)", req.Op, attempt+1, c.MaxRetries)
+			continue
+		}
+		if err != nil {
+			return configReply{}, err
+		}
+
+		var reply configReply
+		err = json.Unmarshal(line, &reply)
+		if err != nil {
+			return configReply{}, serialcomm.ProtocolError("配置回复无效: %v", err)
+		}
+		if reply.Error != "" {
+			return reply, &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: fmt.Errorf("对端拒绝配置请求 %s: %s", req.Op, reply.Error)}
+		}
+		return reply, nil
+	}
+}
+
+// configValue 把命令行上的值转为JSON：本身是合法JSON（数字、布尔、对象等）时原样使用，否则按字符串处理
+func configValue(s string) json.RawMessage {
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// updatePeerConfig 读取对端配置版本，暂存所有修改后一次提交
+func updatePeerConfig(client configClient, values setFlags) (configReply, error) {
+	current, err := client.get()
+	if err != nil {
+		return current, err
+	}
+	for key, value := range values {
+		_, err = client.set(key, configValue(value), current.Version)
+		if err != nil {
+			return current, err
+		}
+	}
+	return client.commit(current.Version)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"send/internal/serialcomm"
+)
+
+// messagePipeline 编码前对每条消息的处理：过滤读数、补全关联ID、编号、端到端校验和签名，
+// 单条发送与流模式共用，使接收端的重放保护和签名校验对两者同样有效
+type messagePipeline struct {
+	Filter       *telemetryFilter // 按资源的死区和最小间隔过滤读数，nil为不过滤
+	SequenceFile string           // 序号文件，为空时不编号
+	EndToEndCRC  bool             // 在源头计算payload的CRC32
+	Signer       *signer          // 设备私钥签名，nil为不签名
+}
+
+// prepare 就地处理一条消息，签名在最后，覆盖编号和校验之后的payload；
+// 所有读数都被过滤时返回false，此时不消耗序号，消息不应发送
+func (p messagePipeline) prepare(message *Message) (bool, error) {
+	if p.Filter != nil {
+		filtered, send, err := p.Filter.apply(*message)
+		if err != nil {
+			return false, fmt.Errorf("过滤读数失败: %v", err)
+		}
+		if !send {
+			return false, nil
+		}
+		*message = filtered
+	}
+	if message.CorrelationID == "" {
+		id, err := newUUID()
+		if err != nil {
+			return false, err
+		}
+		message.CorrelationID = id
+	}
+	if p.SequenceFile != "" {
+		sequence, err := nextSequence(p.SequenceFile)
+		if err != nil {
+			return false, err
+		}
+		message.Sequence = sequence
+	}
+	if p.EndToEndCRC {
+		payloadData, err := base64.StdEncoding.DecodeString(message.Payload)
+		if err != nil {
+			return false, fmt.Errorf("解码Payload失败: %v", err)
+		}
+		message.PayloadCRC = payloadChecksum(payloadData)
+	}
+	if p.Signer != nil {
+		if err := p.Signer.sign(message); err != nil {
+			return false, fmt.Errorf("签名失败: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// frameEncoder 把消息编码为待分帧的帧体：序列化、键名缩短、字典压缩、链路加密和优先级标记，
+// 单条发送与流模式共用，使两者的线上格式一致
+type frameEncoder struct {
+	Canonical            bool                   // 规范JSON，键按字典序排列，便于签名和逐字节比对
+	KeyMapVersion        byte                   // 键名映射表版本，0为不缩短（接收端需有相同版本的映射表）
+	CompressionThreshold int                    // 帧体达到该长度时压缩，0为不压缩
+	DictID               byte                   // 压缩字典编号，须与接收端配置一致
+	Dict                 []byte                 // 压缩字典
+	LinkKey              serialcomm.KeyProvider // 链路加密密钥，nil为不加密
+}
+
+// encode 编码一条消息；消息的Priority不写入JSON，而作为帧级优先级字节发送
+func (e frameEncoder) encode(message Message) ([]byte, error) {
+	priority := message.Priority
+	message.Priority = 0
+
+	var data []byte
+	if e.KeyMapVersion != 0 {
+		short, err := shortenMessage(message, e.KeyMapVersion)
+		if err != nil {
+			log.Printf("键名缩短失败，按原样发送: %v", err)
+		} else {
+			data = short
+		}
+	}
+	if data == nil {
+		var err error
+		if e.Canonical {
+			data, err = canonicalJSON(message)
+		} else {
+			data, err = json.Marshal(message)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("序列化消息失败: %v", err)
+		}
+		log.Printf("序列化后的JSON数据: %s", string(data))
+	} else {
+		log.Printf("键名缩短(表版本%d): %d字节", e.KeyMapVersion, len(data))
+	}
+	return e.seal(data, priority)
+}
+
+// seal 对已序列化的帧体做压缩、加密并加上优先级标记，透传的原始信封也经过这一步
+func (e frameEncoder) seal(data []byte, priority uint8) ([]byte, error) {
+	// 小帧用通用压缩几乎没有收益，预置字典可显著缩小帧体；压缩后反而变大时发送原文
+	if e.CompressionThreshold > 0 && len(data) >= e.CompressionThreshold {
+		compressed, err := compressWithDict(data, e.DictID, e.Dict)
+		if err != nil {
+			return nil, fmt.Errorf("压缩失败: %v", err)
+		}
+		log.Printf("字典压缩: %d -> %d字节", len(data), len(compressed))
+		if len(compressed) < len(data) {
+			data = compressed
+		}
+	}
+	if e.LinkKey != nil {
+		var err error
+		data, err = encryptFrame(data, e.LinkKey)
+		if err != nil {
+			return nil, fmt.Errorf("加密失败: %v", err)
+		}
+		log.Printf("帧体已加密: %d字节", len(data))
+	}
+	if priority > 0 {
+		data = withPriority(data, priority)
+	}
+	return data, nil
+}
+
+// keyMaps 返回握手时声明使用的键名映射表版本
+func (e frameEncoder) keyMaps() []int {
+	if e.KeyMapVersion == 0 {
+		return nil
+	}
+	return []int{int(e.KeyMapVersion)}
+}
+
+// dicts 返回握手时声明使用的压缩字典编号
+func (e frameEncoder) dicts() []int {
+	if e.CompressionThreshold == 0 {
+		return nil
+	}
+	return []int{int(e.DictID)}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// probeCheck 合规探测中的一项：发送特定的帧并检查对端的反馈
+type probeCheck struct {
+	Name   string
+	Expect string // "ok"、"retry"、"auth" 或 "none"（不应有任何反馈）
+	Send   func(port *serial.Port) error
+}
+
+// probeResult 一项探测的结果
+type probeResult struct {
+	Name   string
+	Expect string
+	Got    string
+	Pass   bool
+}
+
+// probeChecks 返回针对本协议的标准探测序列，data为一帧合法的消息
+func probeChecks(data []byte) []probeCheck {
+	return []probeCheck{
+		{Name: "合法帧", Expect: "ok", Send: func(port *serial.Port) error {
+			return sendData(port, data)
+		}},
+		{Name: "CRC错误", Expect: "retry", Send: func(port *serial.Port) error {
+			return sendWithFault(port, data, "crc")
+		}},
+		{Name: "数据字节损坏", Expect: "retry", Send: func(port *serial.Port) error {
+			return sendWithFault(port, data, "byte")
+		}},
+		{Name: "非JSON帧体", Expect: "retry", Send: func(port *serial.Port) error {
+			return sendData(port, []byte("not json"))
+		}},
+		{Name: "超长长度前缀", Expect: "retry", Send: func(port *serial.Port) error {
+			// 只发送长度前缀，对端应立即判定无效而不是等待这么多数据
+			header := make([]byte, 4)
+			binary.BigEndian.PutUint32(header, 1<<30)
+			_, err := port.Write(header)
+			return err
+		}},
+		{Name: "保活帧", Expect: "none", Send: sendKeepAlive},
+	}
+}
+
+// runProbe 依次执行探测并返回合规报告，每项之间等待对端恢复并清空缓冲区
+func runProbe(port *serial.Port, checks []probeCheck, policy retryPolicy) ([]probeResult, error) {
+	var results []probeResult
+	for _, check := range checks {
+		err := check.Send(port)
+		if err != nil {
+			return results, fmt.Errorf("探测 %q 发送失败: %v", check.Name, err)
+		}
+
+		got := "none"
+		feedback, err := readFeedback(port, policy.FeedbackTimeout, policy.Feedback)
+		switch {
+		case errors.Is(err, errFeedbackTimeout):
+		case err != nil:
+			return results, fmt.Errorf("探测 %q 读取反馈失败: %v", check.Name, err)
+		case policy.Feedback.isOK(feedback):
+			got = "ok"
+		case policy.Feedback.isAuthFail(feedback):
+			got = "auth"
+		case policy.Feedback.match(feedback):
+			got = "retry"
+		default:
+			got = fmt.Sprintf("未知反馈 %q", feedback)
+		}
+		results = append(results, probeResult{Name: check.Name, Expect: check.Expect, Got: got, Pass: got == check.Expect})
+
+		sysClock.Sleep(500 * time.Millisecond) // 等待对端处理完残留数据
+		port.Flush()
+	}
+	return results, nil
+}
+
+// printProbeReport 输出合规报告，返回未通过的项数
+func printProbeReport(w io.Writer, results []probeResult) int {
+	failed := 0
+	for _, r := range results {
+		status := "通过"
+		if !r.Pass {
+			status = "未通过"
+			failed++
+		}
+		fmt.Fprintf(w, "%-12s 期望 %-6s 实际 %-6s %s\n", r.Name, r.Expect, r.Got, status)
+	}
+	fmt.Fprintf(w, "共 %d 项，未通过 %d 项（握手和回显未探测）\n", len(results), failed)
+	return failed
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// provisionContentType 标识配置下发消息，接收端仅在显式开启配置模式时处理
+const provisionContentType = "application/vnd.serialjson.provision+json"
+
+// provisionBundle 下发给出厂设备的密钥材料（均为PEM文本）
+type provisionBundle struct {
+	TrustedKeys []string `json:"trustedKeys"`      // 接收端应信任的Ed25519公钥
+	CACert      string   `json:"caCert,omitempty"` // 接收端应信任的CA证书
+}
+
+// loadProvisionBundle 从PEM文件组装下发内容，caFile可为空
+func loadProvisionBundle(keyFiles []string, caFile string) (provisionBundle, error) {
+	var bundle provisionBundle
+	for _, keyFile := range keyFiles {
+		keyPEM, err := os.ReadFile(keyFile)
+		if err != nil {
+			return bundle, fmt.Errorf("读取公钥文件失败: %v", err)
+		}
+		bundle.TrustedKeys = append(bundle.TrustedKeys, string(keyPEM))
+	}
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return bundle, fmt.Errorf("读取CA文件失败: %v", err)
+		}
+		bundle.CACert = string(caPEM)
+	}
+	return bundle, nil
+}
+
+// buildProvisionMessage 用一次性配对码派生的密钥加密下发内容
+// Payload格式: base64(盐(16字节) | 随机数(12字节) | AES-GCM密文)
+func buildProvisionMessage(pairingCode string, bundle provisionBundle) (Message, error) {
+	plain, err := json.Marshal(bundle)
+	if err != nil {
+		return Message{}, fmt.Errorf("序列化下发内容失败: %v", err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(sysRand, salt); err != nil {
+		return Message{}, fmt.Errorf("生成盐失败: %v", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, pairingCode, salt, 100000, 32)
+	if err != nil {
+		return Message{}, fmt.Errorf("派生密钥失败: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return Message{}, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return Message{}, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(sysRand, nonce); err != nil {
+		return Message{}, fmt.Errorf("生成随机数失败: %v", err)
+	}
+
+	sealed := append(salt, nonce...)
+	sealed = gcm.Seal(sealed, nonce, plain, []byte(provisionContentType))
+	return Message{
+		APIVersion:  "v3",
+		Payload:     base64.StdEncoding.EncodeToString(sealed),
+		ContentType: provisionContentType,
+	}, nil
+}
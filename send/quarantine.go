@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
+)
+
+// errQuarantined 表示对端已被判定失联，发送被拒绝
+var errQuarantined = errors.New("对端已被隔离")
+
+// peerState 某个串口上对端的健康状态
+type peerState struct {
+	Failures int       `json:"failures"` // 连续发送失败次数
+	Dead     bool      `json:"dead"`
+	Since    time.Time `json:"since,omitempty"` // 被隔离的时间
+}
+
+// peerHealth 连续DeadAfter次发送失败后判定对端失联并隔离该串口，不再发送，避免对拔掉的设备
+// 无休止地重试；状态按串口名保存在状态文件中，跨发送进程有效。
+// 隔离期间收到对端的任一有效帧（数据帧或反馈）即自动解除，也可用 -reset-peer 手动解除
+type peerHealth struct {
+	StateFile string
+	DeadAfter int
+	OnDead    func(port string, failures int)
+
+	// ListenWindow 隔离期间每次发送前只监听不发送的时长，收到对端的有效帧则解除隔离后照常发送，
+	// 0表示隔离期间直接拒绝发送
+	ListenWindow time.Duration
+}
+
+func (h peerHealth) load() (map[string]peerState, error) {
+	states := make(map[string]peerState)
+	data, err := os.ReadFile(h.StateFile)
+	if err == nil {
+		err = json.Unmarshal(data, &states)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取对端状态失败: %v", err)
+	}
+	return states, nil
+}
+
+func (h peerHealth) save(states map[string]peerState) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(h.StateFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("写入对端状态失败: %v", err)
+	}
+	return nil
+}
+
+// check 串口上的对端已被隔离时返回errQuarantined
+func (h peerHealth) check(port string) error {
+	states, err := h.load()
+	if err != nil {
+		return err
+	}
+	if state := states[port]; state.Dead {
+		return fmt.Errorf("%w: %s 自 %s 起连续%d次发送失败", errQuarantined, port, state.Since.Format(time.RFC3339), state.Failures)
+	}
+	return nil
+}
+
+// record 记录一次发送结果，成功时清零失败计数
+func (h peerHealth) record(port string, sendErr error) error {
+	states, err := h.load()
+	if err != nil {
+		return err
+	}
+	state := states[port]
+	if sendErr == nil {
+		state = peerState{}
+	} else {
+		state.Failures++
+		if !state.Dead && state.Failures >= h.DeadAfter {
+			state.Dead = true
+			state.Since = sysClock.Now()
+			if h.OnDead != nil {
+				h.OnDead(port, state.Failures)
+			}
+		}
+	}
+	states[port] = state
+	return h.save(states)
+}
+
+// reset 手动解除串口的隔离
+func (h peerHealth) reset(port string) error {
+	states, err := h.load()
+	if err != nil {
+		return err
+	}
+	delete(states, port)
+	return h.save(states)
+}
+
+// heard 在window内监听串口，收到能按framing解码的帧或已知的反馈字符串时返回true；
+// 只读不写，不会对失联的对端造成重试风暴
+func heard(port *serial.Port, window time.Duration, tokens feedbackTokens) (bool, error) {
+	var received bytes.Buffer
+	chunk := make([]byte, 256)
+	deadline := sysClock.Now().Add(window)
+	for sysClock.Now().Before(deadline) {
+		n, err := port.Read(chunk)
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return false, fmt.Errorf("监听对端失败: %v", err)
+		}
+		received.Write(chunk[:n])
+		if n > 0 && tokens.match(received.String()) {
+			return true, nil
+		}
+		for received.Len() > 0 {
+			before := received.Len()
+			_, err := framing.Decode(&received)
+			if err == nil {
+				return true, nil
+			}
+			if err == serialcomm.ErrIncompleteFrame {
+				break
+			}
+			if received.Len() == before {
+				received.Next(1) // 噪声或残帧，跳过一个字节后重新同步
+			}
+		}
+	}
+	return false, nil
+}
+
+// admit 发送前检查串口的隔离状态，隔离期间监听ListenWindow，收到对端的有效帧时解除隔离；
+// 监听需要时打开串口，返回的串口供后续发送使用
+func (h peerHealth) admit(port *serial.Port, config *serial.Config, settings openSettings, tokens feedbackTokens) (*serial.Port, error) {
+	checkErr := h.check(config.Name)
+	if !errors.Is(checkErr, errQuarantined) || h.ListenWindow <= 0 {
+		return port, checkErr
+	}
+	if port == nil {
+		var err error
+		port, err = openPort(config, settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	alive, err := heard(port, h.ListenWindow, tokens)
+	if err != nil {
+		return port, err
+	}
+	if !alive {
+		return port, checkErr
+	}
+	log.Printf("收到 %s 上对端的帧，解除隔离", config.Name)
+	return port, h.record(config.Name, nil)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"send/internal/serialcomm"
+)
+
+// 常用的消息优先级，数值越大越先发送
+const (
+	priorityBulk   = 0 // 批量遥测
+	priorityNormal = 4
+	priorityAlarm  = 7 // 告警，需在延迟目标内送达
+)
+
+// priorityFrameMarker 带优先级的帧体的首字节：标记 | 优先级 | 帧体（可为压缩或加密帧）。
+// 优先级不加密，接收端和中继无需密钥即可按优先级调度；优先级为0的帧不加标记，与旧接收端兼容
+const priorityFrameMarker = 0x05
+
+// withPriority 在帧体前加上优先级标记
+func withPriority(data []byte, priority uint8) []byte {
+	return append([]byte{priorityFrameMarker, priority}, data...)
+}
+
+// queuedFrame 等待发送的一帧
+type queuedFrame struct {
+	priority uint8
+	rank     uint8  // 排序用的优先级：排序域的队首继承域内排队帧的最高优先级
+	seq      uint64 // 入队顺序，同优先级先进先出
+	domain   string // 排序域，为空时不受顺序约束
+	data     []byte
+	noAck    bool // 发出即返回，不等待确认
+	done     chan error
+	index    int // 在堆中的位置
+}
+
+// frameHeap 按优先级从高到低、同优先级按入队顺序排列
+type frameHeap []*queuedFrame
+
+func (h frameHeap) Len() int { return len(h) }
+func (h frameHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank > h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+func (h frameHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *frameHeap) Push(x any) {
+	item := x.(*queuedFrame)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *frameHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// sendQueue 按优先级发送的队列：告警等高优先级消息越过已排队的批量遥测先发送；
+// 正在发送（含重试）的帧不会被打断，因此高优先级消息最多等待一帧的发送时间。
+// 同一排序域（如同一主题）内的帧严格按入队顺序发送，只在不同排序域之间按优先级调整顺序；
+// 域内排在后面的高优先级帧把优先级借给队首，使整个域提前发送而不是被其他域的帧阻塞
+type sendQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frames frameHeap                 // 不属于任何排序域的帧，以及各排序域的队首
+	lanes  map[string][]*queuedFrame // 各排序域中排在队首之后的帧；域存在即表示其队首在堆中
+	seq    uint64
+	closed bool
+
+	// Backoff 某帧因传输错误（重试用尽后）发送失败时，取下一帧前按此退避，发送成功后清零，
+	// 避免串口断开期间逐帧立即重开；为nil时不等待
+	Backoff *serialcomm.Backoff
+
+	// Idle 队列取空后调用（不持锁），如写出合并缓冲中的帧；调用前最多再等待Linger，期间到达的帧先发送。
+	// 为nil时不调用
+	Idle   func()
+	Linger time.Duration
+}
+
+func newSendQueue() *sendQueue {
+	q := &sendQueue{lanes: make(map[string][]*queuedFrame)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// enqueue 加入一帧，domain非空时与同一排序域的帧保持先进先出；返回的通道在该帧发送完成后收到结果
+func (q *sendQueue) enqueue(data []byte, priority uint8, noAck bool, domain string) <-chan error {
+	done := make(chan error, 1)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	frame := &queuedFrame{priority: priority, rank: priority, seq: q.seq, domain: domain, data: data, noAck: noAck, done: done}
+	q.cond.Signal()
+
+	if domain == "" {
+		heap.Push(&q.frames, frame)
+		return done
+	}
+	waiting, ok := q.lanes[domain]
+	if !ok {
+		q.lanes[domain] = nil
+		heap.Push(&q.frames, frame)
+		return done
+	}
+	q.lanes[domain] = append(waiting, frame)
+	// 队首（在堆中）继承更高的优先级
+	for _, head := range q.frames {
+		if head.domain == domain && head.rank < priority {
+			head.rank = priority
+			heap.Fix(&q.frames, head.index)
+			break
+		}
+	}
+	return done
+}
+
+// pop 取出下一帧，若它是排序域的队首，则把域内的下一帧作为新队首放入堆中
+func (q *sendQueue) pop() *queuedFrame {
+	frame := heap.Pop(&q.frames).(*queuedFrame)
+	if frame.domain == "" {
+		return frame
+	}
+	waiting := q.lanes[frame.domain]
+	if len(waiting) == 0 {
+		delete(q.lanes, frame.domain)
+		return frame
+	}
+	next := waiting[0]
+	q.lanes[frame.domain] = waiting[1:]
+	for _, f := range waiting {
+		if f.priority > next.rank {
+			next.rank = f.priority
+		}
+	}
+	heap.Push(&q.frames, next)
+	return frame
+}
+
+// close 不再接受新帧，run在发完已排队的帧后返回
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// linger 在持锁状态下最多等待Linger，直到有新帧到达或队列关闭
+func (q *sendQueue) linger() {
+	if q.Linger <= 0 {
+		return
+	}
+	expired := false
+	timer := time.AfterFunc(q.Linger, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		expired = true
+		q.cond.Broadcast()
+	})
+	defer timer.Stop()
+	for len(q.frames) == 0 && !q.closed && !expired {
+		q.cond.Wait()
+	}
+}
+
+// run 依次取出优先级最高的帧交给send发送，直到队列关闭且为空。send须向done写入该帧的结果恰好一次，
+// 可在返回之后写入（如滑动窗口中等待累积确认的帧）；send返回的错误只用于决定是否退避
+func (q *sendQueue) run(send func(data []byte, noAck bool, done chan<- error) error) {
+	for {
+		q.mu.Lock()
+		if len(q.frames) == 0 && q.Idle != nil {
+			q.linger()
+			if len(q.frames) == 0 {
+				q.mu.Unlock()
+				q.Idle()
+				q.mu.Lock()
+			}
+		}
+		for len(q.frames) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.frames) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		frame := q.pop()
+		q.mu.Unlock()
+
+		err := send(frame.data, frame.noAck, frame.done)
+		if q.Backoff == nil {
+			continue
+		}
+		if errors.Is(err, serialcomm.ErrPort) {
+			q.Backoff.Wait(err)
+		} else {
+			q.Backoff.Reset()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// queuedItem 测试中入队的一帧，帧体即名称
+type queuedItem struct {
+	name     string
+	priority uint8
+	domain   string
+}
+
+func TestSendQueueOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []queuedItem
+		want  []string
+	}{
+		{
+			"按优先级插队，同优先级先进先出",
+			[]queuedItem{{"bulk1", priorityBulk, ""}, {"alarm", priorityAlarm, ""}, {"bulk2", priorityBulk, ""}, {"normal", priorityNormal, ""}},
+			[]string{"alarm", "normal", "bulk1", "bulk2"},
+		},
+		{
+			"排序域内严格先进先出",
+			[]queuedItem{{"a1", priorityBulk, "a"}, {"a2", priorityAlarm, "a"}, {"a3", priorityNormal, "a"}},
+			[]string{"a1", "a2", "a3"},
+		},
+		{
+			"域内的高优先级帧把优先级借给队首",
+			[]queuedItem{{"a1", priorityBulk, "a"}, {"b1", priorityNormal, "b"}, {"a2", priorityAlarm, "a"}},
+			[]string{"a1", "a2", "b1"},
+		},
+		{
+			"新队首继承域内剩余帧的最高优先级",
+			[]queuedItem{{"a1", priorityAlarm, "a"}, {"a2", priorityBulk, "a"}, {"a3", priorityAlarm, "a"}, {"x", priorityNormal, ""}},
+			[]string{"a1", "a2", "a3", "x"},
+		},
+		{
+			"低优先级的域不借用其他域的优先级",
+			[]queuedItem{{"a1", priorityAlarm, "a"}, {"b1", priorityBulk, "b"}, {"x", priorityNormal, ""}, {"b2", priorityBulk, "b"}},
+			[]string{"a1", "x", "b1", "b2"},
+		},
+		{
+			"不同域同优先级按入队顺序",
+			[]queuedItem{{"b1", priorityNormal, "b"}, {"a1", priorityNormal, "a"}, {"x", priorityNormal, ""}, {"a2", priorityNormal, "a"}},
+			[]string{"b1", "a1", "x", "a2"},
+		},
+	}
+	for _, tc := range tests {
+		q := newSendQueue()
+		for _, item := range tc.items {
+			q.enqueue([]byte(item.name), item.priority, false, item.domain)
+		}
+		q.close()
+
+		var got []string
+		q.run(func(data []byte, noAck bool, done chan<- error) error {
+			got = append(got, string(data))
+			done <- nil
+			return nil
+		})
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: 发送顺序 %v，期望 %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSendQueueResults(t *testing.T) {
+	q := newSendQueue()
+	failed := errors.New("发送失败")
+	ok := q.enqueue([]byte("ok"), priorityBulk, true, "")
+	bad := q.enqueue([]byte("bad"), priorityAlarm, false, "")
+	q.close()
+
+	noAcks := make(map[string]bool)
+	q.run(func(data []byte, noAck bool, done chan<- error) error {
+		noAcks[string(data)] = noAck
+		if string(data) == "bad" {
+			done <- failed
+			return failed
+		}
+		done <- nil
+		return nil
+	})
+
+	if err := <-ok; err != nil {
+		t.Errorf("ok 的结果为 %v，期望成功", err)
+	}
+	if err := <-bad; err != failed {
+		t.Errorf("bad 的结果为 %v，期望 %v", err, failed)
+	}
+	if !noAcks["ok"] || noAcks["bad"] {
+		t.Errorf("noAck 未随帧传递: %v", noAcks)
+	}
+}
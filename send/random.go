@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// sysRand 随机数来源，用于退避抖动和UUID生成；测试时可替换为固定序列，
+// 熵不足的嵌入式平台可替换为硬件随机数发生器
+var sysRand io.Reader = rand.Reader
+
+// randFloat 返回 [0,1) 内的随机数，随机源出错时返回0.5
+func randFloat() float64 {
+	var b [8]byte
+	_, err := io.ReadFull(sysRand, b[:])
+	if err != nil {
+		return 0.5
+	}
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
+
+// newUUID 生成版本4的UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	_, err := io.ReadFull(sysRand, b[:])
+	if err != nil {
+		return "", fmt.Errorf("生成UUID失败: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // 版本4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122变体
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tarm/serial"
+)
+
+const replHelp = `命令:
+  show                      显示当前消息
+  set <字段> <值>           设置字段（apiVersion/receivedTopic/correlationID/requestID/errorCode/contentType）
+  payload <文件>            读取文件并以base64写入payload
+  payload-text <文本>       将文本以base64写入payload
+  fault <none|crc|byte>     故障注入：crc发送错误的校验和，byte在校验后翻转一个数据字节
+  send                      发送一次并等待反馈（不重试）
+  keepalive                 发送长度为0的保活帧（接收端不回复），配置了心跳时空闲期间自动发送
+  help                      显示帮助
+  quit                      退出`
+
+// runREPL 交互式编辑并发送消息，用于新固件的协议联调；hb不为nil时在等待输入期间发送心跳
+func runREPL(port *serial.Port, message Message, policy retryPolicy, audit *auditor, hb *heartbeat) {
+	fault := "none"
+	if hb == nil {
+		hb = &heartbeat{}
+	} else {
+		stop := make(chan struct{})
+		defer close(stop)
+		go hb.run(port, stop)
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Println(replHelp)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), fields[0]))
+
+		switch fields[0] {
+		case "show":
+			data, _ := json.MarshalIndent(message, "", "  ")
+			fmt.Println(string(data))
+		case "set":
+			if len(fields) < 3 {
+				fmt.Println("用法: set <字段> <值>")
+				continue
+			}
+			err := setMessageField(&message, fields[1], strings.TrimSpace(strings.TrimPrefix(arg, fields[1])))
+			if err != nil {
+				fmt.Println(err)
+			}
+		case "payload":
+			data, err := os.ReadFile(arg)
+			if err != nil {
+				fmt.Printf("读取文件失败: %v\n", err)
+				continue
+			}
+			message.Payload = base64.StdEncoding.EncodeToString(data)
+			fmt.Printf("payload已设置 (%d字节)\n", len(data))
+		case "payload-text":
+			message.Payload = base64.StdEncoding.EncodeToString([]byte(arg))
+		case "fault":
+			switch arg {
+			case "none", "crc", "byte":
+				fault = arg
+				fmt.Printf("故障注入: %s\n", fault)
+			default:
+				fmt.Println("用法: fault <none|crc|byte>")
+			}
+		case "send":
+			data, err := json.Marshal(message)
+			if err != nil {
+				fmt.Printf("序列化消息失败: %v\n", err)
+				continue
+			}
+			// 发送和读取反馈都在写锁内完成，避免心跳插入到等待反馈的过程中
+			var feedback string
+			var feedbackErr error
+			hb.do(func() {
+				err = sendWithFault(port, data, fault)
+				if err == nil {
+					feedback, feedbackErr = readFeedback(port, policy.FeedbackTimeout, policy.Feedback)
+				}
+			})
+			if auditErr := audit.record(message, len(data), err); auditErr != nil {
+				log.Print(auditErr)
+			}
+			if err != nil {
+				fmt.Printf("发送失败: %v\n", err)
+				continue
+			}
+			if feedbackErr != nil {
+				fmt.Printf("未收到反馈: %v\n", feedbackErr)
+				continue
+			}
+			fmt.Printf("收到反馈: %q\n", feedback)
+		case "keepalive":
+			var err error
+			hb.do(func() { err = sendKeepAlive(port) })
+			if err != nil {
+				fmt.Printf("发送保活帧失败: %v\n", err)
+			}
+		case "help":
+			fmt.Println(replHelp)
+		case "quit", "exit":
+			return
+		default:
+			fmt.Printf("未知命令 %q，输入help查看帮助\n", fields[0])
+		}
+	}
+}
+
+// setMessageField 按JSON字段名设置消息字段
+func setMessageField(message *Message, name, value string) error {
+	switch name {
+	case "apiVersion":
+		message.APIVersion = value
+	case "receivedTopic":
+		message.ReceivedTopic = value
+	case "correlationID":
+		message.CorrelationID = value
+	case "requestID":
+		message.RequestID = value
+	case "contentType":
+		message.ContentType = value
+	case "errorCode":
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("errorCode必须是整数: %v", err)
+		}
+		message.ErrorCode = code
+	default:
+		return fmt.Errorf("未知字段 %q", name)
+	}
+	return nil
+}
+
+// sendWithFault 按故障注入设置发送一帧：crc翻转帧尾前的校验字节，byte翻转帧中间的一个字节
+func sendWithFault(port *serial.Port, data []byte, fault string) error {
+	frame, err := framing.Encode(data)
+	if err != nil {
+		return err
+	}
+	switch fault {
+	case "crc":
+		if len(frame) >= 2 {
+			frame[len(frame)-2] ^= 0xFF
+		}
+	case "byte":
+		if len(data) > 0 {
+			frame[len(frame)/2] ^= 0x01
+		}
+	}
+	return sendFrame(port, frame)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// link 带标签的串口，如 {Name: "COM6", Labels: {"rack": "rack3"}}
+type link struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+// routeRule 路由规则：设备名匹配Device（支持 * ? 通配）的消息发往标签匹配Selector的串口
+type routeRule struct {
+	Device   string            `json:"device"`
+	Selector map[string]string `json:"selector"`
+}
+
+// routeConfig 路由配置文件，如
+//
+//	{"links": [{"name": "COM6", "labels": {"rack": "rack3"}}],
+//	 "rules": [{"device": "Boiler-*", "selector": {"rack": "rack3"}}]}
+type routeConfig struct {
+	Links []link      `json:"links"`
+	Rules []routeRule `json:"rules"`
+}
+
+// loadRoutes 读取路由配置文件，检查串口名和设备名通配符
+func loadRoutes(file string) ([]link, []routeRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取路由配置失败: %v", err)
+	}
+	var config routeConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析路由配置 %s 失败: %v", file, err)
+	}
+	if len(config.Links) == 0 || len(config.Rules) == 0 {
+		return nil, nil, fmt.Errorf("路由配置 %s 须至少包含一个串口和一条规则", file)
+	}
+	for _, l := range config.Links {
+		if l.Name == "" {
+			return nil, nil, fmt.Errorf("路由配置 %s 中有串口未指定名称", file)
+		}
+	}
+	for _, rule := range config.Rules {
+		if _, err := path.Match(rule.Device, ""); err != nil {
+			return nil, nil, fmt.Errorf("路由规则 %q 无效: %v", rule.Device, err)
+		}
+	}
+	return config.Links, config.Rules, nil
+}
+
+// matches 判断串口的标签是否包含选择器中的全部键值
+func (l link) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if l.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// route 按第一条匹配设备名的规则选出目标串口，没有规则匹配时返回错误
+func route(links []link, rules []routeRule, deviceName string) ([]link, error) {
+	for _, rule := range rules {
+		ok, err := path.Match(rule.Device, deviceName)
+		if err != nil {
+			return nil, fmt.Errorf("路由规则 %q 无效: %v", rule.Device, err)
+		}
+		if !ok {
+			continue
+		}
+		var targets []link
+		for _, l := range links {
+			if l.matches(rule.Selector) {
+				targets = append(targets, l)
+			}
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("设备 %s 匹配规则 %q，但没有串口带有标签 %v", deviceName, rule.Device, rule.Selector)
+		}
+		return targets, nil
+	}
+	return nil, fmt.Errorf("设备 %s 没有匹配的路由规则", deviceName)
+}
+
+// messageDevice 从消息的payload中取出事件的设备名，用于路由
+func messageDevice(message Message) (string, error) {
+	payloadData, err := base64.StdEncoding.DecodeString(message.Payload)
+	if err != nil {
+		return "", fmt.Errorf("解码Payload失败: %v", err)
+	}
+	var payload Payload
+	err = json.Unmarshal(payloadData, &payload)
+	if err != nil {
+		return "", fmt.Errorf("解析Payload失败: %v", err)
+	}
+	return payload.Event.DeviceName, nil
+}
+
+// lookupLearnedRoute 在接收端学习到的路由表文件中查找设备所在的串口
+func lookupLearnedRoute(tableFile, deviceName string) (string, error) {
+	data, err := os.ReadFile(tableFile)
+	if err != nil {
+		return "", fmt.Errorf("读取路由表失败: %v", err)
+	}
+	var routes map[string]struct {
+		Port string `json:"port"`
+	}
+	err = json.Unmarshal(data, &routes)
+	if err != nil {
+		return "", fmt.Errorf("解析路由表 %s 失败: %v", tableFile, err)
+	}
+	route, ok := routes[deviceName]
+	if !ok {
+		return "", fmt.Errorf("路由表中没有设备 %s", deviceName)
+	}
+	return route.Port, nil
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
-	"encoding/binary"
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/sigurn/crc16"
 	"github.com/tarm/serial"
+
+	"send/internal/serialcomm"
 )
 
 type Reading struct {
@@ -45,90 +58,613 @@ type Message struct {
 	ErrorCode     int    `json:"errorCode"`
 	Payload       string `json:"payload"`
 	ContentType   string `json:"contentType"`
+	Signature     string `json:"signature,omitempty"`   // Payload的Ed25519签名（base64）
+	Certificate   string `json:"certificate,omitempty"` // 签名设备的证书（DER，base64）
+	Sequence      uint64 `json:"sequence,omitempty"`    // 发送端递增的序号，0表示未编号
+	PayloadCRC    string `json:"payloadCRC,omitempty"`  // 解码后payload的CRC32（十六进制），用于端到端校验
+	NoAck         bool   `json:"noAck,omitempty"`       // 发送端不等待确认（遥测），接收端成功处理后不回复
+	Priority      uint8  `json:"priority,omitempty"`    // 发送优先级，数值越大越先发送（如告警为7），0为批量遥测；线上作为帧级优先级字节发送
+
+	// Extra 保存新版本对端添加的未知字段，编码时原样写回，使转发不丢字段
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// messageFields 与Message字段相同但没有自定义编解码方法，避免递归调用
+type messageFields Message
+
+// UnmarshalJSON 解码已知字段，并把未知字段保存到Extra
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var fields messageFields
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(data, &all)
+	if err != nil {
+		return err
+	}
+
+	known := knownJSONFields(reflect.TypeOf(fields))
+	fields.Extra = nil
+	for name, value := range all {
+		if !known(name) {
+			if fields.Extra == nil {
+				fields.Extra = make(map[string]json.RawMessage)
+			}
+			fields.Extra[name] = value
+		}
+	}
+	*m = Message(fields)
+	return nil
+}
+
+// MarshalJSON 编码已知字段，并按键名顺序追加Extra中的未知字段
+func (m Message) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(messageFields(m))
+	if err != nil || len(m.Extra) == 0 {
+		return data, err
+	}
+
+	names := make([]string, 0, len(m.Extra))
+	for name := range m.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1]) // 去掉结尾的 }
+	for _, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(m.Extra[name])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// knownJSONFields 返回判断JSON键是否对应结构体字段的函数（与encoding/json一样不区分大小写）
+func knownJSONFields(t reflect.Type) func(name string) bool {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		names = append(names, name)
+	}
+	return func(name string) bool {
+		for _, known := range names {
+			if strings.EqualFold(known, name) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
-func calculateCRC16(data []byte) uint16 {
-	table := crc16.MakeTable(crc16.CRC16_MODBUS)
-	if table == nil {
-		log.Fatal("无法生成CRC16表")
+// payloadChecksum 计算解码后payload的CRC32，链路CRC只保护单跳，该值在源头计算、在最终接收端校验
+func payloadChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// canonicalJSON 以规范形式编码：所有对象的键按字典序排列，数字保持原始文本，
+// 不做HTML转义且无多余空白，保证同一消息在不同Go版本和平台上得到相同的字节
+func canonicalJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic any
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return nil, err
 	}
-	crc := crc16.Checksum(data, table)
-	log.Printf("CRC16 计算输入数据长度: %d, 校验和: %x", len(data), crc)
-	return crc
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(generic) // map的键由encoding/json按字典序输出
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
 }
 
+// parseRawEnvelope 校验已序列化的消息信封可直接成帧发送，只读取信封头部用于日志和审计，
+// 发送时使用原始字节而不重新编码；contentType非空时要求与信封中的contentType一致
+func parseRawEnvelope(contentType string, frameBody []byte) (Message, error) {
+	if !json.Valid(frameBody) {
+		return Message{}, fmt.Errorf("原始信封不是有效的JSON")
+	}
+	var header Message
+	err := json.Unmarshal(frameBody, &header)
+	if err != nil {
+		return Message{}, fmt.Errorf("解析原始信封失败: %v", err)
+	}
+	if contentType != "" && header.ContentType != contentType {
+		return Message{}, fmt.Errorf("原始信封的contentType为 %q，期望 %q", header.ContentType, contentType)
+	}
+	return header, nil
+}
+
+// framing 发送使用的分帧方式，由 -framing、-length-prefix、-no-terminator、-frame-version、-sync-marker 等选项指定，须与接收端一致；
+// 与MODBUS从站共用总线时用 -framing modbus
+var framing serialcomm.FrameCodec = serialcomm.LengthCRCCodec{}
+
 func sendData(port *serial.Port, data []byte) error {
-	// 添加4字节长度前缀（大端序）
-	length := uint32(len(data))
-	log.Printf("长度前缀的值为:%v", length)
-	lengthBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(lengthBytes, length)
-	log.Printf("长度前缀数组为:%v", lengthBytes)
-	_, err := port.Write(lengthBytes)
+	frame, err := framing.Encode(data)
 	if err != nil {
-		return fmt.Errorf("发送长度前缀失败: %v", err)
+		return err
 	}
-	log.Printf("发送长度前缀: %d字节（十六进制: %x）", length, lengthBytes)
+	return sendFrame(port, frame)
+}
+
+// sendKeepAlive 发送长度为0的保活帧，接收端据此确认链路在线且不会回复反馈
+func sendKeepAlive(port *serial.Port) error {
+	return sendData(port, nil)
+}
 
-	// 计算CRC16校验和
-	crc := calculateCRC16(data)
-	crcBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(crcBytes, crc)
+// sendFrame 分段写出已编码的帧，故障注入时可传入被篡改的帧
+func sendFrame(port *serial.Port, frame []byte) error {
+	// RTU帧内不能有超过1.5个字符的间隔，必须一次写出，前后保持帧间静默
+	if c, ok := serialcomm.ModbusFraming(framing); ok {
+		sysClock.Sleep(c.Silence())
+		_, err := port.Write(frame)
+		if err != nil {
+			return serialcomm.PortError("发送RTU帧失败", err)
+		}
+		log.Printf("发送完整RTU帧: %d字节 (十六进制: %x)", len(frame), frame)
+		sysClock.Sleep(c.Silence())
+		return nil
+	}
 
 	// 按20字节分段发送
 	chunkSize := 20
-	for i := 0; i < len(data); i += chunkSize {
+	for i := 0; i < len(frame); i += chunkSize {
 		end := i + chunkSize
-		if end > len(data) {
-			end = len(data)
+		if end > len(frame) {
+			end = len(frame)
 		}
-		chunk := data[i:end]
-		_, err = port.Write(chunk)
+		chunk := frame[i:end]
+		_, err := port.Write(chunk)
 		if err != nil {
-			return fmt.Errorf("发送第%d块数据失败: %v", i/chunkSize+1, err)
+			return serialcomm.PortError(fmt.Sprintf("发送第%d块数据失败", i/chunkSize+1), err)
 		}
 		log.Printf("发送第%d块数据: %d字节，内容: %q (十六进制: %x)", i/chunkSize+1, len(chunk), chunk, chunk)
-		time.Sleep(50 * time.Millisecond) // 每段之间添加50ms延迟
+		sysClock.Sleep(50 * time.Millisecond) // 每段之间添加50ms延迟
 	}
+	log.Printf("发送完整帧: %d字节", len(frame))
+	return nil
+}
 
-	// 发送CRC16校验和
-	_, err = port.Write(crcBytes)
-	if err != nil {
-		return fmt.Errorf("发送CRC16校验和失败: %v", err)
+// errFeedbackTimeout 表示在超时时间内未收到对端反馈
+var errFeedbackTimeout = &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: errors.New("反馈读取超时")}
+
+// feedbackTokens 对端使用的反馈字符串，旧固件可能回复 "ACK"/"NAK" 或中文等其他字符串
+type feedbackTokens struct {
+	OK       []string // 表示确认的字符串
+	Retry    []string // 表示请求重传的字符串
+	AuthFail []string // 表示帧认证失败的字符串，重传无济于事
+}
+
+// defaultFeedback 本协议默认的反馈字符串
+var defaultFeedback = feedbackTokens{OK: []string{"OK"}, Retry: []string{"RETRY"}, AuthFail: []string{"AUTH"}}
+
+// wrap 返回用wrapToken转换后的反馈字符串，如封装为MODBUS RTU帧
+func (t feedbackTokens) wrap(wrapToken func(string) string) feedbackTokens {
+	var wrapped feedbackTokens
+	for _, pair := range []struct{ from, to *[]string }{
+		{&t.OK, &wrapped.OK}, {&t.Retry, &wrapped.Retry}, {&t.AuthFail, &wrapped.AuthFail},
+	} {
+		for _, token := range *pair.from {
+			*pair.to = append(*pair.to, wrapToken(token))
+		}
 	}
-	log.Printf("发送CRC16校验和: %x", crcBytes)
+	return wrapped
+}
 
-	// 发送结束标记（换行符）
-	_, err = port.Write([]byte("\n"))
-	if err != nil {
-		return fmt.Errorf("发送结束标记失败: %v", err)
+// isOK 判断反馈是否为确认
+func (t feedbackTokens) isOK(feedback string) bool {
+	for _, token := range t.OK {
+		if feedback == token {
+			return true
+		}
 	}
-	log.Println("发送结束标记 (\\n)")
+	return false
+}
 
-	return nil
+// isAuthFail 判断反馈是否为认证失败
+func (t feedbackTokens) isAuthFail(feedback string) bool {
+	for _, token := range t.AuthFail {
+		if feedback == token {
+			return true
+		}
+	}
+	return false
 }
 
-func readFeedback(port *serial.Port, timeout time.Duration) (string, error) {
-	feedback := make([]byte, 10)
+// match 判断反馈是否为已知的确认、重传或认证失败字符串
+func (t feedbackTokens) match(feedback string) bool {
+	if t.isOK(feedback) || t.isAuthFail(feedback) {
+		return true
+	}
+	for _, token := range t.Retry {
+		if feedback == token {
+			return true
+		}
+	}
+	return false
+}
+
+// maxLen 返回最长反馈字符串的字节数，至少为10
+func (t feedbackTokens) maxLen() int {
+	n := 10
+	for _, tokens := range [][]string{t.OK, t.Retry, t.AuthFail} {
+		for _, token := range tokens {
+			if len(token) > n {
+				n = len(token)
+			}
+		}
+	}
+	return n
+}
+
+func readFeedback(port *serial.Port, timeout time.Duration, tokens feedbackTokens) (string, error) {
+	feedback := make([]byte, tokens.maxLen())
 	var totalRead int
-	start := time.Now()
+	start := sysClock.Now()
 
-	for time.Since(start) < timeout {
+	for sysClock.Since(start) < timeout {
 		n, err := port.Read(feedback[totalRead:])
-		if err != nil {
-			return "", fmt.Errorf("读取反馈失败: %v", err)
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return "", serialcomm.PortError("读取反馈失败", err)
 		}
 		totalRead += n
-		if totalRead > 0 && (string(feedback[:totalRead]) == "OK" || string(feedback[:totalRead]) == "RETRY") {
+		if totalRead > 0 && tokens.match(string(feedback[:totalRead])) {
 			return string(feedback[:totalRead]), nil
 		}
-		time.Sleep(10 * time.Millisecond) // 防止CPU过载
+		if totalRead == len(feedback) {
+			return string(feedback), nil // 缓冲区已满仍未匹配，交由调用方按未知反馈处理
+		}
+		sysClock.Sleep(10 * time.Millisecond) // 防止CPU过载
+	}
+
+	return "", fmt.Errorf("%w (%v)", errFeedbackTimeout, timeout)
+}
+
+// retryPolicy 发送重试策略，传输错误与协议否认分别计数
+type retryPolicy struct {
+	Transport       serialcomm.Backoff // 传输错误（写失败、读失败、打开失败）后的退避，每次重开串口
+	MaxNackRetries  int                // 对端否认（RETRY、未知反馈、无反馈）后的最大立即重发次数
+	FeedbackTimeout time.Duration      // 等待对端反馈的时间
+	Feedback        feedbackTokens     // 对端使用的确认/重传字符串
+
+	// LatencyBudget 从首次发送到收到确认的时间预算，0表示不限；超出预算的消息不再发送，
+	// 避免控制命令过期后才到达，并调用OnExpire通知应用
+	LatencyBudget time.Duration
+	OnExpire      func(data []byte, elapsed time.Duration)
+
+	// NoAck 发出即返回，不等待确认也不重发（传输错误仍按Transport重试），适合周期性遥测
+	NoAck bool
+
+	// MaxFrameLength 帧体超过该长度时分片发送，须不超过接收端的最大长度，0表示只按分帧方式的上限分片
+	MaxFrameLength int
+
+	// Standby 冷备串口：当前串口连续FailoverAfter次传输失败后切换到另一个串口，
+	// 备用串口同样失败时切回主串口，每次切换调用OnFailover
+	Standby       *serial.Config
+	FailoverAfter int
+	OnFailover    func(from, to string)
+
+	// Health 失联隔离，非nil时每次发送（含广播、流模式）前检查对端是否已被隔离并记录发送结果
+	Health *peerHealth
+}
+
+// ackMode 单条消息的确认要求，使同一链路上遥测与命令可以采用不同的可靠性
+type ackMode int
+
+const (
+	ackDefault  ackMode = iota // 沿用链路默认策略
+	ackRequired                // 命令：必须确认，未确认时重发
+	ackNone                    // 遥测：不等待确认
+)
+
+// noAck 按链路默认值解析消息是否免确认
+func (m ackMode) noAck(linkDefault bool) bool {
+	switch m {
+	case ackRequired:
+		return false
+	case ackNone:
+		return true
+	}
+	return linkDefault
+}
+
+// errExpired 表示消息未能在时间预算内发送并确认
+var errExpired = &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: errors.New("消息超出时间预算")}
+
+// sendWithRetry 发送数据直到收到确认，返回的串口可能因传输错误而被重新打开
+func sendWithRetry(port *serial.Port, config *serial.Config, settings openSettings, data []byte, hooks []preSendHook, policy retryPolicy) (*serial.Port, error) {
+	health := policy.Health
+	if health == nil {
+		return deliver(port, config, settings, data, hooks, policy)
+	}
+	port, err := health.admit(port, config, settings, policy.Feedback)
+	if err != nil {
+		return port, err
+	}
+	port, err = deliver(port, config, settings, data, hooks, policy)
+	if healthErr := health.record(config.Name, err); healthErr != nil {
+		log.Print(healthErr)
+	}
+	return port, err
+}
+
+// frameLimit 返回单帧帧体的最大长度，0表示不限
+func frameLimit(policy retryPolicy) int {
+	maxFrame := policy.MaxFrameLength
+	if limit := serialcomm.MaxBody(framing); limit > 0 && (maxFrame == 0 || maxFrame > limit) {
+		maxFrame = limit // 分片不能超过长度前缀等分帧格式本身的上限
+	}
+	return maxFrame
+}
+
+// deliver 按策略发送一条消息，超过最大帧长时分片，不检查失联隔离
+func deliver(port *serial.Port, config *serial.Config, settings openSettings, data []byte, hooks []preSendHook, policy retryPolicy) (*serial.Port, error) {
+	maxFrame := frameLimit(policy)
+	if maxFrame > 0 && len(data) > maxFrame {
+		fragments, err := splitFragments(data, maxFrame)
+		if err != nil {
+			return port, err
+		}
+		log.Printf("数据 %d 字节超过最大帧长 %d，分为 %d 片发送", len(data), maxFrame, len(fragments))
+		fragmentPolicy := policy
+		fragmentPolicy.MaxFrameLength = maxFrame
+		for i, fragment := range fragments {
+			port, err = deliver(port, config, settings, fragment, hooks, fragmentPolicy)
+			if err != nil {
+				return port, fmt.Errorf("发送第%d/%d片失败: %w", i+1, len(fragments), err)
+			}
+		}
+		return port, nil
 	}
 
-	return "", fmt.Errorf("反馈读取超时 (%v)", timeout)
+	var nackFailures, portFailures int
+	transport := policy.Transport
+	active := config // 当前使用的串口配置，冷备切换后为policy.Standby
+	start := sysClock.Now()
+
+	for {
+		if elapsed := sysClock.Since(start); policy.LatencyBudget > 0 && elapsed > policy.LatencyBudget {
+			if policy.OnExpire != nil {
+				policy.OnExpire(data, elapsed)
+			}
+			return port, fmt.Errorf("%w (预算 %v，已用 %v)", errExpired, policy.LatencyBudget, elapsed.Round(time.Millisecond))
+		}
+
+		var err error
+		var feedback string
+		if port == nil {
+			port, err = openPort(active, settings)
+		}
+		if err == nil {
+			err = runPreSendHooks(port, hooks)
+		}
+		if err == nil && !policy.NoAck {
+			// 丢弃此前免确认消息可能留下的反馈，避免误当作本帧的确认
+			port.Flush()
+		}
+		if err == nil {
+			err = sendData(port, data)
+		}
+		if err == nil && policy.NoAck {
+			return port, nil
+		}
+		if err == nil {
+			feedback, err = readFeedback(port, policy.FeedbackTimeout, policy.Feedback)
+		}
+
+		switch {
+		case err == nil && policy.Feedback.isOK(feedback):
+			return port, nil
+
+		case err == nil && policy.Feedback.isAuthFail(feedback):
+			// 密钥不一致时重发同样会失败，直接放弃
+			return port, &serialcomm.LinkError{Kind: serialcomm.ErrAuth, Err: fmt.Errorf("接收端拒绝了帧的HMAC (反馈: %q)", feedback)}
+
+		case errors.Is(err, serialcomm.ErrProtocol):
+			// 帧无法编码，重发无济于事
+			return port, err
+
+		case err == nil || errors.Is(err, errFeedbackTimeout):
+			// 协议层失败：链路正常但对端未确认，立即重发
+			nackFailures++
+			if nackFailures > policy.MaxNackRetries {
+				return port, &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: fmt.Errorf("对端连续%d次未确认 (最后反馈: %q)", nackFailures, feedback)}
+			}
+			if err != nil {
+				log.Printf("%v，立即重发 (第%d/%d次)", err, nackFailures, policy.MaxNackRetries)
+			} else {
+				log.Printf("接收端反馈 %q，立即重发 (第%d/%d次)", feedback, nackFailures, policy.MaxNackRetries)
+			}
+			port.Flush() // 清空缓冲区以避免残留数据
+
+		default:
+			// 传输层失败：关闭串口，退避后重新打开，连续失败过多时切换到冷备串口
+			if port != nil {
+				port.Close()
+				port = nil
+			}
+			portFailures++
+			if policy.Standby != nil && portFailures >= policy.FailoverAfter {
+				next := policy.Standby
+				if active == policy.Standby {
+					next = config
+				}
+				log.Printf("串口 %s 连续%d次传输失败，切换到 %s", active.Name, portFailures, next.Name)
+				if policy.OnFailover != nil {
+					policy.OnFailover(active.Name, next.Name)
+				}
+				active = next
+				portFailures = 0
+			}
+			if !transport.Wait(err) {
+				return nil, fmt.Errorf("传输错误重试%d次后仍失败: %w", transport.MaxAttempts, err)
+			}
+		}
+	}
+}
+
+// openPort 打开串口并等待对端稳定
+func openPort(config *serial.Config, settings openSettings) (*serial.Port, error) {
+	port, err := serial.OpenPort(config)
+	if err != nil {
+		return nil, serialcomm.PortError("无法打开串口", err)
+	}
+	err = settlePort(port, config.Name, settings)
+	if err != nil {
+		port.Close()
+		return nil, serialcomm.PortError("串口稳定等待失败", err)
+	}
+	return port, nil
+}
+
+// verifyLink 按配置打开串口、等待稳定后关闭，不发送任何应用数据
+func verifyLink(config *serial.Config, settings openSettings) error {
+	if config.Baud <= 0 {
+		return fmt.Errorf("波特率无效: %d", config.Baud)
+	}
+	port, err := openPort(config, settings)
+	if err != nil {
+		return err
+	}
+	closePort(port, config.Name)
+	return nil
+}
+
+// drainTimeout 关闭串口前等待发送缓冲区排空的最长时间
+const drainTimeout = 2 * time.Second
+
+// closePort 先排空驱动发送缓冲区再关闭串口，避免最后的帧在关闭文件描述符时丢失
+func closePort(port *serial.Port, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	err := serialcomm.DrainPort(ctx, name)
+	if err != nil {
+		log.Printf("%v，直接关闭串口", err)
+	}
+	port.Close()
+}
+
+// openSettings 控制串口打开后、首次发送前的行为
+type openSettings struct {
+	DTR           *bool         // 打开后设置DTR电平，nil表示保持驱动默认
+	RTS           *bool         // 打开后设置RTS电平，nil表示保持驱动默认
+	SettleDelay   time.Duration // 打开后等待对端稳定（如Arduino因DTR复位）的时间
+	DiscardWindow time.Duration // 首次发送前读取并丢弃对端启动输出的时间窗口
+}
+
+// settlePort 按配置设置控制线并等待对端稳定，丢弃其启动阶段的输出
+func settlePort(port *serial.Port, name string, settings openSettings) error {
+	err := setModemLines(name, settings.DTR, settings.RTS)
+	if err != nil {
+		return err
+	}
+
+	if settings.SettleDelay > 0 {
+		log.Printf("等待对端稳定 %v", settings.SettleDelay)
+		sysClock.Sleep(settings.SettleDelay)
+	}
+
+	if settings.DiscardWindow > 0 {
+		discard := make([]byte, 256)
+		var discarded int
+		deadline := sysClock.Now().Add(settings.DiscardWindow)
+		for sysClock.Now().Before(deadline) {
+			n, err := port.Read(discard)
+			if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+				return fmt.Errorf("丢弃启动输出失败: %v", err)
+			}
+			discarded += n
+		}
+		log.Printf("丢弃窗口结束，共丢弃 %d 字节", discarded)
+	}
+
+	return port.Flush()
 }
 
 func main() {
+	repl := flag.Bool("repl", false, "进入交互模式，手动编辑并发送消息")
+	verify := flag.Bool("verify", false, "只按配置打开并关闭串口，不发送任何数据，用于安装时检查接线和参数")
+	resetPeer := flag.Bool("reset-peer", false, "解除对端的失联隔离后退出")
+	probe := flag.Bool("probe", false, "向对端发送合法帧、错误CRC、超长帧等探测序列，输出协议合规报告")
+	templatePath := flag.String("template", "", "用模板生成消息，模板输出为消息JSON")
+	vars := setFlags{}
+	flag.Var(vars, "set", "模板参数 key=value，可重复")
+	xmodemFile := flag.String("xmodem", "", "以XMODEM-CRC发送该文件（如给bootloader），不使用本协议的帧格式")
+	ymodemFile := flag.String("ymodem", "", "以YMODEM发送该文件，文件名和大小随文件头发送")
+	stream := flag.Bool("stream", false, "从标准输入逐行读取消息JSON，按消息的priority排队发送（告警越过排队中的批量遥测）")
+	otaImage := flag.String("ota", "", "向对端推送该固件镜像，核对哈希后切换并等待新固件启动，输出JSON结果")
+	configGet := flag.Bool("config-get", false, "读取对端设备的配置后退出")
+	configSet := setFlags{}
+	flag.Var(configSet, "config-set", "修改对端设备的配置 key=value（值可为JSON），可重复，全部暂存后一次提交")
+	trainDict := flag.String("train-dict", "", "从参数中的抓包文件（每行一个帧体JSON）训练压缩字典并写入该文件")
+	var opts sendOptions
+	opts.registerFlags()
+	flag.Parse()
+
+	err := errors.Join(opts.validate(), validateModes(map[string]bool{
+		"-repl":       *repl,
+		"-verify":     *verify,
+		"-reset-peer": *resetPeer,
+		"-probe":      *probe,
+		"-xmodem":     *xmodemFile != "",
+		"-ymodem":     *ymodemFile != "",
+		"-stream":     *stream,
+		"-ota":        *otaImage != "",
+		"-config-get": *configGet,
+		"-config-set": len(configSet) > 0,
+		"-train-dict": *trainDict != "",
+	}, *templatePath, vars, *otaImage, configSet))
+	if opts.AckWindow > 0 && !*stream {
+		err = errors.Join(err, errors.New("-ack-window 需要 -stream：只有流模式会让多帧同时在途"))
+	}
+	if len(opts.FilterRules) > 0 && (*repl || *verify || *resetPeer || *probe || *xmodemFile != "" || *ymodemFile != "" ||
+		*otaImage != "" || *configGet || len(configSet) > 0 || *trainDict != "") {
+		err = errors.Join(err, errors.New("-filter 只用于单条发送和 -stream：其他模式不发送遥测读数"))
+	}
+	if opts.Routes != "" && (*repl || *verify || *resetPeer || *probe || *xmodemFile != "" || *ymodemFile != "" ||
+		*stream || *otaImage != "" || *configGet || len(configSet) > 0 || *trainDict != "") {
+		err = errors.Join(err, errors.New("-routes 只用于单条发送：路由按该条消息的设备名选择串口"))
+	}
+	if err != nil {
+		log.Fatalf("参数无效:\n%v", err)
+	}
+	framing, err = opts.Framing.Codec(0, opts.Baud)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *trainDict != "" {
+		err := trainDictionaryFiles(*trainDict, flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// 定义原始消息
 	message := Message{
 		APIVersion:    "v3",
@@ -140,65 +676,636 @@ func main() {
 		ContentType:   "application/json",
 	}
 
-	// 序列化消息为JSON
-	data, err := json.Marshal(message)
+	// 使用模板生成消息，如 --template event.tmpl --set device=Boiler-1 --set value=42
+	if *templatePath != "" {
+		var err error
+		message, err = renderMessageTemplate(*templatePath, vars)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// 确认要求：linkNoAck为链路默认值，messageAck可对本条消息覆盖，
+	// 如遥测链路上的控制命令设为ackRequired，可靠链路上的遥测设为ackNone
+	linkNoAck := opts.LinkNoAck
+	messageAck, _ := opts.messageAck() // 已在validate中检查
+	message.NoAck = messageAck.noAck(linkNoAck)
+
+	// 使用设备私钥签名（私钥未配置时不签名），私钥可来自文件、环境变量或系统钥匙串
+	signKey, err := serialcomm.ParseKey(opts.SignKey, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signCert, err := serialcomm.ParseKey(opts.SignCert, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 逐条处理：按资源的死区和最小间隔过滤读数（-filter），所有读数都被过滤时不发送；
+	// 未指定关联ID时生成一个；为消息编号，接收端据此发现丢帧（序号文件为空时不编号）；
+	// 端到端校验在源头计算payload的CRC32，由最终接收端校验，中间桥接重新组帧不影响该字段
+	pipeline := messagePipeline{SequenceFile: opts.SequenceFile, EndToEndCRC: opts.EndToEndCRC}
+	pipeline.Filter, _ = opts.telemetryFilter() // 已在validate中检查
+	if signKey != nil {
+		pipeline.Signer = &signer{key: signKey, cert: signCert}
+	}
+	if !*stream { // 流模式不发送内置消息，只逐条处理标准输入的消息
+		send, err := pipeline.prepare(&message)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !send {
+			log.Println("读数未超出死区或间隔过短，本次不发送")
+			return
+		}
+	}
+
+	// 帧体编码：单条发送与流模式共用，键名缩短、压缩和加密的接收端需有相同的映射表、字典和密钥
+	encoder := frameEncoder{
+		Canonical:            opts.Canonical,
+		KeyMapVersion:        byte(opts.KeyMapVersion),  // 键名缩短，payload内嵌为JSON
+		CompressionThreshold: opts.CompressionThreshold, // EdgeX JSON在9600等低波特率下压缩收益明显，如 128
+		DictID:               byte(builtinDictID),       // 编号0为不带字典的普通DEFLATE
+		Dict:                 builtinDict,
+	}
+	trainedDictFile := opts.Dict // 用 -train-dict 训练的字典，编号需与接收端配置一致
+	if trainedDictFile != "" {
+		dict, err := os.ReadFile(trainedDictFile)
+		if err != nil {
+			log.Fatalf("读取字典失败: %v", err)
+		}
+		encoder.DictID, encoder.Dict = 2, dict
+	}
+
+	// 链路加密：线路经过物理上不安全的区域时，用预共享密钥对帧体做AES-GCM加密（接收端需配置相同密钥）
+	linkKey, err := serialcomm.ParseKey(opts.LinkKey, true)
 	if err != nil {
-		log.Fatalf("序列化消息失败: %v", err)
+		log.Fatal(err)
+	}
+	encoder.LinkKey = linkKey
+
+	// 透传模式：直接发送已序列化的消息信封（如桥接收到的原始帧），不解码再编码，只做压缩和加密
+	rawEnvelopeFile := opts.RawEnvelope
+	var envelope []byte
+	if rawEnvelopeFile != "" {
+		envelope, err = os.ReadFile(rawEnvelopeFile)
+		if err != nil {
+			log.Fatalf("读取原始信封失败: %v", err)
+		}
+		message, err = parseRawEnvelope("application/json", envelope)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("透传原始信封: %d字节", len(envelope))
+	}
+	// encodeMessage 编码要发送的消息，握手降级关闭压缩或键名缩短后重新编码
+	encodeMessage := func() []byte {
+		var data []byte
+		var err error
+		if envelope != nil {
+			data, err = encoder.seal(envelope, 0)
+		} else {
+			data, err = encoder.encode(message)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return data
+	}
+	data := encodeMessage()
+
+	// 帧认证：在帧体后追加HMAC-SHA256，接收端据此拒绝伪造的帧（接收端需配置相同密钥）
+	macKey, err := serialcomm.ParseKey(opts.MACKey, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if macKey != nil {
+		// 每帧重新读取密钥以支持轮换，缓存1分钟避免每帧都访问环境变量、文件或钥匙串
+		macKey = &serialcomm.CachedKey{Provider: macKey, TTL: time.Minute}
+		if _, err := serialcomm.HexKey(macKey); err != nil {
+			log.Fatal(err)
+		}
+		hmacCodec := serialcomm.HMACCodec{Key: macKey, Inner: framing}
+		if err := hmacCodec.Validate(); err != nil {
+			log.Fatalf("-mac-key: %v", err)
+		}
+		framing = hmacCodec
 	}
-	log.Printf("序列化后的JSON数据: %s", string(data))
 
 	// 配置串口1
 	config := &serial.Config{
-		Name:        "COM6", // 替换为你的串口1名称
-		Baud:        115200,
+		Name:        opts.Port,
+		Baud:        opts.Baud,
 		Parity:      serial.ParityNone,
 		ReadTimeout: 500 * time.Millisecond, // 设置默认读取超时
 	}
 
+	// 打开串口后等待对端稳定再发送，避免首帧在对端复位期间丢失
+	settings := openSettings{
+		DTR:           opts.DTR.level,
+		RTS:           opts.RTS.level,
+		SettleDelay:   opts.SettleDelay,
+		DiscardWindow: opts.DiscardWindow,
+	}
+
+	if *verify {
+		err := verifyLink(config, settings)
+		if err != nil {
+			log.Fatalf("串口检查失败: %v", err)
+		}
+		log.Printf("串口检查通过: %s %d波特", config.Name, config.Baud)
+		return
+	}
+
+	// 发送前钩子，依次等待时隙、唤醒电池供电的对端（-wake-gpio）、确认总线空闲
+	var hooks []preSendHook
+
+	// 共享总线上只允许在分配的时隙内发送，紧急消息（如告警）不受时隙限制
+	var window *transmitWindow
+	if opts.WindowPeriod > 0 {
+		window = &transmitWindow{Period: opts.WindowPeriod, Offset: opts.WindowOffset, Length: opts.WindowLength}
+	}
+	emergency := opts.Emergency
+	if window != nil {
+		hooks = append(hooks, window.hook(emergency))
+	}
+	if wake := opts.wakeHook(); wake != nil {
+		hooks = append(hooks, wake)
+	}
+
+	// RS-485半双工：发送前等待收发切换并确认总线空闲，避免与对端的回复冲突（接收端需同时配置回复前的切换时间）
+	var bus *halfDuplex
+	if opts.BusTurnaround > 0 {
+		bus = &halfDuplex{Turnaround: opts.BusTurnaround, IdleTime: opts.BusIdle, IdleTimeout: 2 * time.Second}
+	}
+	if bus != nil {
+		hooks = append(hooks, bus.hook())
+	}
+
+	// 发送数据并按失败类型重试
+	policy := retryPolicy{
+		Transport: serialcomm.Backoff{
+			Base:        500 * time.Millisecond,
+			Cap:         5 * time.Second,
+			Jitter:      0.2,
+			MaxAttempts: 3,
+			OnAttempt: func(attempt int, delay time.Duration, err error) {
+				log.Printf("传输错误: %v，%v后重开串口 (第%d次)", err, delay, attempt)
+			},
+			Clock: sysClock,
+			Rand:  randFloat,
+		},
+		MaxNackRetries:  2,
+		FeedbackTimeout: 3 * time.Second,
+		Feedback:        opts.feedback(),    // 旧固件可用 -ok-token ACK -retry-token NAK
+		LatencyBudget:   opts.LatencyBudget, // 控制命令可设置如 10s，过期即放弃
+		OnExpire: func(data []byte, elapsed time.Duration) {
+			log.Printf("消息 (%d字节) 在 %v 内未能送达，已放弃", len(data), elapsed.Round(time.Millisecond))
+		},
+		MaxFrameLength: opts.MaxFrameLength, // 与接收端的maxLength一致
+		FailoverAfter:  2,
+		OnFailover: func(from, to string) {
+			log.Printf("冷备切换: %s -> %s", from, to)
+		},
+	}
+
+	if opts.Standby != "" {
+		standby := *config
+		standby.Name = opts.Standby
+		policy.Standby = &standby
+	}
+
+	// MODBUS RTU分帧时反馈同样封装为RTU帧，不在共享总线上发送裸字符串
+	if c, ok := serialcomm.ModbusFraming(framing); ok {
+		policy.Feedback = policy.Feedback.wrap(c.WrapToken)
+	}
+
+	// 审计所有出站命令（谁、何时、发了什么、结果如何），可同时写文件和syslog：
+	// w, err := openSyslogAudit("serialjson"); audit.Writers = append(audit.Writers, w)
+	audit := &auditor{Operator: currentOperator(), Port: config.Name}
+	auditFile := opts.AuditFile // 为空时不写文件
+	if auditFile != "" {
+		w, err := openFileAudit(auditFile)
+		if err != nil {
+			log.Fatalf("启用审计失败: %v", err)
+		}
+		defer w.Close()
+		audit.Writers = append(audit.Writers, w)
+	}
+
+	// 失联隔离：连续多次发送失败后不再向该串口发送，直到收到对端的帧或用 -reset-peer 手动解除
+	peerStateFile := opts.PeerState // 为空时不隔离
+	health := peerHealth{
+		StateFile:    peerStateFile,
+		DeadAfter:    5,
+		ListenWindow: 2 * time.Second,
+		OnDead: func(port string, failures int) {
+			log.Printf("对端失联: %s 连续%d次发送失败，已隔离", port, failures)
+		},
+	}
+	if peerStateFile != "" {
+		policy.Health = &health
+	}
+	if *resetPeer {
+		if peerStateFile == "" {
+			log.Fatal("未配置对端状态文件")
+		}
+		err := health.reset(config.Name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("已解除 %s 的隔离", config.Name)
+		return
+	}
+
+	// 广播模式：并发发送到多个串口（如固件批量升级、全局配置下发），汇总每个串口的结果
+	broadcastPorts := opts.broadcastPorts()
+
+	// 路由模式：按消息的设备名和路由规则（-routes），选出带有对应标签的串口发送
+	var links []link
+	var rules []routeRule
+	if opts.Routes != "" {
+		links, rules, err = loadRoutes(opts.Routes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(links) > 0 {
+		device, err := messageDevice(message)
+		if err != nil {
+			log.Fatalf("无法确定消息的设备名: %v", err)
+		}
+		targets, err := route(links, rules, device)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, target := range targets {
+			broadcastPorts = append(broadcastPorts, target.Name)
+		}
+		log.Printf("设备 %s 路由到串口 %v", device, broadcastPorts)
+	}
+
+	// 没有配置路由规则时，可使用接收端从入站流量学习到的设备-串口路由表
+	routeTableFile := opts.RouteTable
+	if len(links) == 0 && routeTableFile != "" {
+		device, err := messageDevice(message)
+		if err != nil {
+			log.Fatalf("无法确定消息的设备名: %v", err)
+		}
+		learned, err := lookupLearnedRoute(routeTableFile, device)
+		if err != nil {
+			log.Fatal(err)
+		}
+		broadcastPorts = append(broadcastPorts, learned)
+		log.Printf("设备 %s 按学习到的路由发往 %s", device, learned)
+	}
+
+	if len(broadcastPorts) > 0 {
+		var configs []*serial.Config
+		for _, name := range broadcastPorts {
+			c := *config
+			c.Name = name
+			configs = append(configs, &c)
+		}
+		var failed int
+		for _, result := range broadcast(configs, settings, data, hooks, policy) {
+			portAudit := *audit
+			portAudit.Port = result.Port
+			if auditErr := portAudit.record(message, len(data), result.Err); auditErr != nil {
+				log.Print(auditErr)
+			}
+			if result.Err != nil {
+				failed++
+				log.Printf("广播到 %s 失败: %v", result.Port, result.Err)
+			} else {
+				log.Printf("广播到 %s 成功", result.Port)
+			}
+		}
+		log.Printf("广播完成: %d个串口成功，%d个失败", len(broadcastPorts)-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 打开串口
-	port, err := serial.OpenPort(config)
+	port, err := openPort(config, settings)
 	if err != nil {
-		log.Fatalf("无法打开串口: %v", err)
+		log.Fatal(err)
+	}
+	defer func() {
+		if port != nil {
+			closePort(port, config.Name)
+		}
+	}()
+
+	// 握手：先与接收端交换协议能力，本端使用了对端不支持的版本头、字典或映射表时立即报错，
+	// 不必等到数据帧被反复拒绝才发现两端配置不一致；最大帧长按两端的较小值分片。
+	// -allow-downgrade 时改为关闭该特性或改用较低的帧版本；对端固件低于 -require-firmware 的要求时
+	// 自动关闭对应特性，两者都输出降级警告
+	handshakeEnabled := opts.Handshake // 旧版本接收端不支持握手，会在反馈超时后报错
+	if handshakeEnabled {
+		local := capabilities{
+			Versions: codecVersions(framing),
+			MaxFrame: policy.MaxFrameLength,
+			Dicts:    encoder.dicts(),
+			KeyMaps:  encoder.keyMaps(),
+			CRC:      []string{crcAlgorithm},
+		}
+		allowlist, _ := opts.peerAllowlist() // 已在validate中检查
+		gates, _ := opts.firmwareGates()
+		negotiation := negotiationPolicy{
+			AllowDowngrade: opts.Downgrade,
+			Versions:       acceptedVersions(framing),
+			Gates:          gates,
+			OnPeerIdentified: func(identity *peerIdentity) error {
+				if identity != nil {
+					log.Printf("对端身份: 序列号 %q，型号 %q，固件 %q", identity.Serial, identity.Model, identity.Firmware)
+				}
+				return checkAllowlist(allowlist, identity)
+			},
+		}
+		agreed, downgrades, err := handshake(port, local, encoder.LinkKey, policy.FeedbackTimeout, negotiation)
+		if err != nil {
+			log.Fatalf("握手失败: %v", err)
+		}
+		for _, d := range downgrades {
+			d.report(config.Name)
+			switch d.Feature {
+			case featureVersion:
+				framing = withSendVersion(framing, byte(agreed.Versions[0]))
+			case featureCompression:
+				encoder.CompressionThreshold = 0
+			case featureKeyMap:
+				encoder.KeyMapVersion = 0
+			}
+		}
+		if len(downgrades) > 0 {
+			data = encodeMessage()
+		}
+		policy.MaxFrameLength = agreed.MaxFrame
+		log.Printf("握手完成: %+v", agreed)
+	}
+
+	// 流模式：持续读取消息并按优先级发送，与单条发送一样经过编号、端到端校验、签名、键名缩短、压缩、加密和失联隔离，
+	// 每条消息的noAck决定是否等待确认，未给出时沿用链路默认值
+	if *stream {
+		queue := newSendQueue()
+
+		// 滑动窗口：最多ackWindow帧在途，接收端按序号累积确认，高波特率下不再受逐帧往返时间限制
+		var acks *ackWindow
+		if opts.AckWindow > 0 {
+			if _, ok := serialcomm.ModbusFraming(framing); ok {
+				log.Fatal("MODBUS RTU分帧不支持滑动窗口：总线上的从站须逐帧应答")
+			}
+			acks = newAckWindow(opts.AckWindow, policy.FeedbackTimeout, policy.MaxNackRetries)
+			acks.Hooks = hooks
+			if opts.Coalesce > 0 {
+				acks.Coalesce = opts.Coalesce
+				queue.Linger = opts.Coalesce
+				queue.Idle = func() {
+					if acks.Port == nil {
+						return
+					}
+					if err := acks.idle(); err != nil {
+						log.Printf("写出合并的帧失败: %v", err)
+						if errors.Is(err, serialcomm.ErrPort) {
+							port.Close()
+							port, acks.Port = nil, nil
+						}
+					}
+				}
+			}
+			if policy.Health != nil {
+				acks.OnResult = func(err error) {
+					if healthErr := policy.Health.record(config.Name, err); healthErr != nil {
+						log.Print(healthErr)
+					}
+				}
+			}
+		}
+		queue.Backoff = &serialcomm.Backoff{
+			Base:   time.Second,
+			Cap:    time.Minute,
+			Jitter: 0.2,
+			OnAttempt: func(attempt int, delay time.Duration, err error) {
+				log.Printf("串口不可用，%v后继续发送排队的消息 (第%d次)", delay, attempt)
+			},
+			Clock: sysClock,
+			Rand:  randFloat,
+		}
+		finished := make(chan struct{})
+		go func() {
+			queue.run(func(data []byte, noAck bool, done chan<- error) error {
+				if acks != nil {
+					var err error
+					port, err = sendWindowed(acks, port, config, settings, data, policy, done)
+					return err
+				}
+				queuedPolicy := policy
+				queuedPolicy.NoAck = noAck
+				var err error
+				port, err = sendWithRetry(port, config, settings, data, hooks, queuedPolicy)
+				done <- err
+				return err
+			})
+			if acks != nil {
+				if err := acks.drain(); err != nil {
+					log.Printf("等待在途消息确认失败: %v", err)
+				}
+			}
+			close(finished)
+		}()
+
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		var pending sync.WaitGroup
+		for scanner.Scan() {
+			var queued Message
+			err := json.Unmarshal(scanner.Bytes(), &queued)
+			if err != nil {
+				log.Printf("跳过无效的消息: %v", err)
+				continue
+			}
+			var ack struct {
+				NoAck *bool `json:"noAck"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &ack) == nil && ack.NoAck == nil {
+				queued.NoAck = linkNoAck
+			}
+			if acks != nil {
+				queued.NoAck = false // 窗口帧总是由累积确认覆盖
+			}
+			send, err := pipeline.prepare(&queued)
+			if err != nil {
+				log.Printf("跳过消息 %s: %v", queued.CorrelationID, err)
+				continue
+			}
+			if !send {
+				log.Printf("消息 %s 的读数未超出死区或间隔过短，不发送", queued.CorrelationID)
+				continue
+			}
+			data, err := encoder.encode(queued)
+			if err != nil {
+				log.Printf("跳过消息 %s: %v", queued.CorrelationID, err)
+				continue
+			}
+			done := queue.enqueue(data, queued.Priority, queued.NoAck, opts.orderingDomain(queued))
+			pending.Add(1)
+			go func() {
+				defer pending.Done()
+				err := <-done
+				if auditErr := audit.record(queued, len(data), err); auditErr != nil {
+					log.Print(auditErr)
+				}
+				if err != nil {
+					log.Printf("发送消息 %s (优先级%d) 失败: %v", queued.CorrelationID, queued.Priority, err)
+				}
+			}()
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("读取标准输入失败: %v", err)
+		}
+		queue.close()
+		<-finished
+		pending.Wait()
+		return
 	}
-	defer port.Close()
 
-	// 清空串口缓冲区
-	port.Flush()
+	// OTA升级：推送镜像、核对对端哈希、提交切换，等待新固件应答握手后核对其运行的镜像
+	if *otaImage != "" {
+		image, err := os.ReadFile(*otaImage)
+		if err != nil {
+			log.Fatalf("读取固件镜像失败: %v", err)
+		}
+		update := otaUpdate{
+			Port:          port,
+			Transfer:      xmodemSender{Port: port, OneK: true, Retries: 10, StartupWait: time.Minute},
+			Config:        configClient{Port: port, Timeout: policy.FeedbackTimeout, MaxRetries: policy.MaxNackRetries, Key: linkKey},
+			Hello:         capabilities{Versions: codecVersions(framing), MaxFrame: policy.MaxFrameLength, CRC: []string{crcAlgorithm}},
+			RebootTimeout: 2 * time.Minute,
+			Reopen: func() (*serial.Port, error) {
+				reopened, err := openPort(config, settings)
+				if err != nil {
+					return nil, err
+				}
+				port = reopened
+				return reopened, nil
+			},
+		}
+		result, err := update.run(*otaImage, image)
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// 发送数据并监听重传请求
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if attempt == maxRetries {
-			log.Fatalf("达到最大重试次数 (%d)，发送失败", maxRetries)
+	// 文件传输模式：与现有bootloader或终端工具对接，使用它们的协议而不是本协议的帧格式
+	if *xmodemFile != "" || *ymodemFile != "" {
+		sender := xmodemSender{Port: port, OneK: true, Retries: 10, StartupWait: time.Minute}
+		path := *xmodemFile
+		if path == "" {
+			path = *ymodemFile
+		}
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("读取文件失败: %v", err)
+		}
+		if *ymodemFile != "" {
+			err = sender.sendFile(filepath.Base(path), fileData)
+		} else {
+			err = sender.send(fileData)
 		}
-		log.Printf("尝试发送数据 (第%d/%d次)", attempt, maxRetries)
-		err = sendData(port, data)
 		if err != nil {
-			log.Fatalf("发送数据失败: %v", err)
+			log.Fatalf("文件传输失败: %v", err)
 		}
+		log.Printf("文件传输完成: %s (%d字节)", path, len(fileData))
+		return
+	}
 
-		// 监听接收端的反馈（等待3秒）
-		feedback, err := readFeedback(port, 3*time.Second)
+	if *repl {
+		// 交互模式下长时间等待输入，空闲时发送心跳让接收端知道链路仍在线
+		heartbeatInterval := opts.Heartbeat // 0表示不发送心跳
+		var hb *heartbeat
+		if heartbeatInterval > 0 {
+			hb = &heartbeat{Interval: heartbeatInterval}
+		}
+		runREPL(port, message, policy, audit, hb)
+		return
+	}
+
+	if *probe {
+		results, err := runProbe(port, probeChecks(data), policy)
+		failed := printProbeReport(os.Stdout, results)
 		if err != nil {
-			log.Printf("读取反馈失败: %v", err)
-			port.Flush() // 清空缓冲区以避免残留数据
-			continue
+			log.Fatal(err)
+		}
+		if failed > 0 {
+			log.Fatalf("合规探测未通过 %d 项", failed)
 		}
-		log.Printf("接收到反馈: %q (字节数: %d)", feedback, len(feedback))
+		return
+	}
 
-		if feedback == "OK" {
-			log.Println("数据发送成功，收到确认")
-			break
-		} else if feedback == "RETRY" {
-			log.Printf("接收端请求重传，尝试第%d次", attempt+1)
-			port.Flush() // 清空缓冲区以避免残留数据
-			continue
+	// 读取或修改对端设备的配置
+	if *configGet || len(configSet) > 0 {
+		client := configClient{Port: port, Timeout: policy.FeedbackTimeout, MaxRetries: policy.MaxNackRetries, Key: linkKey}
+		var reply configReply
+		if len(configSet) > 0 {
+			reply, err = updatePeerConfig(client, configSet)
 		} else {
-			log.Printf("收到未知反馈: %q，尝试第%d次", feedback, attempt+1)
-			port.Flush() // 清空缓冲区以避免残留数据
-			continue
+			reply, err = client.get()
 		}
+		if err != nil {
+			log.Fatalf("对端配置操作失败: %v", err)
+		}
+		out, _ := json.MarshalIndent(reply, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
 
+	// 配置下发模式：配对码非空时先向出厂设备下发密钥（接收端需同时开启配置模式）
+	pairingCode := opts.PairingCode
+	provisionKeyFiles := []string(opts.ProvisionKeys) // 下发给接收端信任的公钥，通常为本机签名公钥
+	provisionCAFile := opts.ProvisionCA
+	if pairingCode != "" {
+		bundle, err := loadProvisionBundle(provisionKeyFiles, provisionCAFile)
+		if err != nil {
+			log.Fatalf("加载下发内容失败: %v", err)
+		}
+		provisionMessage, err := buildProvisionMessage(pairingCode, bundle)
+		if err != nil {
+			log.Fatalf("构建配置下发消息失败: %v", err)
+		}
+		// 与普通消息一样编码，开启链路加密的接收端拒绝明文的配置下发消息
+		provisionData, err := encoder.encode(provisionMessage)
+		if err != nil {
+			log.Fatalf("编码配置下发消息失败: %v", err)
+		}
+		port, err = sendWithRetry(port, config, settings, provisionData, hooks, policy)
+		if auditErr := audit.record(provisionMessage, len(provisionData), err); auditErr != nil {
+			log.Fatal(auditErr)
+		}
+		if err != nil {
+			log.Fatalf("配置下发失败: %v", err)
+		}
+		log.Println("配置下发完成")
+	}
+
+	messagePolicy := policy
+	messagePolicy.NoAck = message.NoAck
+	port, err = sendWithRetry(port, config, settings, data, hooks, messagePolicy)
+	if auditErr := audit.record(message, len(data), err); auditErr != nil {
+		log.Fatal(auditErr)
+	}
+	if err != nil {
+		log.Fatalf("发送失败: %v", err)
+	}
+	if message.NoAck {
+		log.Println("数据已发送，无需确认")
+	} else {
+		log.Println("数据发送成功，收到确认")
 	}
 
 	log.Println("所有数据发送完成")
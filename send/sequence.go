@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// nextSequence 从状态文件读取上次使用的序号并加一写回，使序号在进程重启后保持递增
+func nextSequence(stateFile string) (uint64, error) {
+	var last uint64
+	data, err := os.ReadFile(stateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("读取序号文件失败: %v", err)
+	}
+	if len(data) > 0 {
+		last, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("序号文件 %s 内容无效: %v", stateFile, err)
+		}
+	}
+
+	next := last + 1
+	err = os.WriteFile(stateFile, []byte(strconv.FormatUint(next, 10)+"\n"), 0644)
+	if err != nil {
+		return 0, fmt.Errorf("写入序号文件失败: %v", err)
+	}
+	return next, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"send/internal/serialcomm"
+)
+
+// signer 使用设备Ed25519私钥对消息负载签名，每次签名都从提供者获取密钥以支持轮换
+type signer struct {
+	key  serialcomm.KeyProvider // PKCS#8 PEM格式的Ed25519私钥
+	cert serialcomm.KeyProvider // 可选的设备证书（PEM），随消息发送供接收端用CA校验
+}
+
+// sign 对消息的Payload签名，并写入Signature和Certificate字段
+func (s *signer) sign(message *Message) error {
+	keyPEM, err := s.key.Key()
+	if err != nil {
+		return fmt.Errorf("获取私钥失败: %v", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("私钥不是PEM格式")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("解析私钥失败: %v", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return fmt.Errorf("私钥类型 %T 不是Ed25519", key)
+	}
+
+	signature := ed25519.Sign(edKey, []byte(message.Payload))
+	message.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	if s.cert != nil {
+		certPEM, err := s.cert.Key()
+		if err != nil {
+			return fmt.Errorf("获取证书失败: %v", err)
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			return fmt.Errorf("证书不是PEM格式")
+		}
+		message.Certificate = base64.StdEncoding.EncodeToString(block.Bytes)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// setFlags 收集重复的 --set key=value 参数
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	var parts []string
+	for k, v := range s {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s setFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("参数 %q 不是 key=value 格式", value)
+	}
+	s[key] = val
+	return nil
+}
+
+// templateFuncs 模板中可用的辅助函数
+var templateFuncs = template.FuncMap{
+	// base64 对字符串做base64编码，用于生成payload
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	// json 将值编码为JSON字面量，插入字符串参数时避免引号和转义问题
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// uuid 生成新的UUID，用于id、correlationID等字段
+	"uuid": newUUID,
+	// now 返回当前Unix纳秒时间戳，与EdgeX的origin字段一致
+	"now": func() int64 { return time.Now().UnixNano() },
+}
+
+// renderMessageTemplate 用 --set 参数渲染消息模板，模板输出必须是Message的JSON
+// 模板中通过 {{.device}} 引用参数，嵌套的payload可以用 {{define "event"}} 定义，
+// 再以 {{base64 (include "event" .)}} 渲染并编码
+func renderMessageTemplate(path string, vars setFlags) (Message, error) {
+	tmpl := template.New(filepath.Base(path)).Funcs(templateFuncs).Option("missingkey=error")
+	tmpl.Funcs(template.FuncMap{
+		"include": func(name string, data any) (string, error) {
+			var buf bytes.Buffer
+			err := tmpl.ExecuteTemplate(&buf, name, data)
+			return buf.String(), err
+		},
+	})
+	tmpl, err := tmpl.ParseFiles(path)
+	if err != nil {
+		return Message{}, fmt.Errorf("解析模板失败: %v", err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string(vars))
+	if err != nil {
+		return Message{}, fmt.Errorf("渲染模板失败: %v", err)
+	}
+
+	var message Message
+	err = json.Unmarshal(buf.Bytes(), &message)
+	if err != nil {
+		return Message{}, fmt.Errorf("模板输出不是有效的消息JSON: %v\n%s", err, buf.Bytes())
+	}
+	return message, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// preSendHook 在每次发送数据前执行，返回错误时放弃本次发送
+type preSendHook func(port *serial.Port) error
+
+// runPreSendHooks 依次执行发送前钩子
+func runPreSendHooks(port *serial.Port, hooks []preSendHook) error {
+	for i, hook := range hooks {
+		if err := hook(port); err != nil {
+			return fmt.Errorf("第%d个发送前钩子失败: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// gpioPin 由使用方提供的GPIO引脚实现（如sysfs、gpiod或板级SDK）
+type gpioPin interface {
+	Set(high bool) error
+	Get() (bool, error)
+}
+
+// sysfsPin 通过sysfs访问的GPIO引脚，引脚须已导出（/sys/class/gpio/export）并设置好方向
+type sysfsPin struct {
+	Path string // 引脚的value文件，如 /sys/class/gpio/gpio17/value
+}
+
+// newSysfsPin 返回编号为number的sysfs GPIO引脚
+func newSysfsPin(number int) sysfsPin {
+	return sysfsPin{Path: fmt.Sprintf("/sys/class/gpio/gpio%d/value", number)}
+}
+
+func (p sysfsPin) Set(high bool) error {
+	value := []byte("0")
+	if high {
+		value = []byte("1")
+	}
+	return os.WriteFile(p.Path, value, 0)
+}
+
+func (p sysfsPin) Get() (bool, error) {
+	value, err := os.ReadFile(p.Path)
+	if err != nil {
+		return false, err
+	}
+	return string(bytes.TrimSpace(value)) == "1", nil
+}
+
+// gpioWake 描述发送前的唤醒序列：唤醒脚输出一个脉冲，然后等待就绪脚变高
+type gpioWake struct {
+	Wake         gpioPin       // 唤醒脚
+	Ready        gpioPin       // 就绪脚，为nil时仅等待ReadyDelay
+	Pulse        time.Duration // 唤醒脉冲宽度
+	ReadyDelay   time.Duration // 没有就绪脚时脉冲后的固定等待时间
+	ReadyTimeout time.Duration // 等待就绪脚的超时时间
+}
+
+// hook 将唤醒序列包装为发送前钩子
+func (w gpioWake) hook() preSendHook {
+	return func(port *serial.Port) error {
+		if err := w.Wake.Set(true); err != nil {
+			return fmt.Errorf("拉高唤醒脚失败: %v", err)
+		}
+		sysClock.Sleep(w.Pulse)
+		if err := w.Wake.Set(false); err != nil {
+			return fmt.Errorf("拉低唤醒脚失败: %v", err)
+		}
+
+		if w.Ready == nil {
+			sysClock.Sleep(w.ReadyDelay)
+			return nil
+		}
+
+		start := sysClock.Now()
+		for sysClock.Since(start) < w.ReadyTimeout {
+			ready, err := w.Ready.Get()
+			if err != nil {
+				return fmt.Errorf("读取就绪脚失败: %v", err)
+			}
+			if ready {
+				log.Printf("对端已唤醒，耗时 %v", sysClock.Since(start))
+				return nil
+			}
+			sysClock.Sleep(5 * time.Millisecond)
+		}
+		return fmt.Errorf("等待对端就绪超时 (%v)", w.ReadyTimeout)
+	}
+}
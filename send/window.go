@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// transmitWindow 时分复用（TDMA）发送时隙：每个Period内从Offset开始、持续Length的时间段允许发送
+type transmitWindow struct {
+	Period time.Duration // 时隙周期，从Unix纪元起对齐
+	Offset time.Duration // 本站时隙在周期内的起始偏移
+	Length time.Duration // 本站时隙长度
+}
+
+// untilOpen 返回距离下一个时隙开始的时间，当前已在时隙内时返回0
+func (w transmitWindow) untilOpen(now time.Time) time.Duration {
+	pos := time.Duration(now.UnixNano()) % w.Period
+	start := w.Offset
+	end := w.Offset + w.Length
+	if pos >= start && pos < end {
+		return 0
+	}
+	if pos < start {
+		return start - pos
+	}
+	return w.Period - pos + start
+}
+
+// hook 将时隙等待包装为发送前钩子；emergency为true时（如告警）跳过等待立即发送
+func (w transmitWindow) hook(emergency bool) preSendHook {
+	return func(port *serial.Port) error {
+		if emergency {
+			return nil
+		}
+		wait := w.untilOpen(sysClock.Now())
+		if wait > 0 {
+			log.Printf("等待发送时隙开启 %v", wait)
+			sysClock.Sleep(wait)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/sigurn/crc16"
+
+	"send/internal/serialcomm"
+)
+
+// XMODEM/YMODEM控制字节
+const (
+	xmodemSOH = 0x01 // 128字节数据块
+	xmodemSTX = 0x02 // 1024字节数据块
+	xmodemEOT = 0x04
+	xmodemACK = 0x06
+	xmodemNAK = 0x15
+	xmodemCAN = 0x18
+	xmodemSUB = 0x1A // 最后一块的填充字节
+	xmodemC   = 'C'  // 接收端请求CRC模式
+)
+
+var xmodemTable = crc16.MakeTable(crc16.CRC16_XMODEM)
+
+// xmodemSender 以XMODEM-CRC或YMODEM发送文件，用于与现有的bootloader和终端工具对接；
+// 与本协议的帧格式无关，传输期间串口上不能有其他流量
+type xmodemSender struct {
+	Port        io.ReadWriter
+	OneK        bool          // 使用1024字节数据块（XMODEM-1K），YMODEM总是使用
+	Retries     int           // 每块的最大重发次数
+	StartupWait time.Duration // 等待接收端发出'C'的时间
+}
+
+// send 以XMODEM-CRC发送数据
+func (s xmodemSender) send(data []byte) error {
+	err := s.waitStart()
+	if err != nil {
+		return err
+	}
+	err = s.sendBlocks(data, s.OneK)
+	if err != nil {
+		return err
+	}
+	return s.finish()
+}
+
+// sendFile 以YMODEM发送单个文件：0号块携带文件名和大小，传输结束后发送空的0号块结束批次
+func (s xmodemSender) sendFile(name string, data []byte) error {
+	err := s.waitStart()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 128)
+	copy(header, name+"\x00"+strconv.Itoa(len(data)))
+	err = s.sendBlock(0, header)
+	if err != nil {
+		return fmt.Errorf("发送文件头失败: %v", err)
+	}
+	err = s.waitStart()
+	if err != nil {
+		return err
+	}
+	err = s.sendBlocks(data, true)
+	if err != nil {
+		return err
+	}
+	err = s.finish()
+	if err != nil {
+		return err
+	}
+	err = s.waitStart()
+	if err != nil {
+		return err
+	}
+	return s.sendBlock(0, make([]byte, 128))
+}
+
+// sendBlocks 按块发送数据，块号从1开始，最后一块用SUB填充
+func (s xmodemSender) sendBlocks(data []byte, oneK bool) error {
+	size := 128
+	if oneK {
+		size = 1024
+	}
+	for i := 0; i*size < len(data); i++ {
+		block := bytes.Repeat([]byte{xmodemSUB}, size)
+		copy(block, data[i*size:])
+		err := s.sendBlock(byte(i+1), block)
+		if err != nil {
+			return fmt.Errorf("发送第%d块失败: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// sendBlock 发送一块并等待确认，NAK或超时后重发
+func (s xmodemSender) sendBlock(num byte, block []byte) error {
+	header := byte(xmodemSOH)
+	if len(block) == 1024 {
+		header = xmodemSTX
+	}
+	packet := append([]byte{header, num, 0xFF - num}, block...)
+	packet = binary.BigEndian.AppendUint16(packet, crc16.Checksum(block, xmodemTable))
+
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		_, err := s.Port.Write(packet)
+		if err != nil {
+			return serialcomm.PortError("写入数据块失败", err)
+		}
+		reply, err := xmodemReadByte(s.Port, 10*time.Second)
+		switch {
+		case err != nil && !errors.Is(err, serialcomm.ErrTimeout):
+			return err
+		case reply == xmodemACK:
+			return nil
+		case reply == xmodemCAN:
+			return &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: errors.New("接收端取消了传输")}
+		}
+		log.Printf("数据块 %d 未确认 (%#x)，重发", num, reply)
+	}
+	return &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: fmt.Errorf("数据块 %d 重发%d次仍未确认", num, s.Retries)}
+}
+
+// waitStart 等待接收端请求CRC模式
+func (s xmodemSender) waitStart() error {
+	deadline := sysClock.Now().Add(s.StartupWait)
+	for sysClock.Now().Before(deadline) {
+		b, err := xmodemReadByte(s.Port, time.Second)
+		switch {
+		case err != nil && !errors.Is(err, serialcomm.ErrTimeout):
+			return err
+		case err == nil && b == xmodemC:
+			return nil
+		case err == nil && b == xmodemCAN:
+			return &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: errors.New("接收端取消了传输")}
+		}
+	}
+	return &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: fmt.Errorf("%v内未收到接收端的CRC模式请求", s.StartupWait)}
+}
+
+// finish 发送EOT直到接收端确认，YMODEM接收端通常先回复NAK
+func (s xmodemSender) finish() error {
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		_, err := s.Port.Write([]byte{xmodemEOT})
+		if err != nil {
+			return serialcomm.PortError("写入EOT失败", err)
+		}
+		reply, err := xmodemReadByte(s.Port, 10*time.Second)
+		if err != nil && !errors.Is(err, serialcomm.ErrTimeout) {
+			return err
+		}
+		if reply == xmodemACK {
+			return nil
+		}
+	}
+	return &serialcomm.LinkError{Kind: serialcomm.ErrNack, Err: errors.New("EOT未被确认")}
+}
+
+// xmodemReadByte 读取一个字节，超时返回serialcomm.ErrTimeout类别的错误
+func xmodemReadByte(r io.Reader, timeout time.Duration) (byte, error) {
+	b := make([]byte, 1)
+	start := sysClock.Now()
+	for sysClock.Since(start) < timeout {
+		n, err := r.Read(b)
+		if n == 1 {
+			return b[0], nil
+		}
+		if err != nil && err != io.EOF { // 读超时在部分平台上表现为EOF
+			return 0, serialcomm.PortError("读取失败", err)
+		}
+		sysClock.Sleep(10 * time.Millisecond) // 防止CPU过载
+	}
+	return 0, &serialcomm.LinkError{Kind: serialcomm.ErrTimeout, Err: fmt.Errorf("%v内未收到数据", timeout)}
+}